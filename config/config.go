@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -9,7 +11,62 @@ import (
 type Config struct {
 	Port        string
 	Environment string
+	// Postgres URL, or sqlite://path or :memory: for a local SQLite database
 	DatabaseURL string
+	// Run GORM AutoMigrate at startup; development only, use `migrate up` otherwise
+	AutoMigrate bool
+
+	// Deadline for handling a single request, including its database queries; 0 disables it
+	RequestTimeout time.Duration
+
+	// Public URL of the web client, used to build links in emails
+	AppBaseURL string
+
+	// First admin account, promoted or created at startup if set
+	BootstrapAdminEmail    string
+	BootstrapAdminPassword string
+
+	// Where a verified email is required: "off" (default), "conversations" or "login"
+	EmailVerification string
+
+	// Login throttling state: "postgres" (default, stored in the database and shared
+	// by all instances, SQLite included) or "memory"
+	ThrottleStore string
+	// Per-account lockout after repeated failed logins
+	LoginMaxFailures int
+	LoginLockoutBase time.Duration
+	LoginLockoutMax  time.Duration
+	// Per-IP request limits
+	LoginRateLimit     int
+	LoginRateWindow    time.Duration
+	RegisterRateLimit  int
+	RegisterRateWindow time.Duration
+	// Applied separately to password reset requests and to resets
+	PasswordResetRateLimit  int
+	PasswordResetRateWindow time.Duration
+
+	// Attachment content storage: "local" (default, files under AttachmentDir)
+	BlobStore     string
+	AttachmentDir string
+	// Largest accepted upload, and total size of a user's attachments
+	MaxAttachmentBytes   int64
+	AttachmentQuotaBytes int64
+	// Lifetime of the signed download URLs of attachments
+	AttachmentURLTTL time.Duration
+
+	// Streamed AI messages: how often their partial content is saved, and how
+	// long one may go without a chunk before it is marked failed
+	StreamFlushInterval time.Duration
+	StreamIdleTimeout   time.Duration
+
+	// Mail delivery: "log" (default) or "smtp"
+	Mailer       string
+	MailFrom     string
+	MailLogFile  string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
 }
 
 func Load() *Config {
@@ -20,6 +77,45 @@ func Load() *Config {
 		Port:        getEnv("PORT", "8080"),
 		Environment: getEnv("ENVIRONMENT", "development"),
 		DatabaseURL: getEnv("DATABASE_URL", ""),
+		AutoMigrate: getBoolEnv("DB_AUTO_MIGRATE", false),
+
+		RequestTimeout: getDurationEnv("REQUEST_TIMEOUT", 30*time.Second),
+
+		AppBaseURL: getEnv("APP_BASE_URL", "http://localhost:3000"),
+
+		BootstrapAdminEmail:    getEnv("BOOTSTRAP_ADMIN_EMAIL", ""),
+		BootstrapAdminPassword: getEnv("BOOTSTRAP_ADMIN_PASSWORD", ""),
+
+		EmailVerification: getEnv("EMAIL_VERIFICATION", "off"),
+
+		ThrottleStore:      getEnv("THROTTLE_STORE", "postgres"),
+		LoginMaxFailures:   getIntEnv("LOGIN_MAX_FAILURES", 5),
+		LoginLockoutBase:   getDurationEnv("LOGIN_LOCKOUT_BASE", time.Minute),
+		LoginLockoutMax:    getDurationEnv("LOGIN_LOCKOUT_MAX", time.Hour),
+		LoginRateLimit:     getIntEnv("LOGIN_RATE_LIMIT", 20),
+		LoginRateWindow:    getDurationEnv("LOGIN_RATE_WINDOW", time.Minute),
+		RegisterRateLimit:  getIntEnv("REGISTER_RATE_LIMIT", 10),
+		RegisterRateWindow: getDurationEnv("REGISTER_RATE_WINDOW", time.Hour),
+
+		PasswordResetRateLimit:  getIntEnv("PASSWORD_RESET_RATE_LIMIT", 10),
+		PasswordResetRateWindow: getDurationEnv("PASSWORD_RESET_RATE_WINDOW", time.Hour),
+
+		BlobStore:            getEnv("BLOB_STORE", "local"),
+		AttachmentDir:        getEnv("ATTACHMENT_DIR", "data/attachments"),
+		MaxAttachmentBytes:   getInt64Env("MAX_ATTACHMENT_BYTES", 10<<20),
+		AttachmentQuotaBytes: getInt64Env("ATTACHMENT_QUOTA_BYTES", 100<<20),
+		AttachmentURLTTL:     getDurationEnv("ATTACHMENT_URL_TTL", 15*time.Minute),
+
+		StreamFlushInterval: getDurationEnv("STREAM_FLUSH_INTERVAL", time.Second),
+		StreamIdleTimeout:   getDurationEnv("STREAM_IDLE_TIMEOUT", 5*time.Minute),
+
+		Mailer:       getEnv("MAILER", "log"),
+		MailFrom:     getEnv("MAIL_FROM", "no-reply@localhost"),
+		MailLogFile:  getEnv("MAIL_LOG_FILE", ""),
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
 	}
 }
 
@@ -29,3 +125,31 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getIntEnv(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getInt64Env(key string, defaultValue int64) int64 {
+	if value, err := strconv.ParseInt(os.Getenv(key), 10, 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getBoolEnv(key string, defaultValue bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
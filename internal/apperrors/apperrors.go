@@ -0,0 +1,176 @@
+// Package apperrors defines the typed errors returned by the repository and
+// service layers. Each error carries a kind, which decides the HTTP status,
+// and a stable machine-readable code that clients can match on.
+package apperrors
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Kind classifies an error
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindBadRequest
+	KindValidation
+	KindUnauthorized
+	KindForbidden
+	KindNotFound
+	KindConflict
+	KindTooLarge
+	KindUnsupportedMediaType
+	KindLocked
+	KindTooManyRequests
+	KindTimeout
+)
+
+// FieldError describes a problem with a single request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is an application error with a stable code
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	// Fields lists per-field problems for validation errors
+	Fields []FieldError
+	// RetryAfter is set for locked and rate limited errors
+	RetryAfter time.Duration
+	// Err is the underlying cause, if any
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches sentinel errors: a target without a code matches any error of
+// the same kind, otherwise codes must be equal
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	if t.Code == "" {
+		return t.Kind == e.Kind
+	}
+	return t.Code == e.Code
+}
+
+// Status returns the HTTP status code for the error
+func (e *Error) Status() int {
+	switch e.Kind {
+	case KindBadRequest, KindValidation:
+		return http.StatusBadRequest
+	case KindUnauthorized:
+		return http.StatusUnauthorized
+	case KindForbidden:
+		return http.StatusForbidden
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindConflict:
+		return http.StatusConflict
+	case KindTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case KindUnsupportedMediaType:
+		return http.StatusUnsupportedMediaType
+	case KindLocked:
+		return http.StatusLocked
+	case KindTooManyRequests:
+		return http.StatusTooManyRequests
+	case KindTimeout:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Sentinels for matching by kind with errors.Is
+var (
+	ErrBadRequest      = &Error{Kind: KindBadRequest}
+	ErrValidation      = &Error{Kind: KindValidation}
+	ErrUnauthorized    = &Error{Kind: KindUnauthorized}
+	ErrForbidden       = &Error{Kind: KindForbidden}
+	ErrNotFound        = &Error{Kind: KindNotFound}
+	ErrConflict        = &Error{Kind: KindConflict}
+	ErrTooLarge        = &Error{Kind: KindTooLarge}
+	ErrUnsupportedType = &Error{Kind: KindUnsupportedMediaType}
+	ErrLocked          = &Error{Kind: KindLocked}
+	ErrTooManyRequests = &Error{Kind: KindTooManyRequests}
+	ErrTimeout         = &Error{Kind: KindTimeout}
+)
+
+// BadRequest creates an error for a malformed or unacceptable request
+func BadRequest(code, message string) *Error {
+	return &Error{Kind: KindBadRequest, Code: code, Message: message}
+}
+
+// Validation creates an error for invalid request fields
+func Validation(message string, fields ...FieldError) *Error {
+	return &Error{Kind: KindValidation, Code: "validation_failed", Message: message, Fields: fields}
+}
+
+// Unauthorized creates an error for missing or invalid credentials
+func Unauthorized(code, message string) *Error {
+	return &Error{Kind: KindUnauthorized, Code: code, Message: message}
+}
+
+// Forbidden creates an error for an authenticated caller lacking permission
+func Forbidden(code, message string) *Error {
+	return &Error{Kind: KindForbidden, Code: code, Message: message}
+}
+
+// NotFound creates an error for a missing resource
+func NotFound(code, message string) *Error {
+	return &Error{Kind: KindNotFound, Code: code, Message: message}
+}
+
+// Conflict creates an error for a request that conflicts with existing state
+func Conflict(code, message string) *Error {
+	return &Error{Kind: KindConflict, Code: code, Message: message}
+}
+
+// TooLarge creates an error for a request body or upload over a size limit
+func TooLarge(code, message string) *Error {
+	return &Error{Kind: KindTooLarge, Code: code, Message: message}
+}
+
+// UnsupportedMediaType creates an error for content of a type that is not
+// accepted
+func UnsupportedMediaType(code, message string) *Error {
+	return &Error{Kind: KindUnsupportedMediaType, Code: code, Message: message}
+}
+
+// Locked creates an error for a temporarily locked resource
+func Locked(code, message string, retryAfter time.Duration) *Error {
+	return &Error{Kind: KindLocked, Code: code, Message: message, RetryAfter: retryAfter}
+}
+
+// TooManyRequests creates an error for a rate limited caller
+func TooManyRequests(code, message string, retryAfter time.Duration) *Error {
+	return &Error{Kind: KindTooManyRequests, Code: code, Message: message, RetryAfter: retryAfter}
+}
+
+// Timeout creates an error for a request that ran out of time
+func Timeout(code, message string) *Error {
+	return &Error{Kind: KindTimeout, Code: code, Message: message}
+}
+
+// As returns err as an *Error, or nil if it is not one
+func As(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return nil
+}
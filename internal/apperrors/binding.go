@@ -0,0 +1,118 @@
+package apperrors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+var registerTagNames sync.Once
+
+// UseJSONFieldNames makes validation errors report fields by their JSON name,
+// or their query parameter name for query structs, instead of the Go struct
+// field name
+func UseJSONFieldNames() {
+	registerTagNames.Do(func() {
+		v, ok := binding.Validator.Engine().(*validator.Validate)
+		if !ok {
+			return
+		}
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+			if name == "" {
+				name = strings.SplitN(field.Tag.Get("form"), ",", 2)[0]
+			}
+			if name == "-" || name == "" {
+				return field.Name
+			}
+			return name
+		})
+	})
+}
+
+var enums sync.Map // tag -> []string
+
+// RegisterEnum adds a binding tag that accepts one of values, so that
+// enumerations defined in code need not be repeated in oneof tags. Failures
+// are reported like oneof.
+func RegisterEnum(tag string, values ...string) {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+	enums.Store(tag, values)
+	_ = v.RegisterValidation(tag, func(fl validator.FieldLevel) bool {
+		return slices.Contains(values, fl.Field().String())
+	})
+}
+
+// FromBinding translates an error from ShouldBindJSON or ShouldBindQuery into
+// a validation error with per-field messages, or a generic bad request
+func FromBinding(err error) *Error {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		fields := make([]FieldError, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			fields = append(fields, FieldError{Field: fe.Field(), Message: fieldMessage(fe)})
+		}
+		return Validation("request validation failed", fields...)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return Validation("request validation failed", FieldError{
+			Field:   typeErr.Field,
+			Message: fmt.Sprintf("must be of type %s", typeErr.Type.String()),
+		})
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &syntaxErr) {
+		return BadRequest("invalid_json", "request body must be valid JSON")
+	}
+
+	// Anything else, such as a query parameter that does not parse, carries
+	// parser details that are logged rather than shown to clients
+	log.Printf("malformed request: %v", err)
+	appErr := BadRequest("invalid_request", "malformed request")
+	appErr.Err = err
+	return appErr
+}
+
+// fieldMessage returns a readable message for a failed validation rule
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required", "required_without", "required_without_all":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "min":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be at least %s characters long", fe.Param())
+		}
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "max":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be at most %s characters long", fe.Param())
+		}
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", strings.ReplaceAll(fe.Param(), " ", ", "))
+	case "uuid":
+		return "must be a valid UUID"
+	default:
+		if values, ok := enums.Load(fe.Tag()); ok {
+			return fmt.Sprintf("must be one of: %s", strings.Join(values.([]string), ", "))
+		}
+		return fmt.Sprintf("failed the %q rule", fe.Tag())
+	}
+}
@@ -0,0 +1,10 @@
+package apperrors
+
+import "user_service/internal/constants"
+
+// Binding tags for enumerations defined in the constants package
+func init() {
+	RegisterEnum("sender_role", constants.ValidSenderRoles()...)
+	RegisterEnum("part_type", constants.ValidPartTypes()...)
+	RegisterEnum("user_role", constants.ValidUserRoles()...)
+}
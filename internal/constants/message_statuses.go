@@ -0,0 +1,22 @@
+package constants
+
+// MessageStatus constants for the lifecycle of a message. Messages are
+// complete unless they are streamed: a streamed AI message is opened as
+// pending, becomes streaming with its first chunk and ends complete or failed.
+const (
+	MessageStatusPending   = "pending"
+	MessageStatusStreaming = "streaming"
+	MessageStatusComplete  = "complete"
+	MessageStatusFailed    = "failed"
+)
+
+// ValidMessageStatuses returns a slice of all valid message statuses
+func ValidMessageStatuses() []string {
+	return []string{MessageStatusPending, MessageStatusStreaming, MessageStatusComplete, MessageStatusFailed}
+}
+
+// IsOpenMessageStatus reports whether a message with the status can still
+// receive content
+func IsOpenMessageStatus(status string) bool {
+	return status == MessageStatusPending || status == MessageStatusStreaming
+}
@@ -0,0 +1,16 @@
+package constants
+
+// PartType constants for the parts of a message
+const (
+	PartTypeText = "text"
+	PartTypeCode = "code"
+	// PartTypeImage and PartTypeFile reference an attachment of the
+	// conversation; image parts require an image attachment
+	PartTypeImage = "image"
+	PartTypeFile  = "file"
+)
+
+// ValidPartTypes returns a slice of all valid message part types
+func ValidPartTypes() []string {
+	return []string{PartTypeText, PartTypeCode, PartTypeImage, PartTypeFile}
+}
@@ -5,11 +5,15 @@ const (
 	SenderRoleUser   = "user"
 	SenderRoleAI     = "ai"
 	SenderRoleSystem = "system"
+	// SenderRoleTool is the result of a tool call requested by an AI message
+	SenderRoleTool = "tool"
 )
 
-// ValidSenderRoles returns a slice of all valid sender roles
+// ValidSenderRoles returns a slice of all valid sender roles. It is the only
+// list of roles: requests validate against it and the database does not
+// restrict the column.
 func ValidSenderRoles() []string {
-	return []string{SenderRoleUser, SenderRoleAI, SenderRoleSystem}
+	return []string{SenderRoleUser, SenderRoleAI, SenderRoleSystem, SenderRoleTool}
 }
 
 // IsValidSenderRole checks if a sender role is valid
@@ -0,0 +1,22 @@
+package constants
+
+// UserRole constants for access control
+const (
+	UserRoleUser  = "user"
+	UserRoleAdmin = "admin"
+)
+
+// ValidUserRoles returns a slice of all valid user roles
+func ValidUserRoles() []string {
+	return []string{UserRoleUser, UserRoleAdmin}
+}
+
+// IsValidUserRole checks if a user role is valid
+func IsValidUserRole(role string) bool {
+	for _, validRole := range ValidUserRoles() {
+		if role == validRole {
+			return true
+		}
+	}
+	return false
+}
@@ -2,7 +2,9 @@ package database
 
 import (
 	"fmt"
+	"strings"
 	"user_service/config"
+	"user_service/internal/migrations"
 	"user_service/internal/models"
 
 	"gorm.io/driver/postgres"
@@ -10,28 +12,79 @@ import (
 	"gorm.io/gorm/logger"
 )
 
+// InitDB connects to the database and, when DB_AUTO_MIGRATE is enabled outside
+// production, brings the auth tables up to date with GORM AutoMigrate.
+// The schema is otherwise managed by the versioned migrations applied with
+// `user_service migrate up`. SQLite databases are always migrated on startup
+// so that local runs need no setup.
 func InitDB(cfg *config.Config) (*gorm.DB, error) {
+	db, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if IsSQLite(cfg.DatabaseURL) {
+		migrator, err := migrations.NewMigrator(db)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := migrator.Up(); err != nil {
+			return nil, fmt.Errorf("failed to migrate database: %w", err)
+		}
+		return db, nil
+	}
+
+	if !cfg.AutoMigrate {
+		return db, nil
+	}
+	if cfg.Environment == "production" {
+		return nil, fmt.Errorf("DB_AUTO_MIGRATE is not allowed in production; run migrations instead")
+	}
+
+	// Conversation tables depend on indexes AutoMigrate cannot create, such
+	// as the search indexes; they come from the versioned migrations only
+	if err := db.AutoMigrate(
+		&models.User{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.PasswordResetToken{},
+		&models.MFARecoveryCode{},
+		&models.LoginAttempt{},
+	); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	return db, nil
+}
+
+// Open connects to the database without changing its schema. DATABASE_URL
+// selects the backend: a postgres:// URL, or sqlite://path and :memory: for SQLite.
+func Open(cfg *config.Config) (*gorm.DB, error) {
 	if cfg.DatabaseURL == "" {
 		return nil, fmt.Errorf("DATABASE_URL is required")
 	}
 
+	gormConfig := &gorm.Config{
+		Logger:         logger.Default.LogMode(logger.Info),
+		TranslateError: true, // map driver errors such as unique violations to gorm.ErrDuplicatedKey
+	}
+
+	if IsSQLite(cfg.DatabaseURL) {
+		return openSQLite(cfg.DatabaseURL, gormConfig)
+	}
+
 	db, err := gorm.Open(postgres.New(postgres.Config{
 		DSN:                  cfg.DatabaseURL,
 		PreferSimpleProtocol: true, // disables implicit prepared statement usage for compatibility with Supabase connection pooler (Transaction mode)
-	}), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
-	})
+	}), gormConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	// Auto migrate models (skipping conversation tables due to UUID conflicts)
-	if err := db.AutoMigrate(&models.User{}); err != nil {
-		return nil, fmt.Errorf("failed to migrate database: %w", err)
-	}
-
-	// Note: Conversation and Message tables should already exist in Supabase
-	// with proper UUID types. If not, create them manually in Supabase SQL editor.
-
 	return db, nil
 }
+
+// IsSQLite reports whether a DATABASE_URL selects the SQLite backend
+func IsSQLite(databaseURL string) bool {
+	return databaseURL == sqliteMemory || strings.HasPrefix(databaseURL, sqliteScheme)
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+const (
+	sqliteScheme = "sqlite://"
+	sqliteMemory = ":memory:"
+)
+
+// openSQLite opens a SQLite database for local development and tests.
+// The pure Go driver is used so that builds do not need cgo.
+func openSQLite(databaseURL string, gormConfig *gorm.Config) (*gorm.DB, error) {
+	path := strings.TrimPrefix(databaseURL, sqliteScheme)
+	if path == "" {
+		return nil, fmt.Errorf("DATABASE_URL is missing the SQLite file path")
+	}
+
+	// Enforce foreign keys so that deletes cascade as they do in Postgres
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	path += separator + "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+
+	db, err := gorm.Open(sqlite.Open(path), gormConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	// SQLite allows a single writer, and every connection to :memory: would
+	// otherwise get its own empty database
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	return db, nil
+}
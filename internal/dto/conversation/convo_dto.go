@@ -1,6 +1,8 @@
 package conversation
 
 import (
+	"encoding/json"
+	"mime/multipart"
 	"time"
 
 	"github.com/google/uuid"
@@ -23,11 +25,196 @@ type CreateConversationResponse struct {
 // ================================ Add a message to a conversation ================================
 type AddMessageRequest struct {
 	ConversationID string `json:"conversation_id,omitempty"` // Optional in body, set from URL param
-	Message        string `json:"message" binding:"required"`
-	Sender         string `json:"sender" binding:"required,oneof=user ai system"`
+	// Message may be empty for an AI message that only requests tool calls
+	// or is opened for streaming, and must be empty when the content is given
+	// as Parts
+	Message string `json:"message" binding:"required_without_all=ToolCalls Parts Status"`
+	Sender  string `json:"sender" binding:"required,sender_role"`
+	// ParentMessageID is the message replied to. It defaults to the last
+	// message of the active branch; any other message starts a new branch.
+	ParentMessageID string `json:"parent_message_id,omitempty" binding:"omitempty,uuid"`
+	// Model is the model that produced an AI message. It defaults to the
+	// model of the conversation and is only accepted for AI messages.
+	Model *string `json:"model,omitempty" binding:"omitempty,max=100"`
+	// Metadata is stored with the message. Well-known keys such as
+	// prompt_tokens are validated by the service.
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	// ToolCalls are the tool calls requested by an AI message
+	ToolCalls []ToolCall `json:"tool_calls,omitempty" binding:"omitempty,max=32,dive"`
+	// ToolCallID names the call a tool message answers, among the calls of
+	// the AI message it replies to
+	ToolCallID string `json:"tool_call_id,omitempty" binding:"omitempty,max=100"`
+	// Parts give the content as typed parts instead of Message
+	Parts []MessagePart `json:"parts,omitempty" binding:"omitempty,max=32,dive"`
+	// Status "pending" opens an AI message for streaming: its content is then
+	// appended in chunks. Messages are complete by default.
+	Status string `json:"status,omitempty" binding:"omitempty,oneof=pending complete"`
+}
+
+// MessagePart is a typed part of the content of a message. Text and code
+// parts hold Text, code parts optionally their Language; image and file parts
+// reference an attachment of the conversation.
+type MessagePart struct {
+	Type         string `json:"type" binding:"required,part_type"`
+	Text         string `json:"text,omitempty"`
+	Language     string `json:"language,omitempty" binding:"omitempty,max=50"`
+	AttachmentID string `json:"attachment_id,omitempty" binding:"omitempty,uuid"`
+}
+
+// ToolCall is a tool call requested by an AI message. Arguments must be a
+// JSON object.
+type ToolCall struct {
+	ID        string          `json:"id" binding:"required,max=100"`
+	Name      string          `json:"name" binding:"required,max=100"`
+	Arguments json.RawMessage `json:"arguments" binding:"required"`
+}
+
+type AddMessageResponse struct {
+	Message         string     `json:"message"`
+	MessageID       uuid.UUID  `json:"message_id"`
+	ParentMessageID *uuid.UUID `json:"parent_message_id,omitempty"`
+	Status          string     `json:"status"`
+}
+
+// ================================ Stream an AI message ================================
+// AppendChunkRequest appends content to a message opened for streaming.
+// Status "complete" or "failed" ends the stream, after appending Content;
+// Metadata, such as token counts, is then merged into the metadata of the
+// message.
+type AppendChunkRequest struct {
+	Content  string                 `json:"content"`
+	Status   string                 `json:"status,omitempty" binding:"omitempty,oneof=streaming complete failed"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+type AppendChunkResponse struct {
+	MessageID uuid.UUID `json:"message_id"`
+	Status    string    `json:"status"`
+	// Length is the length of the content received so far, in bytes
+	Length int `json:"length"`
+}
+
+// StreamMessageEvent is sent to the subscribers of a conversation for every
+// new message, including messages opened for streaming
+type StreamMessageEvent struct {
+	MessageID       uuid.UUID  `json:"message_id"`
+	ParentMessageID *uuid.UUID `json:"parent_message_id,omitempty"`
+	Role            string     `json:"role"`
+	Status          string     `json:"status"`
+	Message         string     `json:"message"`
+	Timestamp       time.Time  `json:"timestamp"`
+}
+
+// StreamChunkEvent is content appended to a streaming message. Offset is the
+// byte offset of Content in the content of the message, so that chunks
+// already seen in a snapshot can be skipped.
+type StreamChunkEvent struct {
+	MessageID uuid.UUID `json:"message_id"`
+	Offset    int       `json:"offset"`
+	Content   string    `json:"content"`
+}
+
+// StreamStatusEvent is sent when a streaming message ends
+type StreamStatusEvent struct {
+	MessageID uuid.UUID `json:"message_id"`
+	Status    string    `json:"status"`
+}
+
+// StreamSnapshotEvent is sent on subscription for every message of the
+// conversation being streamed, with the content received so far
+type StreamSnapshotEvent struct {
+	MessageID uuid.UUID `json:"message_id"`
+	Status    string    `json:"status"`
+	Message   string    `json:"message"`
+}
+
+// ================================ Edit a message ================================
+// EditMessageRequest changes a message by adding a new version of it: a
+// sibling with the same parent, which becomes the active branch. System
+// messages can instead be changed in place with InPlace.
+type EditMessageRequest struct {
+	Message string `json:"message" binding:"required_without=Parts"`
+	// Parts give the new content as typed parts instead of Message. They
+	// cannot be combined with InPlace.
+	Parts   []MessagePart `json:"parts,omitempty" binding:"omitempty,max=32,dive"`
+	InPlace bool          `json:"in_place"`
+}
+
+type EditMessageResponse struct {
+	Message string `json:"message"`
+	// MessageID is the new version, or the edited message for in-place edits
+	MessageID       uuid.UUID  `json:"message_id"`
+	ParentMessageID *uuid.UUID `json:"parent_message_id,omitempty"`
+	EditedInPlace   bool       `json:"edited_in_place"`
+}
+
+// ================================ Regenerate an AI message ================================
+// RegenerateMessageRequest adds a new version of an AI message: a sibling with
+// the same parent, which becomes the active branch
+type RegenerateMessageRequest struct {
+	Message string `json:"message" binding:"required_without_all=ToolCalls Parts"`
+	// Model is the model that produced the new version. It defaults to the
+	// model of the conversation.
+	Model     *string                `json:"model,omitempty" binding:"omitempty,max=100"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	ToolCalls []ToolCall             `json:"tool_calls,omitempty" binding:"omitempty,max=32,dive"`
+	Parts     []MessagePart          `json:"parts,omitempty" binding:"omitempty,max=32,dive"`
+}
+
+type RegenerateMessageResponse struct {
+	Message         string     `json:"message"`
+	MessageID       uuid.UUID  `json:"message_id"`
+	ParentMessageID *uuid.UUID `json:"parent_message_id,omitempty"`
+	Model           string     `json:"model,omitempty"`
+	// VersionIndex is the position of the new version among the versions of
+	// the message, oldest first; VersionCount is their number
+	VersionIndex int `json:"version_index"`
+	VersionCount int `json:"version_count"`
+}
+
+// ================================ Delete a message ================================
+// DeleteMessageQuery selects how a message is removed: "delete" (the default)
+// removes it with every reply below it, "redact" keeps it in the tree with its
+// content replaced by a tombstone.
+type DeleteMessageQuery struct {
+	Mode string `form:"mode" binding:"omitempty,oneof=delete redact"`
+}
+
+type DeleteMessageResponse struct {
+	Message string `json:"message"`
+	// DeletedCount is the number of messages deleted, including replies
+	DeletedCount int64 `json:"deleted_count,omitempty"`
+	// ActiveLeafID is the end of the active branch after a deletion, absent
+	// once the conversation has no messages
+	ActiveLeafID *uuid.UUID `json:"active_leaf_id,omitempty"`
+	Redacted     bool       `json:"redacted"`
+}
+
+// ================================ Pagination ================================
+// PageQuery holds the keyset pagination parameters of list endpoints. Before
+// and After are opaque cursors from a previous response's prev_cursor and
+// next_cursor; at most one may be given.
+type PageQuery struct {
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	Before string `form:"before"`
+	After  string `form:"after"`
+}
+
+// PageCursors point to the neighbouring pages; each is omitted when there is
+// no page in that direction
+type PageCursors struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
 }
 
 // ================================ List of conversations (all conversations) ================================
+type GetAllConversationsQuery struct {
+	PageQuery
+	// Pinned lists only pinned (true) or only unpinned (false) conversations.
+	// Without it pinned conversations are listed first.
+	Pinned *bool `form:"pinned"`
+}
+
 type ConversationsListItem struct {
 	ConversationID uuid.UUID `json:"conversation_id"`
 	Title          string    `json:"title"`
@@ -37,6 +224,7 @@ type ConversationsListItem struct {
 
 type GetAllConversationsResponse struct {
 	Conversations []ConversationsListItem `json:"conversations"`
+	PageCursors
 }
 
 // ================================ Delete a conversation ================================
@@ -56,12 +244,149 @@ type PinConversationResponse struct {
 }
 
 // ================================ Conversation History ================================
+// GetConversationQuery pages through a branch of a conversation. Without a
+// cursor the latest messages are returned.
+type GetConversationQuery struct {
+	PageQuery
+	// LeafID selects the branch through the given message, continued to the
+	// most recent message below it. Without it the active branch is returned.
+	LeafID string `form:"leaf_id" binding:"omitempty,uuid"`
+	// Metadata filters the branch to messages whose metadata has a key, given
+	// as key, or a key with a value, given as key:value
+	Metadata []string `form:"metadata"`
+}
+
 type MessageHistoryItem struct {
-	Message   string    `json:"message"`
-	Role      string    `json:"role"`
-	Timestamp time.Time `json:"timestamp"`
+	MessageID       uuid.UUID       `json:"message_id"`
+	ParentMessageID *uuid.UUID      `json:"parent_message_id,omitempty"`
+	Message         string          `json:"message"`
+	Role            string          `json:"role"`
+	Timestamp       time.Time       `json:"timestamp"`
+	EditedAt        *time.Time      `json:"edited_at,omitempty"`
+	RedactedAt      *time.Time      `json:"redacted_at,omitempty"`
+	RedactedBy      *uint           `json:"redacted_by,omitempty"`
+	Metadata        json.RawMessage `json:"metadata,omitempty"`
+	// Status is pending or streaming while an AI message is being streamed,
+	// and then complete or failed
+	Status string `json:"status"`
+	// Model is the model that produced an AI message, if known
+	Model string `json:"model,omitempty"`
+	// ToolCalls are the calls requested by an AI message. Their results are
+	// the tool messages directly following it, which give the call they
+	// answer as ToolCallID and ToolName.
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	ToolName   string     `json:"tool_name,omitempty"`
+	// Parts are the parts of a message given as parts; Message then holds
+	// their plain-text rendering
+	Parts []MessagePartItem `json:"parts,omitempty"`
+	// SiblingIndex is the position of the message among its siblings, the
+	// messages with the same parent, oldest first; SiblingCount is their number
+	SiblingIndex int `json:"sibling_index"`
+	SiblingCount int `json:"sibling_count"`
+	// SiblingIDs lists the siblings, including the message itself, when
+	// there is more than one
+	SiblingIDs []uuid.UUID `json:"sibling_ids,omitempty"`
+}
+
+// MessagePartItem is a part of a message. Image and file parts describe their
+// attachment, unless it no longer exists.
+type MessagePartItem struct {
+	MessagePart
+	Attachment *AttachmentItem `json:"attachment,omitempty"`
 }
 
 type GetConversationResponse struct {
 	Messages []MessageHistoryItem `json:"messages"`
+	// LeafID is the last message of the branch, or empty for a conversation
+	// without messages
+	LeafID *uuid.UUID `json:"leaf_id,omitempty"`
+	PageCursors
+}
+
+// ================================ Switch the active branch ================================
+// SwitchBranchRequest makes the branch through MessageID the active one. The
+// branch is continued to the most recent message below it.
+type SwitchBranchRequest struct {
+	MessageID string `json:"message_id" binding:"required,uuid"`
+}
+
+type SwitchBranchResponse struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	ActiveLeafID   uuid.UUID `json:"active_leaf_id"`
+	Message        string    `json:"message"`
+}
+
+// ================================ Search conversations ================================
+// SearchConversationsQuery searches the titles and messages of the caller's
+// conversations. Sender, From, To and Metadata restrict the messages
+// searched; with any of them set titles are not searched. Metadata filters
+// are given as in GetConversationQuery.
+type SearchConversationsQuery struct {
+	Q        string     `form:"q" binding:"required,max=200"`
+	Sender   string     `form:"sender" binding:"omitempty,sender_role"`
+	From     *time.Time `form:"from"`
+	To       *time.Time `form:"to"`
+	Metadata []string   `form:"metadata"`
+	Pinned   *bool      `form:"pinned"`
+	Limit    int        `form:"limit" binding:"omitempty,min=1,max=50"`
+}
+
+// SearchMessageMatch is a matching message. Snippet is an excerpt of the
+// message with the matched words wrapped in <mark> tags.
+type SearchMessageMatch struct {
+	MessageID uuid.UUID `json:"message_id"`
+	Role      string    `json:"role"`
+	Timestamp time.Time `json:"timestamp"`
+	Snippet   string    `json:"snippet"`
+}
+
+type SearchResultItem struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	Title          string    `json:"title"`
+	IsPinned       bool      `json:"is_pinned"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	Rank           float64   `json:"rank"`
+	// TitleSnippet is the title with the matched words marked, if it matched
+	TitleSnippet string `json:"title_snippet,omitempty"`
+	// MatchCount counts every matching message; Matches holds the best few
+	MatchCount int                  `json:"match_count"`
+	Matches    []SearchMessageMatch `json:"matches"`
+}
+
+type SearchConversationsResponse struct {
+	Results []SearchResultItem `json:"results"`
+}
+
+// ================================ Attachments ================================
+// UploadAttachmentRequest is a multipart/form-data upload of a single file
+type UploadAttachmentRequest struct {
+	File *multipart.FileHeader `form:"file" binding:"required"`
+}
+
+// AttachmentItem describes an attachment. DownloadURL fetches its content
+// without further authentication until DownloadURLExpiresAt.
+type AttachmentItem struct {
+	AttachmentID         uuid.UUID `json:"attachment_id"`
+	ConversationID       uuid.UUID `json:"conversation_id"`
+	Filename             string    `json:"filename"`
+	ContentType          string    `json:"content_type"`
+	SizeBytes            int64     `json:"size_bytes"`
+	CreatedAt            time.Time `json:"created_at"`
+	DownloadURL          string    `json:"download_url"`
+	DownloadURLExpiresAt time.Time `json:"download_url_expires_at"`
+}
+
+type ListAttachmentsResponse struct {
+	Attachments []AttachmentItem `json:"attachments"`
+	// UsageBytes is the total size of the caller's attachments, counted
+	// against QuotaBytes
+	UsageBytes int64 `json:"usage_bytes"`
+	QuotaBytes int64 `json:"quota_bytes"`
+}
+
+// DownloadAttachmentQuery carries the signature of a download URL
+type DownloadAttachmentQuery struct {
+	Expires   int64  `form:"expires" binding:"required"`
+	Signature string `form:"signature" binding:"required"`
 }
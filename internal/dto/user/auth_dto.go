@@ -17,16 +17,66 @@ type RegisterRequest struct {
 	LastName  string `json:"last_name" binding:"required"`
 }
 
-// AuthResponse represents the authentication response
+// RefreshRequest represents the token refresh request payload
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// LogoutRequest represents the logout request payload.
+// If a refresh token is supplied, its whole token family is revoked as well.
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// ForgotPasswordRequest represents the password reset request payload
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest represents the payload for completing a password reset
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+// ChangePasswordRequest represents the payload for changing a logged-in user's password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=6"`
+}
+
+// VerifyEmailRequest represents the email verification payload
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ResendVerificationRequest represents the payload for resending a verification email
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// AuthResponse represents the authentication response.
+// Tokens are omitted when the account must verify its email before logging in.
+// When MFA is enabled, Login returns only MFARequired and MFAToken, which must
+// be exchanged for tokens with a valid code.
 type AuthResponse struct {
-	Token string       `json:"token"`
-	User  UserResponse `json:"user"`
+	Token            string        `json:"token,omitempty"`
+	ExpiresAt        int64         `json:"expires_at,omitempty"`
+	RefreshToken     string        `json:"refresh_token,omitempty"`
+	RefreshExpiresAt int64         `json:"refresh_expires_at,omitempty"`
+	MFARequired      bool          `json:"mfa_required,omitempty"`
+	MFAToken         string        `json:"mfa_token,omitempty"`
+	User             *UserResponse `json:"user,omitempty"`
 }
 
 // Claims represents the JWT claims
 type Claims struct {
-	UserID   uint   `json:"user_id"`
-	Email    string `json:"email"`
-	Username string `json:"username"`
+	UserID       uint   `json:"user_id"`
+	Email        string `json:"email"`
+	Username     string `json:"username"`
+	Role         string `json:"role"`
+	TokenVersion uint   `json:"token_version"`
+	// EmailVerified is filled in from the user record on validation, not trusted from the token
+	EmailVerified bool `json:"-"`
 	jwt.RegisteredClaims
 }
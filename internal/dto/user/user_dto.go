@@ -7,6 +7,7 @@ type CreateUserRequest struct {
 	Password  string `json:"password" binding:"required,min=6"`
 	FirstName string `json:"first_name" binding:"required"`
 	LastName  string `json:"last_name" binding:"required"`
+	Role      string `json:"role,omitempty" binding:"omitempty,user_role"`
 }
 
 // UpdateUserRequest represents the request payload for updating a user
@@ -15,15 +16,20 @@ type UpdateUserRequest struct {
 	Username  *string `json:"username,omitempty" binding:"omitempty,min=3,max=50"`
 	FirstName *string `json:"first_name,omitempty"`
 	LastName  *string `json:"last_name,omitempty"`
+	Role      *string `json:"role,omitempty" binding:"omitempty,user_role"` // admin only
 }
 
 // UserResponse represents the user data sent in responses
 type UserResponse struct {
-	UserID    uint   `json:"user_id"`
-	Email     string `json:"email"`
-	Username  string `json:"username"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	CreatedAt int64  `json:"created_at"`
-	UpdatedAt int64  `json:"updated_at"`
+	UserID        uint    `json:"user_id"`
+	Email         string  `json:"email"`
+	EmailVerified bool    `json:"email_verified"`
+	PendingEmail  *string `json:"pending_email,omitempty"`
+	MFAEnabled    bool    `json:"mfa_enabled"`
+	Username      string  `json:"username"`
+	Role          string  `json:"role"`
+	FirstName     string  `json:"first_name"`
+	LastName      string  `json:"last_name"`
+	CreatedAt     int64   `json:"created_at"`
+	UpdatedAt     int64   `json:"updated_at"`
 }
@@ -0,0 +1,117 @@
+package conversation
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+	"user_service/internal/apperrors"
+	dto "user_service/internal/dto/conversation"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// UploadAttachment handles uploading a file to a conversation
+// POST /conversations/:conversation_id/attachments
+func (h *ConversationHandler) UploadAttachment(c *gin.Context) {
+	conversationID, err := uuid.Parse(c.Param("conversation_id"))
+	if err != nil {
+		_ = c.Error(apperrors.BadRequest("invalid_conversation_id", "Invalid conversation ID"))
+		return
+	}
+
+	// Stop reading bodies far larger than any accepted attachment
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.conversationService.MaxUploadBytes())
+	var req dto.UploadAttachmentRequest
+	if err := c.ShouldBind(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			_ = c.Error(apperrors.TooLarge("attachment_too_large", "request body is too large"))
+			return
+		}
+		_ = c.Error(apperrors.FromBinding(err))
+		return
+	}
+
+	// Get authenticated user info from JWT middleware
+	userID, exists := c.Get("user_id")
+	if !exists {
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
+		return
+	}
+
+	response, err := h.conversationService.UploadAttachment(c.Request.Context(), conversationID, userID.(uint), &req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// ListAttachments handles listing the attachments of a conversation
+// GET /conversations/:conversation_id/attachments
+func (h *ConversationHandler) ListAttachments(c *gin.Context) {
+	conversationID, err := uuid.Parse(c.Param("conversation_id"))
+	if err != nil {
+		_ = c.Error(apperrors.BadRequest("invalid_conversation_id", "Invalid conversation ID"))
+		return
+	}
+
+	// Get authenticated user info from JWT middleware
+	userID, exists := c.Get("user_id")
+	if !exists {
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
+		return
+	}
+
+	response, err := h.conversationService.ListAttachments(c.Request.Context(), conversationID, userID.(uint))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// DownloadAttachment handles downloading the content of an attachment with a
+// signed URL, which takes the place of authentication
+// GET /attachments/:attachment_id/content
+func (h *ConversationHandler) DownloadAttachment(c *gin.Context) {
+	attachmentID, err := uuid.Parse(c.Param("attachment_id"))
+	if err != nil {
+		_ = c.Error(apperrors.BadRequest("invalid_attachment_id", "Invalid attachment ID"))
+		return
+	}
+
+	var query dto.DownloadAttachmentQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		_ = c.Error(apperrors.FromBinding(err))
+		return
+	}
+
+	attachment, content, err := h.conversationService.OpenAttachment(c.Request.Context(), attachmentID, &query)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	defer content.Close()
+
+	// Uploaded content is served as a download, images excepted, and never
+	// sniffed or run as a page of this origin
+	disposition := "attachment"
+	contentType := attachment.ContentType
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		disposition = "inline"
+	case strings.HasPrefix(contentType, "text/"):
+		contentType += "; charset=utf-8"
+	}
+	c.DataFromReader(http.StatusOK, attachment.SizeBytes, contentType, content, map[string]string{
+		"Content-Disposition":     mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}),
+		"X-Content-Type-Options":  "nosniff",
+		"Content-Security-Policy": "default-src 'none'; sandbox",
+		"Cache-Control":           "private",
+	})
+}
@@ -3,6 +3,7 @@ package conversation
 import (
 	"net/http"
 	"strconv"
+	"user_service/internal/apperrors"
 	dto "user_service/internal/dto/conversation"
 	conversationService "user_service/internal/service/conversation"
 
@@ -25,21 +26,21 @@ func NewConversationHandler(conversationService *conversationService.Conversatio
 func (h *ConversationHandler) CreateConversation(c *gin.Context) {
 	var req dto.CreateConversationRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		_ = c.Error(apperrors.FromBinding(err))
 		return
 	}
 
 	// Get user info from JWT middleware
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
 		return
 	}
 
 	// Create conversation
-	response, err := h.conversationService.CreateConversation(userID.(uint), &req)
+	response, err := h.conversationService.CreateConversation(c.Request.Context(), userID.(uint), &req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		_ = c.Error(err)
 		return
 	}
 
@@ -53,7 +54,7 @@ func (h *ConversationHandler) AddMessage(c *gin.Context) {
 
 	var req dto.AddMessageRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		_ = c.Error(apperrors.FromBinding(err))
 		return
 	}
 
@@ -63,22 +64,129 @@ func (h *ConversationHandler) AddMessage(c *gin.Context) {
 	// Get user info from JWT middleware
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
+		return
+	}
+	response, err := h.conversationService.AddMessage(c.Request.Context(), userID.(uint), &req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// EditMessage handles editing a message
+// PUT /conversations/:conversation_id/messages/:message_id
+func (h *ConversationHandler) EditMessage(c *gin.Context) {
+	conversationID, err := uuid.Parse(c.Param("conversation_id"))
+	if err != nil {
+		_ = c.Error(apperrors.BadRequest("invalid_conversation_id", "Invalid conversation ID"))
+		return
+	}
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		_ = c.Error(apperrors.BadRequest("invalid_message_id", "Invalid message ID"))
+		return
+	}
+
+	var req dto.EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(apperrors.FromBinding(err))
+		return
+	}
+
+	// Get authenticated user info from JWT middleware
+	userID, exists := c.Get("user_id")
+	if !exists {
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
+		return
+	}
+
+	response, err := h.conversationService.EditMessage(c.Request.Context(), conversationID, messageID, userID.(uint), &req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	// A new version is a new resource; an in-place edit is not
+	status := http.StatusCreated
+	if response.EditedInPlace {
+		status = http.StatusOK
+	}
+	c.JSON(status, response)
+}
+
+// RegenerateMessage handles adding a new version of an AI message
+// POST /conversations/:conversation_id/messages/:message_id/regenerate
+func (h *ConversationHandler) RegenerateMessage(c *gin.Context) {
+	conversationID, err := uuid.Parse(c.Param("conversation_id"))
+	if err != nil {
+		_ = c.Error(apperrors.BadRequest("invalid_conversation_id", "Invalid conversation ID"))
+		return
+	}
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		_ = c.Error(apperrors.BadRequest("invalid_message_id", "Invalid message ID"))
+		return
+	}
+
+	var req dto.RegenerateMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(apperrors.FromBinding(err))
+		return
+	}
+
+	// Get authenticated user info from JWT middleware
+	userID, exists := c.Get("user_id")
+	if !exists {
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
+		return
+	}
+
+	response, err := h.conversationService.RegenerateMessage(c.Request.Context(), conversationID, messageID, userID.(uint), &req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// DeleteMessage handles deleting or redacting a message
+// DELETE /conversations/:conversation_id/messages/:message_id
+func (h *ConversationHandler) DeleteMessage(c *gin.Context) {
+	conversationID, err := uuid.Parse(c.Param("conversation_id"))
+	if err != nil {
+		_ = c.Error(apperrors.BadRequest("invalid_conversation_id", "Invalid conversation ID"))
+		return
+	}
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		_ = c.Error(apperrors.BadRequest("invalid_message_id", "Invalid message ID"))
 		return
 	}
-	_ = userID // We have user ID for future authorization checks
 
-	err := h.conversationService.AddMessage(&req)
+	var query dto.DeleteMessageQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		_ = c.Error(apperrors.FromBinding(err))
+		return
+	}
+
+	// Get authenticated user info from JWT middleware
+	userID, exists := c.Get("user_id")
+	if !exists {
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
+		return
+	}
+
+	response, err := h.conversationService.DeleteMessage(c.Request.Context(), conversationID, messageID, userID.(uint), &query)
 	if err != nil {
-		if err.Error() == "conversation not found" {
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		_ = c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "Message added successfully"})
+	c.JSON(http.StatusOK, response)
 }
 
 // GetConversationHistory handles retrieving conversation history
@@ -88,58 +196,121 @@ func (h *ConversationHandler) GetConversationHistory(c *gin.Context) {
 
 	conversationID, err := uuid.Parse(conversationIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		_ = c.Error(apperrors.BadRequest("invalid_conversation_id", "Invalid conversation ID"))
 		return
 	}
 
 	// Get user info from JWT middleware
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
 		return
 	}
-	_ = userID // We have user ID for future authorization checks
 
-	response, err := h.conversationService.GetConversationHistory(conversationID)
+	var query dto.GetConversationQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		_ = c.Error(apperrors.FromBinding(err))
+		return
+	}
+
+	response, err := h.conversationService.GetConversationHistory(c.Request.Context(), conversationID, userID.(uint), &query)
 	if err != nil {
-		if err.Error() == "conversation not found" {
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		_ = c.Error(err)
 		return
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
-// GetAllConversations handles retrieving all conversations for a user
+// SwitchBranch handles switching the active branch of a conversation
+// PUT /conversations/:conversation_id/active-branch
+func (h *ConversationHandler) SwitchBranch(c *gin.Context) {
+	conversationID, err := uuid.Parse(c.Param("conversation_id"))
+	if err != nil {
+		_ = c.Error(apperrors.BadRequest("invalid_conversation_id", "Invalid conversation ID"))
+		return
+	}
+
+	var req dto.SwitchBranchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(apperrors.FromBinding(err))
+		return
+	}
+
+	// Get authenticated user info from JWT middleware
+	userID, exists := c.Get("user_id")
+	if !exists {
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
+		return
+	}
+
+	response, err := h.conversationService.SwitchBranch(c.Request.Context(), conversationID, userID.(uint), &req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetAllConversations handles retrieving a page of conversations for a user
 // GET /users/:id/conversations
 func (h *ConversationHandler) GetAllConversations(c *gin.Context) {
 	userIDStr := c.Param("id")
 
 	userID, err := strconv.ParseUint(userIDStr, 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		_ = c.Error(apperrors.BadRequest("invalid_user_id", "Invalid user ID"))
 		return
 	}
 
 	// Get authenticated user info from JWT middleware
 	authUserID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
 		return
 	}
 
 	// Check if user is requesting their own conversations
 	if uint(userID) != authUserID.(uint) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		_ = c.Error(apperrors.Forbidden("access_denied", "Access denied"))
+		return
+	}
+
+	var query dto.GetAllConversationsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		_ = c.Error(apperrors.FromBinding(err))
+		return
+	}
+
+	response, err := h.conversationService.GetAllConversations(c.Request.Context(), uint(userID), &query)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SearchConversations handles searching the user's conversations
+// GET /conversations/search
+func (h *ConversationHandler) SearchConversations(c *gin.Context) {
+	// Get authenticated user info from JWT middleware
+	userID, exists := c.Get("user_id")
+	if !exists {
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
+		return
+	}
+
+	var query dto.SearchConversationsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		_ = c.Error(apperrors.FromBinding(err))
 		return
 	}
 
-	response, err := h.conversationService.GetAllConversations(uint(userID))
+	response, err := h.conversationService.SearchConversations(c.Request.Context(), userID.(uint), &query)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		_ = c.Error(err)
 		return
 	}
 
@@ -152,26 +323,20 @@ func (h *ConversationHandler) DeleteConversation(c *gin.Context) {
 	conversationIDStr := c.Param("conversation_id")
 	conversationID, err := uuid.Parse(conversationIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		_ = c.Error(apperrors.BadRequest("invalid_conversation_id", "Invalid conversation ID"))
 		return
 	}
 
 	// Get authenticated user info from JWT middleware
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
 		return
 	}
 
-	response, err := h.conversationService.DeleteConversation(conversationID, userID.(uint))
+	response, err := h.conversationService.DeleteConversation(c.Request.Context(), conversationID, userID.(uint))
 	if err != nil {
-		if err.Error() == "conversation not found" {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
-		} else if err.Error() == "access denied: you can only delete your own conversations" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
+		_ = c.Error(err)
 		return
 	}
 
@@ -184,32 +349,26 @@ func (h *ConversationHandler) ToggleConversationPin(c *gin.Context) {
 	conversationIDStr := c.Param("conversation_id")
 	conversationID, err := uuid.Parse(conversationIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		_ = c.Error(apperrors.BadRequest("invalid_conversation_id", "Invalid conversation ID"))
 		return
 	}
 
 	var req dto.PinConversationRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		_ = c.Error(apperrors.FromBinding(err))
 		return
 	}
 
 	// Get authenticated user info from JWT middleware
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
 		return
 	}
 
-	response, err := h.conversationService.ToggleConversationPin(conversationID, userID.(uint), &req)
+	response, err := h.conversationService.ToggleConversationPin(c.Request.Context(), conversationID, userID.(uint), &req)
 	if err != nil {
-		if err.Error() == "conversation not found" {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
-		} else if err.Error() == "access denied: you can only pin your own conversations" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
+		_ = c.Error(err)
 		return
 	}
 
@@ -0,0 +1,106 @@
+package conversation
+
+import (
+	"io"
+	"net/http"
+	"time"
+	"user_service/internal/apperrors"
+	dto "user_service/internal/dto/conversation"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// heartbeatInterval is how often an idle event stream sends a comment, so
+// that proxies keep the connection open
+const heartbeatInterval = 15 * time.Second
+
+// AppendChunk handles appending content to an AI message opened for streaming
+// POST /conversations/:conversation_id/messages/:message_id/chunks
+func (h *ConversationHandler) AppendChunk(c *gin.Context) {
+	conversationID, err := uuid.Parse(c.Param("conversation_id"))
+	if err != nil {
+		_ = c.Error(apperrors.BadRequest("invalid_conversation_id", "Invalid conversation ID"))
+		return
+	}
+	messageID, err := uuid.Parse(c.Param("message_id"))
+	if err != nil {
+		_ = c.Error(apperrors.BadRequest("invalid_message_id", "Invalid message ID"))
+		return
+	}
+
+	var req dto.AppendChunkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(apperrors.FromBinding(err))
+		return
+	}
+
+	// Get authenticated user info from JWT middleware
+	userID, exists := c.Get("user_id")
+	if !exists {
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
+		return
+	}
+
+	response, err := h.conversationService.AppendChunk(c.Request.Context(), conversationID, messageID, userID.(uint), &req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// StreamConversation handles subscribing to the live events of a
+// conversation as Server-Sent Events, until the client disconnects
+// GET /conversations/:conversation_id/stream
+func (h *ConversationHandler) StreamConversation(c *gin.Context) {
+	conversationID, err := uuid.Parse(c.Param("conversation_id"))
+	if err != nil {
+		_ = c.Error(apperrors.BadRequest("invalid_conversation_id", "Invalid conversation ID"))
+		return
+	}
+
+	// Get authenticated user info from JWT middleware
+	userID, exists := c.Get("user_id")
+	if !exists {
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
+		return
+	}
+
+	subscription, err := h.conversationService.Subscribe(c.Request.Context(), conversationID, userID.(uint))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	defer subscription.Close()
+
+	// Send the headers right away, so that the client sees the stream open
+	// before the first event
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	for _, event := range subscription.Snapshot {
+		c.SSEvent(event.Name, event.Data)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-subscription.Events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(event.Name, event.Data)
+			return true
+		case <-heartbeat.C:
+			_, err := io.WriteString(w, ": heartbeat\n\n")
+			return err == nil
+		}
+	})
+}
@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
+	"user_service/internal/apperrors"
 	"user_service/internal/dto/user"
 	"user_service/internal/service/user"
 
@@ -20,17 +24,13 @@ func NewAuthHandler(authService *service.AuthService) *AuthHandler {
 func (h *AuthHandler) Register(c *gin.Context) {
 	var req dto.RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		_ = c.Error(apperrors.FromBinding(err))
 		return
 	}
 
-	response, err := h.authService.Register(&req)
+	response, err := h.authService.Register(c.Request.Context(), &req)
 	if err != nil {
-		status := http.StatusInternalServerError
-		if err.Error() == "email already exists" || err.Error() == "username already exists" {
-			status = http.StatusConflict
-		}
-		c.JSON(status, gin.H{"error": err.Error()})
+		_ = c.Error(err)
 		return
 	}
 
@@ -41,13 +41,241 @@ func (h *AuthHandler) Register(c *gin.Context) {
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req dto.LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		_ = c.Error(apperrors.FromBinding(err))
 		return
 	}
 
-	response, err := h.authService.Login(&req)
+	response, err := h.authService.Login(c.Request.Context(), &req)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Refresh handles refresh token rotation
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req dto.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(apperrors.FromBinding(err))
+		return
+	}
+
+	response, err := h.authService.Refresh(c.Request.Context(), &req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Logout revokes the current access token and, optionally, its refresh token
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req dto.LogoutRequest
+	// The request body is optional
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		_ = c.Error(apperrors.FromBinding(err))
+		return
+	}
+
+	// Get token claims from JWT middleware
+	claims, exists := c.Get("claims")
+	if !exists {
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
+		return
+	}
+
+	if err := h.authService.Logout(c.Request.Context(), claims.(*dto.Claims), &req); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// LogoutAll revokes every access and refresh token issued to the current user
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	// Get user info from JWT middleware
+	userID, exists := c.Get("user_id")
+	if !exists {
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
+		return
+	}
+
+	if err := h.authService.RevokeAllSessions(c.Request.Context(), userID.(uint)); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions successfully"})
+}
+
+// ForgotPassword starts the password reset flow
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req dto.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(apperrors.FromBinding(err))
+		return
+	}
+
+	if err := h.authService.ForgotPassword(c.Request.Context(), &req); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If an account exists for that email, a password reset link has been sent"})
+}
+
+// ResetPassword completes the password reset flow
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req dto.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(apperrors.FromBinding(err))
+		return
+	}
+
+	if err := h.authService.ResetPassword(c.Request.Context(), &req); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
+
+// VerifyEmail confirms an email address
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req dto.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(apperrors.FromBinding(err))
+		return
+	}
+
+	user, err := h.authService.VerifyEmail(c.Request.Context(), &req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// ResendVerification sends a new email verification link
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	var req dto.ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(apperrors.FromBinding(err))
+		return
+	}
+
+	if err := h.authService.ResendVerification(c.Request.Context(), &req); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the account needs verification, a new link has been sent"})
+}
+
+// ChangePassword changes the password of a user by ID, given its current one
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		_ = c.Error(apperrors.BadRequest("invalid_user_id", "Invalid user ID"))
+		return
+	}
+
+	var req dto.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(apperrors.FromBinding(err))
+		return
+	}
+
+	response, err := h.authService.ChangePassword(c.Request.Context(), uint(id), &req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// EnrollMFA starts TOTP enrollment for the authenticated user
+func (h *AuthHandler) EnrollMFA(c *gin.Context) {
+	// Get user info from JWT middleware
+	userID, exists := c.Get("user_id")
+	if !exists {
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
+		return
+	}
+
+	response, err := h.authService.EnrollMFA(c.Request.Context(), userID.(uint))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ConfirmMFA enables MFA after checking a code from the new authenticator
+func (h *AuthHandler) ConfirmMFA(c *gin.Context) {
+	var req dto.MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(apperrors.FromBinding(err))
+		return
+	}
+
+	// Get user info from JWT middleware
+	userID, exists := c.Get("user_id")
+	if !exists {
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
+		return
+	}
+
+	response, err := h.authService.ConfirmMFA(c.Request.Context(), userID.(uint), &req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// DisableMFA turns MFA off for the authenticated user
+func (h *AuthHandler) DisableMFA(c *gin.Context) {
+	var req dto.MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(apperrors.FromBinding(err))
+		return
+	}
+
+	// Get user info from JWT middleware
+	userID, exists := c.Get("user_id")
+	if !exists {
+		_ = c.Error(apperrors.Unauthorized("unauthenticated", "User not authenticated"))
+		return
+	}
+
+	if err := h.authService.DisableMFA(c.Request.Context(), userID.(uint), &req); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// VerifyMFA completes a login that requires a second factor
+func (h *AuthHandler) VerifyMFA(c *gin.Context) {
+	var req dto.MFAVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		_ = c.Error(apperrors.FromBinding(err))
+		return
+	}
+
+	response, err := h.authService.VerifyMFA(c.Request.Context(), &req)
+	if err != nil {
+		_ = c.Error(err)
 		return
 	}
 
@@ -3,6 +3,8 @@ package handlers
 import (
 	"net/http"
 	"strconv"
+	"user_service/internal/apperrors"
+	"user_service/internal/constants"
 	"user_service/internal/dto/user"
 	"user_service/internal/service/user"
 
@@ -21,17 +23,13 @@ func NewUserHandler(userService *service.UserService) *UserHandler {
 func (h *UserHandler) CreateUser(c *gin.Context) {
 	var req dto.CreateUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		_ = c.Error(apperrors.FromBinding(err))
 		return
 	}
 
-	user, err := h.userService.CreateUser(&req)
+	user, err := h.userService.CreateUser(c.Request.Context(), &req)
 	if err != nil {
-		status := http.StatusInternalServerError
-		if err.Error() == "email already exists" || err.Error() == "username already exists" {
-			status = http.StatusConflict
-		}
-		c.JSON(status, gin.H{"error": err.Error()})
+		_ = c.Error(err)
 		return
 	}
 
@@ -42,17 +40,13 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 func (h *UserHandler) GetUser(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		_ = c.Error(apperrors.BadRequest("invalid_user_id", "Invalid user ID"))
 		return
 	}
 
-	user, err := h.userService.GetUser(uint(id))
+	user, err := h.userService.GetUser(c.Request.Context(), uint(id))
 	if err != nil {
-		status := http.StatusInternalServerError
-		if err.Error() == "user not found" {
-			status = http.StatusNotFound
-		}
-		c.JSON(status, gin.H{"error": err.Error()})
+		_ = c.Error(err)
 		return
 	}
 
@@ -64,25 +58,25 @@ func (h *UserHandler) GetUser(c *gin.Context) {
 func (h *UserHandler) UpdateUser(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		_ = c.Error(apperrors.BadRequest("invalid_user_id", "Invalid user ID"))
 		return
 	}
 
 	var req dto.UpdateUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		_ = c.Error(apperrors.FromBinding(err))
 		return
 	}
 
-	user, err := h.userService.UpdateUser(uint(id), &req)
+	// Only admins can change roles
+	if req.Role != nil && c.GetString("user_role") != constants.UserRoleAdmin {
+		_ = c.Error(apperrors.Forbidden("access_denied", "Access denied"))
+		return
+	}
+
+	user, err := h.userService.UpdateUser(c.Request.Context(), uint(id), &req)
 	if err != nil {
-		status := http.StatusInternalServerError
-		if err.Error() == "user not found" {
-			status = http.StatusNotFound
-		} else if err.Error() == "email already exists" || err.Error() == "username already exists" {
-			status = http.StatusConflict
-		}
-		c.JSON(status, gin.H{"error": err.Error()})
+		_ = c.Error(err)
 		return
 	}
 
@@ -93,16 +87,12 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 func (h *UserHandler) DeleteUser(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		_ = c.Error(apperrors.BadRequest("invalid_user_id", "Invalid user ID"))
 		return
 	}
 
-	if err := h.userService.DeleteUser(uint(id)); err != nil {
-		status := http.StatusInternalServerError
-		if err.Error() == "user not found" {
-			status = http.StatusNotFound
-		}
-		c.JSON(status, gin.H{"error": err.Error()})
+	if err := h.userService.DeleteUser(c.Request.Context(), uint(id)); err != nil {
+		_ = c.Error(err)
 		return
 	}
 
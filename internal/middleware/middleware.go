@@ -1,13 +1,23 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+	"user_service/internal/apperrors"
+	"user_service/internal/constants"
 	userServices "user_service/internal/service/user"
+	"user_service/internal/throttle"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
 )
 
 // Logger middleware for request logging
@@ -49,21 +59,38 @@ func CORS() gin.HandlerFunc {
 	}
 }
 
+// Timeout middleware puts a deadline on the request context, which services
+// and repositories pass on to the database. A zero duration disables it, as
+// do the exempt route patterns, meant for long-lived responses such as event
+// streams.
+func Timeout(d time.Duration, exempt ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if d <= 0 || slices.Contains(exempt, c.FullPath()) {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+	}
+}
+
 // Auth middleware for JWT authentication
 func Auth(authService *userServices.AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get Authorization header
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			c.Abort()
+			abortWithError(c, apperrors.Unauthorized("missing_token", "Authorization header required"))
 			return
 		}
 
 		// Check if it's a Bearer token
 		if !strings.HasPrefix(authHeader, "Bearer ") {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
-			c.Abort()
+			abortWithError(c, apperrors.Unauthorized("invalid_token", "Invalid authorization header format"))
 			return
 		}
 
@@ -71,10 +98,12 @@ func Auth(authService *userServices.AuthService) gin.HandlerFunc {
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
 		// Validate JWT token
-		claims, err := authService.ValidateJWT(tokenString)
+		claims, err := authService.ValidateJWT(c.Request.Context(), tokenString)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
-			c.Abort()
+			if apperrors.As(err) == nil {
+				err = apperrors.Unauthorized("invalid_token", "Invalid or expired token")
+			}
+			abortWithError(c, err)
 			return
 		}
 
@@ -82,7 +111,147 @@ func Auth(authService *userServices.AuthService) gin.HandlerFunc {
 		c.Set("user_id", claims.UserID)
 		c.Set("user_email", claims.Email)
 		c.Set("user_username", claims.Username)
+		c.Set("user_role", claims.Role)
+		c.Set("user_email_verified", claims.EmailVerified)
+		c.Set("claims", claims)
+
+		c.Next()
+	}
+}
+
+// RequireVerifiedEmail middleware rejects users whose email is not verified.
+// It is a no-op when required is false and must run after Auth.
+func RequireVerifiedEmail(required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if required && !c.GetBool("user_email_verified") {
+			abortWithError(c, apperrors.Forbidden("email_not_verified", "Email address not verified"))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireRole middleware only lets through users with one of the given roles.
+// It must run after Auth.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("user_role")
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		abortWithError(c, apperrors.Forbidden("access_denied", "Access denied"))
+	}
+}
+
+// RequireSelfOrAdmin middleware only lets users act on their own record, as
+// identified by the given URL parameter, unless they are an admin. It must run
+// after Auth.
+func RequireSelfOrAdmin(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("user_role") == constants.UserRoleAdmin {
+			c.Next()
+			return
+		}
+
+		id, err := strconv.ParseUint(c.Param(param), 10, 32)
+		if err != nil || uint(id) != c.GetUint("user_id") {
+			abortWithError(c, apperrors.Forbidden("access_denied", "Access denied"))
+			return
+		}
 
 		c.Next()
 	}
 }
+
+// RateLimit middleware limits requests per client IP for a route. scope keeps
+// the counters of different routes apart.
+func RateLimit(limiter *throttle.RateLimiter, scope string, limit int, window time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, retryAfter, err := limiter.Allow(c.Request.Context(), scope+":ip:"+c.ClientIP(), limit, window)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		if !allowed {
+			abortWithError(c, apperrors.TooManyRequests("rate_limited", "Too many requests", retryAfter))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// SetRetryAfter sets the Retry-After header, rounded up to whole seconds
+func SetRetryAfter(c *gin.Context, d time.Duration) {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+}
+
+// Problem is an RFC 7807 problem details response body
+type Problem struct {
+	Type     string                 `json:"type"`
+	Title    string                 `json:"title"`
+	Status   int                    `json:"status"`
+	Detail   string                 `json:"detail,omitempty"`
+	Instance string                 `json:"instance,omitempty"`
+	Code     string                 `json:"code"`
+	Errors   []apperrors.FieldError `json:"errors,omitempty"`
+}
+
+// ErrorHandler middleware renders the last error added with c.Error as an
+// application/problem+json response. Errors that are not *apperrors.Error are
+// logged and reported as a generic internal error so that details such as
+// database messages never reach clients. Errors caused by the request deadline
+// passing are reported as a timeout, whatever the driver wrapped them in.
+func ErrorHandler() gin.HandlerFunc {
+	apperrors.UseJSONFieldNames()
+
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		err := c.Errors.Last().Err
+		appErr := apperrors.As(err)
+		if appErr == nil && (errors.Is(err, context.DeadlineExceeded) || errors.Is(c.Request.Context().Err(), context.DeadlineExceeded)) {
+			appErr = &apperrors.Error{Kind: apperrors.KindTimeout, Code: "request_timeout", Message: "request timed out", Err: err}
+		}
+		if appErr == nil {
+			log.Printf("internal error on %s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+			appErr = &apperrors.Error{Kind: apperrors.KindInternal, Code: "internal_error", Message: "internal server error", Err: err}
+		}
+
+		status := appErr.Status()
+		if appErr.RetryAfter > 0 {
+			SetRetryAfter(c, appErr.RetryAfter)
+		}
+
+		c.Header("Content-Type", "application/problem+json")
+		c.Render(status, render.JSON{Data: Problem{
+			Type:     "about:blank",
+			Title:    http.StatusText(status),
+			Status:   status,
+			Detail:   appErr.Message,
+			Instance: c.Request.URL.Path,
+			Code:     appErr.Code,
+			Errors:   appErr.Fields,
+		}})
+	}
+}
+
+// abortWithError records err for ErrorHandler and stops the handler chain
+func abortWithError(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
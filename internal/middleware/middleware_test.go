@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+	"user_service/config"
+	"user_service/internal/constants"
+	"user_service/internal/database"
+	dto "user_service/internal/dto/user"
+	"user_service/internal/mailer"
+	"user_service/internal/repository"
+	userServices "user_service/internal/service/user"
+	"user_service/internal/throttle"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ctx = context.Background()
+
+// session is a registered user and their access token
+type session struct {
+	userID uint
+	token  string
+}
+
+// newAuthRouter returns a router with an AuthService on a fresh SQLite
+// database, and sessions for a normal user and an admin
+func newAuthRouter(t *testing.T) (*gin.Engine, *userServices.AuthService, session, session) {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-secret")
+	gin.SetMode(gin.TestMode)
+
+	db, err := database.InitDB(&config.Config{DatabaseURL: ":memory:"})
+	if err != nil {
+		t.Fatalf("failed to open SQLite database: %v", err)
+	}
+	users := repository.NewUserRepository(db)
+	mail := mailer.NewLogMailer(filepath.Join(t.TempDir(), "mail.log"))
+	auth := userServices.NewAuthService(users,
+		repository.NewRefreshTokenRepository(db),
+		repository.NewRevokedTokenRepository(db),
+		repository.NewPasswordResetTokenRepository(db),
+		userServices.NewEmailVerifier(users, mail, "http://app.test", userServices.EmailVerificationOff),
+		repository.NewMFARecoveryCodeRepository(db),
+		repository.NewTransactor(db),
+		throttle.NewLockout(throttle.NewMemoryStore(), 5, time.Minute, time.Hour),
+		mail, "http://app.test")
+
+	register := func(name string) session {
+		response, err := auth.Register(ctx, &dto.RegisterRequest{
+			Email:     name + "@example.com",
+			Username:  name,
+			Password:  "secret123",
+			FirstName: "Test",
+			LastName:  "User",
+		})
+		if err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+		return session{userID: response.User.UserID, token: response.Token}
+	}
+	user := register("alice")
+	admin := register("carol")
+
+	record, err := users.GetByID(ctx, admin.userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record.Role = constants.UserRoleAdmin
+	if err := users.Update(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	response, err := auth.Login(ctx, &dto.LoginRequest{Email: "carol@example.com", Password: "secret123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin.token = response.Token
+
+	router := gin.New()
+	router.Use(ErrorHandler())
+	return router, auth, user, admin
+}
+
+func ok(c *gin.Context) {
+	c.Status(http.StatusOK)
+}
+
+// serve sends a GET request with an optional bearer token and returns the
+// response status
+func serve(router *gin.Engine, path, token string) int {
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+func TestAuthorization(t *testing.T) {
+	router, auth, user, admin := newAuthRouter(t)
+	router.GET("/admin", Auth(auth), RequireRole(constants.UserRoleAdmin), ok)
+	router.GET("/users/:id", Auth(auth), RequireSelfOrAdmin("id"), ok)
+
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		want  int
+	}{
+		{"RoleWithoutToken", "/admin", "", http.StatusUnauthorized},
+		{"RoleWithInvalidToken", "/admin", "invalid", http.StatusUnauthorized},
+		{"RoleAsUser", "/admin", user.token, http.StatusForbidden},
+		{"RoleAsAdmin", "/admin", admin.token, http.StatusOK},
+		{"SelfWithoutToken", fmt.Sprintf("/users/%d", user.userID), "", http.StatusUnauthorized},
+		{"SelfAsOther", fmt.Sprintf("/users/%d", admin.userID), user.token, http.StatusForbidden},
+		{"SelfAsSelf", fmt.Sprintf("/users/%d", user.userID), user.token, http.StatusOK},
+		{"SelfAsAdmin", fmt.Sprintf("/users/%d", user.userID), admin.token, http.StatusOK},
+		{"SelfWithMalformedID", "/users/abc", user.token, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serve(router, tt.path, tt.token); got != tt.want {
+				t.Fatalf("got status %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(ErrorHandler())
+	limiter := throttle.NewRateLimiter(throttle.NewMemoryStore())
+	router.GET("/login", RateLimit(limiter, "login", 2, time.Minute), ok)
+	router.GET("/register", RateLimit(limiter, "register", 2, time.Minute), ok)
+
+	for i := 1; i <= 2; i++ {
+		if got := serve(router, "/login", ""); got != http.StatusOK {
+			t.Fatalf("request %d: got status %d, want %d", i, got, http.StatusOK)
+		}
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/login", nil)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want %d", recorder.Code, http.StatusTooManyRequests)
+	}
+	if recorder.Header().Get("Retry-After") == "" {
+		t.Fatal("missing Retry-After header")
+	}
+
+	// Scopes count separately
+	if got := serve(router, "/register", ""); got != http.StatusOK {
+		t.Fatalf("other scope: got status %d, want %d", got, http.StatusOK)
+	}
+}
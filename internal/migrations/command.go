@@ -0,0 +1,131 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"gorm.io/gorm"
+)
+
+const usage = `usage: migrate <command>
+
+commands:
+  up             apply all pending migrations
+  down [N|all]   roll back the last N migrations (default 1)
+  status         list migrations and whether they are applied
+  create NAME    create a new pair of migration files for each dialect in ` + Dir
+
+var nonNameChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Run executes a migrate subcommand and writes its output to out. open is
+// only called for subcommands that need the database.
+func Run(args []string, open func() (*gorm.DB, error), out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	// create only touches the filesystem, so it does not need a database
+	if args[0] == "create" {
+		if len(args) != 2 {
+			return errors.New(usage)
+		}
+		paths, err := Create(Dir, args[1])
+		for _, path := range paths {
+			fmt.Fprintf(out, "Created %s\n", path)
+		}
+		return err
+	}
+
+	db, err := open()
+	if err != nil {
+		return err
+	}
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		fmt.Fprintf(out, "Applied %d migration(s)\n", applied)
+		return err
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if args[1] == "all" {
+				steps = len(migrator.migrations)
+			} else if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		rolledBack, err := migrator.Down(steps)
+		fmt.Fprintf(out, "Rolled back %d migration(s)\n", rolledBack)
+		return err
+
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, s := range statuses {
+			state, appliedAt := "pending", ""
+			if s.AppliedAt != nil {
+				state, appliedAt = "applied", s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			if s.Missing {
+				state = "applied (missing file)"
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+		}
+		return w.Flush()
+
+	default:
+		return errors.New(usage)
+	}
+}
+
+// Create writes an empty up and down migration for every dialect under dir,
+// numbered after the highest existing version, and returns their paths
+func Create(dir, name string) ([]string, error) {
+	name = strings.Trim(nonNameChars.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return nil, errors.New("migration name must contain letters or digits")
+	}
+
+	// Keep versions aligned across dialects
+	var version int64 = 1
+	for _, dialect := range Dialects {
+		migrations, err := Load(os.DirFS(filepath.Join(dir, dialect)))
+		if err != nil {
+			return nil, err
+		}
+		if n := len(migrations); n > 0 && migrations[n-1].Version >= version {
+			version = migrations[n-1].Version + 1
+		}
+	}
+
+	var paths []string
+	for _, dialect := range Dialects {
+		base := filepath.Join(dir, dialect, fmt.Sprintf("%04d_%s", version, name))
+		if err := os.WriteFile(base+".up.sql", []byte("-- "+name+"\n"), 0644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, base+".up.sql")
+		if err := os.WriteFile(base+".down.sql", []byte("-- Revert "+name+"\n"), 0644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, base+".down.sql")
+	}
+	return paths, nil
+}
@@ -0,0 +1,75 @@
+package migrations_test
+
+import (
+	"testing"
+	"user_service/config"
+	"user_service/internal/database"
+	"user_service/internal/migrations"
+
+	"gorm.io/gorm"
+)
+
+// TestSQLiteMigrations applies and rolls back every SQLite migration on a
+// fresh in-memory database
+func TestSQLiteMigrations(t *testing.T) {
+	db, err := database.Open(&config.Config{DatabaseURL: ":memory:"})
+	if err != nil {
+		t.Fatalf("failed to open SQLite database: %v", err)
+	}
+	testRoundTrip(t, db)
+}
+
+// testRoundTrip applies every migration, rolls them all back one at a time,
+// and applies them again. db must not have any migrations applied.
+func testRoundTrip(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	migrator, err := migrations.NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := len(statuses)
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			t.Fatalf("migration %d is already applied; use an empty database", status.Version)
+		}
+	}
+
+	if applied, err := migrator.Up(); err != nil || applied != total {
+		t.Fatalf("up: applied %d of %d migrations: %v", applied, total, err)
+	}
+	if applied, err := migrator.Up(); err != nil || applied != 0 {
+		t.Fatalf("second up: applied %d migrations: %v", applied, err)
+	}
+	assertTables(t, db, true)
+
+	// Every down migration must undo its up migration far enough for the
+	// previous down migration to run
+	for i := total; i > 0; i-- {
+		if rolledBack, err := migrator.Down(1); err != nil || rolledBack != 1 {
+			t.Fatalf("down to %d: rolled back %d migrations: %v", i-1, rolledBack, err)
+		}
+	}
+	if rolledBack, err := migrator.Down(1); err != nil || rolledBack != 0 {
+		t.Fatalf("down past the first migration: rolled back %d migrations: %v", rolledBack, err)
+	}
+	assertTables(t, db, false)
+
+	if applied, err := migrator.Up(); err != nil || applied != total {
+		t.Fatalf("up after down: applied %d of %d migrations: %v", applied, total, err)
+	}
+	assertTables(t, db, true)
+}
+
+// assertTables checks whether the tables created by the migrations exist
+func assertTables(t *testing.T, db *gorm.DB, exist bool) {
+	t.Helper()
+	for _, table := range []string{"users", "refresh_tokens", "conversations", "messages", "attachments"} {
+		if db.Migrator().HasTable(table) != exist {
+			t.Fatalf("table %s: got exists=%v, want %v", table, !exist, exist)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+//go:build postgres
+
+package migrations_test
+
+import (
+	"os"
+	"testing"
+	"user_service/config"
+	"user_service/internal/database"
+	"user_service/internal/migrations"
+
+	"gorm.io/gorm"
+)
+
+// openPostgres connects to the empty database named by TEST_DATABASE_URL, and
+// rolls back every migration when the test ends. The test is skipped when the
+// variable is not set.
+func openPostgres(t *testing.T) *gorm.DB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := database.Open(&config.Config{DatabaseURL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrator, err := migrations.NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := migrator.Down(1 << 30); err != nil {
+			t.Errorf("failed to roll back migrations: %v", err)
+		}
+	})
+	return db
+}
+
+// TestPostgresMigrations applies and rolls back every Postgres migration
+func TestPostgresMigrations(t *testing.T) {
+	testRoundTrip(t, openPostgres(t))
+}
+
+// TestPostgresMigrationsAreIdempotent applies every Postgres migration twice
+// in a row, as happens when a migration is retried on a schema that already
+// has its changes
+func TestPostgresMigrationsAreIdempotent(t *testing.T) {
+	db := openPostgres(t)
+	list, err := migrations.Load(os.DirFS("sql/postgres"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := db.Begin()
+	defer tx.Rollback()
+	for _, migration := range list {
+		for run := 1; run <= 2; run++ {
+			if err := tx.Exec(migration.Up).Error; err != nil {
+				t.Fatalf("migration %d_%s, run %d: %v", migration.Version, migration.Name, run, err)
+			}
+		}
+	}
+}
+
+// TestPostgresActiveLeafReference checks the foreign key from a conversation
+// to the leaf of its active branch, which points back into its own messages
+func TestPostgresActiveLeafReference(t *testing.T) {
+	db := openPostgres(t)
+	migrator, err := migrations.NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	exec := func(sql string, args ...interface{}) {
+		t.Helper()
+		if err := db.Exec(sql, args...).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	activeLeaf := func() *string {
+		t.Helper()
+		var leaf *string
+		if err := db.Raw("SELECT active_leaf_id FROM conversations").Row().Scan(&leaf); err != nil {
+			t.Fatal(err)
+		}
+		return leaf
+	}
+	const (
+		conversationID = "00000000-0000-0000-0000-000000000001"
+		rootID         = "00000000-0000-0000-0000-000000000002"
+		leafID         = "00000000-0000-0000-0000-000000000003"
+	)
+
+	exec("INSERT INTO users (user_id, email, username, password) VALUES (1, 'alice@example.com', 'alice', 'x')")
+	exec("INSERT INTO conversations (conversation_id, user_id, title, created_at, updated_at) VALUES (?, 1, 'Chat', NOW(), NOW())", conversationID)
+	exec("INSERT INTO messages (message_id, conversation_id, sender, content, timestamp) VALUES (?, ?, 'user', 'hi', NOW())", rootID, conversationID)
+	exec("INSERT INTO messages (message_id, conversation_id, parent_message_id, sender, content, timestamp) VALUES (?, ?, ?, 'ai', 'hello', NOW())", leafID, conversationID, rootID)
+
+	// The active leaf must be a message
+	if err := db.Exec("UPDATE conversations SET active_leaf_id = ?", conversationID).Error; err == nil {
+		t.Fatal("active leaf accepted an id that is not a message")
+	}
+
+	// Deleting the leaf clears it
+	exec("UPDATE conversations SET active_leaf_id = ?", leafID)
+	exec("DELETE FROM messages WHERE message_id = ?", leafID)
+	if leaf := activeLeaf(); leaf != nil {
+		t.Fatalf("got active leaf %s after deleting it", *leaf)
+	}
+
+	// A conversation, and the user owning it, can be deleted while it
+	// points at one of its messages
+	exec("UPDATE conversations SET active_leaf_id = ?", rootID)
+	exec("DELETE FROM users WHERE user_id = 1")
+	var remaining int64
+	if err := db.Raw("SELECT (SELECT COUNT(*) FROM conversations) + (SELECT COUNT(*) FROM messages)").Row().Scan(&remaining); err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 0 {
+		t.Fatalf("%d conversations and messages remain after deleting their user", remaining)
+	}
+
+	// Rolling back 0005 drops the reference with the column
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Down(len(statuses) - 4); err != nil {
+		t.Fatal(err)
+	}
+	if db.Migrator().HasColumn("conversations", "active_leaf_id") {
+		t.Fatal("active_leaf_id remains after rolling back 0005_message_tree")
+	}
+}
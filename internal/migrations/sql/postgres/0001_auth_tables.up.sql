@@ -0,0 +1,74 @@
+-- Users and authentication state. Statements are idempotent so that databases
+-- previously created by GORM AutoMigrate can adopt versioned migrations.
+
+CREATE TABLE IF NOT EXISTS users (
+    user_id             BIGSERIAL PRIMARY KEY,
+    email               TEXT NOT NULL,
+    username            TEXT NOT NULL,
+    password            TEXT NOT NULL,
+    first_name          TEXT,
+    last_name           TEXT,
+    role                VARCHAR(20) NOT NULL DEFAULT 'user',
+    email_verified_at   TIMESTAMPTZ,
+    pending_email       TEXT,
+    password_changed_at TIMESTAMPTZ,
+    mfa_secret          TEXT,
+    mfa_enabled_at      TIMESTAMPTZ,
+    mfa_last_step       BIGINT NOT NULL DEFAULT 0,
+    token_version       BIGINT NOT NULL DEFAULT 0,
+    created_at          TIMESTAMPTZ,
+    updated_at          TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users (username);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    token_id    BIGSERIAL PRIMARY KEY,
+    user_id     BIGINT NOT NULL,
+    family_id   VARCHAR(36) NOT NULL,
+    token_hash  VARCHAR(64) NOT NULL,
+    expires_at  TIMESTAMPTZ NOT NULL,
+    revoked_at  TIMESTAMPTZ,
+    replaced_by BIGINT,
+    created_at  TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_token_hash ON refresh_tokens (token_hash);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti        VARCHAR(36) PRIMARY KEY,
+    user_id    BIGINT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_user_id ON revoked_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    token_id   BIGSERIAL PRIMARY KEY,
+    user_id    BIGINT NOT NULL,
+    token_hash VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_user_id ON password_reset_tokens (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_password_reset_tokens_token_hash ON password_reset_tokens (token_hash);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    code_id    BIGSERIAL PRIMARY KEY,
+    user_id    BIGINT NOT NULL,
+    code_hash  VARCHAR(64) NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user_id ON mfa_recovery_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    attempt_key  VARCHAR(255) PRIMARY KEY,
+    count        BIGINT NOT NULL DEFAULT 0,
+    window_end   TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_window_end ON login_attempts (window_end);
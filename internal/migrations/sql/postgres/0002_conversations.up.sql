@@ -0,0 +1,33 @@
+-- Conversations and their messages. Messages are removed together with their
+-- conversation, and conversations together with their owner.
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'sender_role') THEN
+        CREATE TYPE sender_role AS ENUM ('user', 'ai', 'system');
+    END IF;
+END
+$$;
+
+CREATE TABLE IF NOT EXISTS conversations (
+    conversation_id UUID PRIMARY KEY,
+    user_id         BIGINT NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    title           VARCHAR(255) NOT NULL,
+    model_used      VARCHAR(100),
+    created_at      TIMESTAMPTZ NOT NULL,
+    updated_at      TIMESTAMPTZ NOT NULL,
+    is_pinned       BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS idx_conversations_user_id ON conversations (user_id, updated_at DESC);
+
+CREATE TABLE IF NOT EXISTS messages (
+    message_id        UUID PRIMARY KEY,
+    conversation_id   UUID NOT NULL REFERENCES conversations (conversation_id) ON DELETE CASCADE,
+    parent_message_id UUID,
+    sender            sender_role NOT NULL,
+    content           TEXT NOT NULL,
+    metadata          JSONB,
+    timestamp         TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_id ON messages (conversation_id, timestamp);
+CREATE INDEX IF NOT EXISTS idx_messages_parent_message_id ON messages (parent_message_id);
@@ -0,0 +1,5 @@
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_id ON messages (conversation_id, timestamp);
+DROP INDEX IF EXISTS idx_messages_conversation_timeline;
+
+CREATE INDEX IF NOT EXISTS idx_conversations_user_id ON conversations (user_id, updated_at DESC);
+DROP INDEX IF EXISTS idx_conversations_user_list;
@@ -0,0 +1,6 @@
+-- Full-text indexes for conversation search. The indexed expressions must
+-- match the ones in the search queries, including the text search
+-- configuration.
+
+CREATE INDEX IF NOT EXISTS idx_conversations_title_search ON conversations USING GIN (to_tsvector('english', title));
+CREATE INDEX IF NOT EXISTS idx_messages_content_search ON messages USING GIN (to_tsvector('english', content));
@@ -0,0 +1,21 @@
+-- Messages form a tree through parent_message_id, and each conversation
+-- tracks the leaf of its active branch. Existing conversations become a single
+-- branch: every message is chained to the one before it.
+
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS active_leaf_id UUID REFERENCES messages (message_id) ON DELETE SET NULL;
+
+UPDATE messages SET parent_message_id = (
+    SELECT previous.message_id FROM messages previous
+    WHERE previous.conversation_id = messages.conversation_id
+      AND (previous.timestamp, previous.message_id) < (messages.timestamp, messages.message_id)
+    ORDER BY previous.timestamp DESC, previous.message_id DESC
+    LIMIT 1
+)
+WHERE parent_message_id IS NULL;
+
+UPDATE conversations SET active_leaf_id = (
+    SELECT message_id FROM messages
+    WHERE messages.conversation_id = conversations.conversation_id
+    ORDER BY timestamp DESC, message_id DESC
+    LIMIT 1
+);
@@ -0,0 +1,4 @@
+-- Messages edited in place record when it happened. Other edits add a
+-- sibling message instead.
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ;
@@ -0,0 +1,6 @@
+-- Redacted messages keep their place in the tree; their content is replaced
+-- with a tombstone and the redaction is recorded. redacted_by is kept when the
+-- user is deleted, so it has no foreign key.
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS redacted_at TIMESTAMPTZ;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS redacted_by BIGINT;
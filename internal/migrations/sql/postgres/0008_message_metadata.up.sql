@@ -0,0 +1,5 @@
+-- Index for filtering messages by metadata values, which uses jsonb
+-- containment (@>). jsonb_path_ops indexes only support containment, and are
+-- smaller than the default operator class.
+
+CREATE INDEX IF NOT EXISTS idx_messages_metadata ON messages USING GIN (metadata jsonb_path_ops);
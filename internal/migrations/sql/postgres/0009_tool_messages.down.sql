@@ -0,0 +1,15 @@
+-- Fails while tool messages exist, as the enum has no tool role; delete them
+-- first.
+
+ALTER TABLE messages DROP COLUMN IF EXISTS tool_name;
+ALTER TABLE messages DROP COLUMN IF EXISTS tool_call_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS tool_calls;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'sender_role') THEN
+        CREATE TYPE sender_role AS ENUM ('user', 'ai', 'system');
+    END IF;
+END
+$$;
+ALTER TABLE messages ALTER COLUMN sender TYPE sender_role USING sender::sender_role;
@@ -0,0 +1,14 @@
+-- Tool calls and their results. AI messages can request tool calls, stored
+-- as a JSON array in tool_calls; a tool message answers one of them and
+-- records its id and tool name.
+--
+-- Sender roles are validated by the application against
+-- constants.ValidSenderRoles, so the sender_role enum is replaced with text
+-- instead of gaining a value.
+
+ALTER TABLE messages ALTER COLUMN sender TYPE VARCHAR(20) USING sender::text;
+DROP TYPE IF EXISTS sender_role;
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS tool_calls JSONB;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS tool_call_id VARCHAR(100);
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS tool_name VARCHAR(100);
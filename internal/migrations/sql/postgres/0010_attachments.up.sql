@@ -0,0 +1,19 @@
+-- Attachments and message parts. Attachments are files uploaded to a
+-- conversation, whose content is kept in the blob store under storage_key.
+-- Messages can be composed of typed parts, stored as a JSON array in parts,
+-- some of which reference attachments.
+
+CREATE TABLE IF NOT EXISTS attachments (
+    attachment_id   UUID PRIMARY KEY,
+    conversation_id UUID NOT NULL REFERENCES conversations (conversation_id) ON DELETE CASCADE,
+    user_id         BIGINT NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    filename        VARCHAR(255) NOT NULL,
+    content_type    VARCHAR(100) NOT NULL,
+    size_bytes      BIGINT NOT NULL,
+    storage_key     VARCHAR(255) NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_attachments_conversation_id ON attachments (conversation_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_attachments_user_id ON attachments (user_id);
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS parts JSONB;
@@ -0,0 +1,9 @@
+-- Message status, for AI messages streamed in chunks: pending when opened,
+-- streaming while chunks arrive, then complete or failed. Other messages are
+-- complete when added. Statuses are validated by the application against
+-- constants.ValidMessageStatuses. streamed_at records when the content of an
+-- open message was last saved, so that streams abandoned by a crashed or
+-- frozen server can be found and marked failed.
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'complete';
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS streamed_at TIMESTAMPTZ;
@@ -0,0 +1,25 @@
+-- Conversations and their messages (SQLite). UUIDs are stored as text, the
+-- sender_role enum and JSONB metadata are enforced with CHECK constraints.
+
+CREATE TABLE IF NOT EXISTS conversations (
+    conversation_id TEXT PRIMARY KEY,
+    user_id         INTEGER NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    title           VARCHAR(255) NOT NULL,
+    model_used      VARCHAR(100),
+    created_at      DATETIME NOT NULL,
+    updated_at      DATETIME NOT NULL,
+    is_pinned       BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS idx_conversations_user_id ON conversations (user_id, updated_at DESC);
+
+CREATE TABLE IF NOT EXISTS messages (
+    message_id        TEXT PRIMARY KEY,
+    conversation_id   TEXT NOT NULL REFERENCES conversations (conversation_id) ON DELETE CASCADE,
+    parent_message_id TEXT,
+    sender            TEXT NOT NULL CHECK (sender IN ('user', 'ai', 'system')),
+    content           TEXT NOT NULL,
+    metadata          TEXT CHECK (metadata IS NULL OR json_valid(metadata)),
+    timestamp         DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_id ON messages (conversation_id, timestamp);
+CREATE INDEX IF NOT EXISTS idx_messages_parent_message_id ON messages (parent_message_id);
@@ -0,0 +1,9 @@
+-- Composite indexes matching the keyset pagination order of conversation
+-- lists (pinned first, then most recently updated) and message history
+-- (oldest first). The IDs make every key unique.
+
+CREATE INDEX IF NOT EXISTS idx_conversations_user_list ON conversations (user_id, is_pinned, updated_at, conversation_id);
+DROP INDEX IF EXISTS idx_conversations_user_id;
+
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_timeline ON messages (conversation_id, timestamp, message_id);
+DROP INDEX IF EXISTS idx_messages_conversation_id;
@@ -0,0 +1,4 @@
+-- SQLite has no full-text indexes in this schema: conversation search falls
+-- back to LIKE, which cannot use an index. This migration only keeps the
+-- versions aligned with Postgres.
+SELECT 1;
@@ -0,0 +1 @@
+ALTER TABLE conversations DROP COLUMN active_leaf_id;
@@ -0,0 +1,2 @@
+ALTER TABLE messages DROP COLUMN redacted_by;
+ALTER TABLE messages DROP COLUMN redacted_at;
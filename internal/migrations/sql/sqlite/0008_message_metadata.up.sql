@@ -0,0 +1,3 @@
+-- SQLite has no index for metadata filters, which extract values with ->.
+-- This migration only keeps the versions aligned with Postgres.
+SELECT 1;
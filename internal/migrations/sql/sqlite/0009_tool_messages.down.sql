@@ -0,0 +1,22 @@
+-- Fails while tool messages exist, as the restored CHECK constraint has no
+-- tool role; delete them first.
+
+CREATE TABLE messages_rebuild (
+    message_id        TEXT PRIMARY KEY,
+    conversation_id   TEXT NOT NULL REFERENCES conversations (conversation_id) ON DELETE CASCADE,
+    parent_message_id TEXT,
+    sender            TEXT NOT NULL CHECK (sender IN ('user', 'ai', 'system')),
+    content           TEXT NOT NULL,
+    metadata          TEXT CHECK (metadata IS NULL OR json_valid(metadata)),
+    timestamp         DATETIME NOT NULL,
+    edited_at         DATETIME,
+    redacted_at       DATETIME,
+    redacted_by       INTEGER
+);
+INSERT INTO messages_rebuild (message_id, conversation_id, parent_message_id, sender, content, metadata, timestamp, edited_at, redacted_at, redacted_by)
+SELECT message_id, conversation_id, parent_message_id, sender, content, metadata, timestamp, edited_at, redacted_at, redacted_by FROM messages;
+DROP TABLE messages;
+ALTER TABLE messages_rebuild RENAME TO messages;
+
+CREATE INDEX IF NOT EXISTS idx_messages_parent_message_id ON messages (parent_message_id);
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_timeline ON messages (conversation_id, timestamp, message_id);
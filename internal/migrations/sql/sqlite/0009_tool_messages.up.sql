@@ -0,0 +1,30 @@
+-- Tool calls and their results. AI messages can request tool calls, stored
+-- as a JSON array in tool_calls; a tool message answers one of them and
+-- records its id and tool name.
+--
+-- Sender roles are validated by the application against
+-- constants.ValidSenderRoles, so the CHECK constraint on sender is dropped.
+-- SQLite cannot alter a constraint, so the table is rebuilt.
+
+CREATE TABLE messages_rebuild (
+    message_id        TEXT PRIMARY KEY,
+    conversation_id   TEXT NOT NULL REFERENCES conversations (conversation_id) ON DELETE CASCADE,
+    parent_message_id TEXT,
+    sender            VARCHAR(20) NOT NULL,
+    content           TEXT NOT NULL,
+    metadata          TEXT CHECK (metadata IS NULL OR json_valid(metadata)),
+    timestamp         DATETIME NOT NULL,
+    edited_at         DATETIME,
+    redacted_at       DATETIME,
+    redacted_by       INTEGER,
+    tool_calls        TEXT CHECK (tool_calls IS NULL OR json_valid(tool_calls)),
+    tool_call_id      VARCHAR(100),
+    tool_name         VARCHAR(100)
+);
+INSERT INTO messages_rebuild (message_id, conversation_id, parent_message_id, sender, content, metadata, timestamp, edited_at, redacted_at, redacted_by)
+SELECT message_id, conversation_id, parent_message_id, sender, content, metadata, timestamp, edited_at, redacted_at, redacted_by FROM messages;
+DROP TABLE messages;
+ALTER TABLE messages_rebuild RENAME TO messages;
+
+CREATE INDEX IF NOT EXISTS idx_messages_parent_message_id ON messages (parent_message_id);
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_timeline ON messages (conversation_id, timestamp, message_id);
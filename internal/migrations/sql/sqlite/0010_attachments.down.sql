@@ -0,0 +1,2 @@
+ALTER TABLE messages DROP COLUMN parts;
+DROP TABLE IF EXISTS attachments;
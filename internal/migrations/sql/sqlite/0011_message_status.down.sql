@@ -0,0 +1,2 @@
+ALTER TABLE messages DROP COLUMN streamed_at;
+ALTER TABLE messages DROP COLUMN status;
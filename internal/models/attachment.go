@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Attachment is a file uploaded to a conversation. Its content is kept in the
+// blob store under StorageKey; the attachment is deleted with its
+// conversation.
+type Attachment struct {
+	AttachmentID   uuid.UUID `json:"attachment_id" gorm:"primaryKey;type:uuid;column:attachment_id"`
+	ConversationID uuid.UUID `json:"conversation_id" gorm:"not null;index;type:uuid;column:conversation_id"`
+	// UserID is the uploader, whose quota the attachment counts against
+	UserID      uint      `json:"user_id" gorm:"not null;index;column:user_id"`
+	Filename    string    `json:"filename" gorm:"not null;type:varchar(255);column:filename"`
+	ContentType string    `json:"content_type" gorm:"not null;type:varchar(100);column:content_type"`
+	SizeBytes   int64     `json:"size_bytes" gorm:"not null;column:size_bytes"`
+	StorageKey  string    `json:"-" gorm:"not null;type:varchar(255);column:storage_key"`
+	CreatedAt   time.Time `json:"created_at" gorm:"not null;column:created_at"`
+}
+
+func (Attachment) TableName() string {
+	return "attachments"
+}
@@ -15,17 +15,43 @@ type Conversation struct {
 	CreatedAt      time.Time `json:"created_at" gorm:"not null;column:created_at"`
 	UpdatedAt      time.Time `json:"updated_at" gorm:"not null;column:updated_at"`
 	IsPinned       bool      `json:"is_pinned" gorm:"not null;default:false;column:is_pinned"`
+	// ActiveLeafID is the last message of the branch shown by default, or nil
+	// for a conversation without messages
+	ActiveLeafID *uuid.UUID `json:"active_leaf_id,omitempty" gorm:"type:uuid;column:active_leaf_id"`
 }
 
-// Message represents a single message in a conversation
+// Message represents a single message in a conversation. Messages form a
+// tree: each one replies to its parent, and messages with the same parent are
+// alternative branches.
 type Message struct {
 	MessageID       uuid.UUID  `json:"message_id" gorm:"primaryKey;type:uuid;column:message_id"`
 	ConversationID  uuid.UUID  `json:"conversation_id" gorm:"not null;index;type:uuid;column:conversation_id"`
 	ParentMessageID *uuid.UUID `json:"parent_message_id,omitempty" gorm:"index;type:uuid;column:parent_message_id"`
-	Sender          string     `json:"sender" gorm:"not null;type:sender_role;column:sender"` // Supabase enum: 'user', 'ai', 'system'
+	Sender          string     `json:"sender" gorm:"not null;type:varchar(20);column:sender"` // one of constants.ValidSenderRoles
 	Content         string     `json:"content" gorm:"type:text;not null;column:content"`
 	Metadata        *string    `json:"metadata,omitempty" gorm:"type:jsonb;column:metadata"`
 	Timestamp       time.Time  `json:"timestamp" gorm:"not null;column:timestamp"`
+	// EditedAt is set when the content was changed in place. Other edits add
+	// a sibling message instead.
+	EditedAt *time.Time `json:"edited_at,omitempty" gorm:"column:edited_at"`
+	// RedactedAt and RedactedBy record the redaction of a message, whose
+	// content has then been replaced with a tombstone
+	RedactedAt *time.Time `json:"redacted_at,omitempty" gorm:"column:redacted_at"`
+	RedactedBy *uint      `json:"redacted_by,omitempty" gorm:"column:redacted_by"`
+	// ToolCalls is the JSON array of tool calls requested by an AI message.
+	// A tool message answers one of them, named by ToolCallID and ToolName.
+	ToolCalls  *string `json:"tool_calls,omitempty" gorm:"type:jsonb;column:tool_calls"`
+	ToolCallID *string `json:"tool_call_id,omitempty" gorm:"type:varchar(100);column:tool_call_id"`
+	ToolName   *string `json:"tool_name,omitempty" gorm:"type:varchar(100);column:tool_name"`
+	// Parts is the JSON array of typed parts a message is composed of, if it
+	// was given as parts. Content then holds their plain-text rendering.
+	Parts *string `json:"parts,omitempty" gorm:"type:jsonb;column:parts"`
+	// Status is one of constants.ValidMessageStatuses. Only streamed messages
+	// are ever anything but complete.
+	Status string `json:"status" gorm:"not null;type:varchar(20);default:complete;column:status"`
+	// StreamedAt is when the content of a pending or streaming message was
+	// last saved; streams idle for too long are marked failed
+	StreamedAt *time.Time `json:"streamed_at,omitempty" gorm:"column:streamed_at"`
 }
 
 // TableName specifies the table names
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// LoginAttempt stores throttling state for a key such as an IP address or
+// account, shared by every instance of the service
+type LoginAttempt struct {
+	Key         string     `json:"key" gorm:"primaryKey;type:varchar(255);column:attempt_key"`
+	Count       int        `json:"count" gorm:"not null;default:0;column:count"`
+	WindowEnd   time.Time  `json:"window_end" gorm:"not null;index;column:window_end"`
+	LockedUntil *time.Time `json:"locked_until,omitempty" gorm:"column:locked_until"`
+}
+
+// TableName specifies the table name for LoginAttempt
+func (LoginAttempt) TableName() string {
+	return "login_attempts"
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// MFARecoveryCode is a single-use code that can stand in for a TOTP code.
+// Only the SHA-256 hash of the code is stored.
+type MFARecoveryCode struct {
+	CodeID    uint       `json:"code_id" gorm:"primaryKey;column:code_id"`
+	UserID    uint       `json:"user_id" gorm:"not null;index;column:user_id"`
+	CodeHash  string     `json:"-" gorm:"not null;type:varchar(64);column:code_hash"`
+	UsedAt    *time.Time `json:"used_at,omitempty" gorm:"column:used_at"`
+	CreatedAt time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// TableName specifies the table name for MFARecoveryCode
+func (MFARecoveryCode) TableName() string {
+	return "mfa_recovery_codes"
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// PasswordResetToken represents a single-use password reset token.
+// Only the SHA-256 hash of the token is stored.
+type PasswordResetToken struct {
+	TokenID   uint       `json:"token_id" gorm:"primaryKey;column:token_id"`
+	UserID    uint       `json:"user_id" gorm:"not null;index;column:user_id"`
+	TokenHash string     `json:"-" gorm:"not null;uniqueIndex;type:varchar(64);column:token_hash"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null;column:expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty" gorm:"column:used_at"`
+	CreatedAt time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// TableName specifies the table name for PasswordResetToken
+func (PasswordResetToken) TableName() string {
+	return "password_reset_tokens"
+}
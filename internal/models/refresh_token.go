@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// RefreshToken represents an opaque, server-side refresh token.
+// Only the SHA-256 hash of the token is stored. Tokens issued from the same
+// login share a FamilyID so that the whole chain can be revoked on reuse.
+type RefreshToken struct {
+	TokenID    uint       `json:"token_id" gorm:"primaryKey;column:token_id"`
+	UserID     uint       `json:"user_id" gorm:"not null;index;column:user_id"`
+	FamilyID   string     `json:"family_id" gorm:"not null;index;type:varchar(36);column:family_id"`
+	TokenHash  string     `json:"-" gorm:"not null;uniqueIndex;type:varchar(64);column:token_hash"`
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null;column:expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" gorm:"column:revoked_at"`
+	ReplacedBy *uint      `json:"replaced_by,omitempty" gorm:"column:replaced_by"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// TableName specifies the table name for RefreshToken
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// RevokedToken is a denylist entry for an access token ID (jti).
+// Entries only need to live until the token itself would have expired.
+type RevokedToken struct {
+	JTI       string    `json:"jti" gorm:"primaryKey;type:varchar(36);column:jti"`
+	UserID    uint      `json:"user_id" gorm:"not null;index;column:user_id"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"not null;index;column:expires_at"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// TableName specifies the table name for RevokedToken
+func (RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
@@ -4,14 +4,29 @@ import "time"
 
 // User represents a user in the system
 type User struct {
-	UserID    uint      `json:"user_id" gorm:"primaryKey;column:user_id"`
-	Email     string    `json:"email" gorm:"uniqueIndex;not null"`
-	Username  string    `json:"username" gorm:"uniqueIndex;not null"`
-	Password  string    `json:"-" gorm:"not null"`
-	FirstName string    `json:"first_name"`
-	LastName  string    `json:"last_name"`
-	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+	UserID    uint   `json:"user_id" gorm:"primaryKey;column:user_id"`
+	Email     string `json:"email" gorm:"uniqueIndex;not null"`
+	Username  string `json:"username" gorm:"uniqueIndex;not null"`
+	Password  string `json:"-" gorm:"not null"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Role      string `json:"role" gorm:"type:varchar(20);not null;default:'user'"` // constants.UserRole*
+	// EmailVerifiedAt is nil until the address has been confirmed
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	// PendingEmail holds a requested address change until it is verified
+	PendingEmail *string `json:"pending_email,omitempty"`
+	// PasswordChangedAt records the last password change or reset
+	PasswordChangedAt *time.Time `json:"password_changed_at,omitempty"`
+	// MFASecret is the base32 TOTP secret; MFAEnabledAt is nil while enrollment
+	// is unconfirmed. MFALastStep is the last accepted TOTP time step.
+	MFASecret    *string    `json:"-"`
+	MFAEnabledAt *time.Time `json:"mfa_enabled_at,omitempty"`
+	MFALastStep  int64      `json:"-" gorm:"not null;default:0"`
+	// TokenVersion is embedded in every access token; bumping it invalidates
+	// all tokens issued before the change.
+	TokenVersion uint      `json:"-" gorm:"not null;default:0"`
+	CreatedAt    time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt    time.Time `json:"updated_at" gorm:"autoUpdateTime"`
 }
 
 // TableName specifies the table name for User
@@ -0,0 +1,43 @@
+package repository_test
+
+import (
+	"testing"
+	"user_service/config"
+	"user_service/internal/database"
+	"user_service/internal/repository"
+	"user_service/internal/repository/repotest"
+
+	"gorm.io/gorm"
+)
+
+// TestMemoryRepositories runs the contract suite against the in-memory
+// implementations
+func TestMemoryRepositories(t *testing.T) {
+	repotest.RunUserRepositoryTests(t, func(t *testing.T) repository.UserRepository {
+		return repository.NewMemoryUserRepository()
+	})
+	repotest.RunConversationRepositoryTests(t, func(t *testing.T) (repository.UserRepository, repository.ConversationRepository) {
+		return repository.NewMemoryUserRepository(), repository.NewMemoryConversationRepository()
+	})
+}
+
+// TestGormRepositories runs the contract suite against the GORM
+// implementations on a fresh in-memory SQLite database per test
+func TestGormRepositories(t *testing.T) {
+	repotest.RunUserRepositoryTests(t, func(t *testing.T) repository.UserRepository {
+		return repository.NewUserRepository(openSQLite(t))
+	})
+	repotest.RunConversationRepositoryTests(t, func(t *testing.T) (repository.UserRepository, repository.ConversationRepository) {
+		db := openSQLite(t)
+		return repository.NewUserRepository(db), repository.NewConversationRepository(db)
+	})
+}
+
+func openSQLite(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := database.InitDB(&config.Config{DatabaseURL: ":memory:"})
+	if err != nil {
+		t.Fatalf("failed to open SQLite database: %v", err)
+	}
+	return db
+}
@@ -1,65 +1,830 @@
 package repository
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"user_service/internal/apperrors"
+	"user_service/internal/constants"
 	"user_service/internal/models"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-type ConversationRepository struct {
+// ErrConversationNotFound is returned when a conversation does not exist or,
+// for user-scoped queries, is not owned by the user
+var ErrConversationNotFound = apperrors.NotFound("conversation_not_found", "conversation not found")
+
+// ErrMessageNotFound is returned when a message does not exist in the
+// conversation it was looked up in
+var ErrMessageNotFound = apperrors.NotFound("message_not_found", "message not found")
+
+// ErrAttachmentNotFound is returned when an attachment does not exist
+var ErrAttachmentNotFound = apperrors.NotFound("attachment_not_found", "attachment not found")
+
+// ConversationRepository stores conversations and their messages.
+// Implementations must be safe for concurrent use.
+type ConversationRepository interface {
+	CreateConversation(ctx context.Context, conversation *models.Conversation) error
+	// CreateMessage stores a message in a conversation owned by userID, or
+	// returns ErrConversationNotFound. A message without a status is stored
+	// as complete.
+	CreateMessage(ctx context.Context, message *models.Message, userID uint) error
+	// GetMessage returns a message of a conversation, or ErrMessageNotFound
+	GetMessage(ctx context.Context, conversationID, messageID uuid.UUID) (*models.Message, error)
+	// GetMessageWithSiblings returns a message of a conversation together with
+	// its siblings, or ErrMessageNotFound
+	GetMessageWithSiblings(ctx context.Context, conversationID, messageID uuid.UUID) (*BranchMessage, error)
+	// GetConversationHistory returns a page of the branch of a conversation
+	// owned by userID that ends at leafID, oldest first, or
+	// ErrConversationNotFound. A nil leafID selects the active branch of the
+	// conversation.
+	GetConversationHistory(ctx context.Context, conversationID uuid.UUID, userID uint, leafID *uuid.UUID, page MessagePage) (*BranchPage, error)
+	// GetLatestMessage returns the most recent message of a conversation, or
+	// ErrMessageNotFound if it has none
+	GetLatestMessage(ctx context.Context, conversationID uuid.UUID) (*models.Message, error)
+	// GetLatestLeaf returns the most recent message in the subtree rooted at
+	// messageID in a conversation owned by userID, which is the leaf of its
+	// most recently extended branch. It returns ErrConversationNotFound for a
+	// missing or foreign conversation, then ErrMessageNotFound.
+	GetLatestLeaf(ctx context.Context, conversationID uuid.UUID, userID uint, messageID uuid.UUID) (uuid.UUID, error)
+	// ListConversationsByUserID returns a page of a user's conversations,
+	// pinned first, then most recently updated first. It reports whether more
+	// conversations follow the page in the direction it was read.
+	ListConversationsByUserID(ctx context.Context, userID uint, page ConversationPage) ([]models.Conversation, bool, error)
+	GetConversationByID(ctx context.Context, conversationID uuid.UUID) (*models.Conversation, error)
+	// GetConversationForUpdate returns a conversation owned by userID, or
+	// ErrConversationNotFound. Within a transaction the conversation stays
+	// locked until the transaction ends, which serializes changes to its
+	// active branch.
+	GetConversationForUpdate(ctx context.Context, conversationID uuid.UUID, userID uint) (*models.Conversation, error)
+	UpdateConversationTimestamp(ctx context.Context, conversationID uuid.UUID) error
+	// SetActiveLeaf sets the last message of the active branch of a
+	// conversation, or clears it with a nil leafID
+	SetActiveLeaf(ctx context.Context, conversationID uuid.UUID, leafID *uuid.UUID) error
+	// UpdateMessageContent replaces the content of a message of a conversation
+	// with plain text, dropping its parts, and records when it was edited, or
+	// returns ErrMessageNotFound
+	UpdateMessageContent(ctx context.Context, conversationID, messageID uuid.UUID, content string, editedAt time.Time) error
+	// AppendMessageContent appends delta to the content of a message of a
+	// conversation that is pending or streaming and not redacted, and sets its
+	// status, its metadata unless metadata is nil, and streamed_at. It returns
+	// ErrMessageNotFound if there is no such message.
+	AppendMessageContent(ctx context.Context, conversationID, messageID uuid.UUID, delta, status string, metadata *string, streamedAt time.Time) error
+	// ExpireStreamingMessages marks the pending and streaming messages of a
+	// conversation last saved before staleBefore as failed, and returns their
+	// number
+	ExpireStreamingMessages(ctx context.Context, conversationID uuid.UUID, staleBefore time.Time) (int64, error)
+	// RedactMessage replaces the content of a message of a conversation with
+	// tombstone, drops its metadata, parts and tool call fields and records
+	// who redacted it and when, or returns ErrMessageNotFound
+	RedactMessage(ctx context.Context, conversationID, messageID uuid.UUID, tombstone string, redactedBy uint, redactedAt time.Time) error
+	// DeleteMessageSubtree deletes a message of a conversation together with
+	// every reply below it and returns how many messages were deleted, or
+	// returns ErrMessageNotFound
+	DeleteMessageSubtree(ctx context.Context, conversationID, messageID uuid.UUID) (int64, error)
+	// DeleteConversation deletes a conversation owned by userID together with
+	// its messages, or returns ErrConversationNotFound
+	DeleteConversation(ctx context.Context, conversationID uuid.UUID, userID uint) error
+	// UpdateConversationPin pins or unpins a conversation owned by userID, or
+	// returns ErrConversationNotFound
+	UpdateConversationPin(ctx context.Context, conversationID uuid.UUID, userID uint, isPinned bool) error
+	// SearchConversations returns up to query.Limit of the user's
+	// conversations whose title or messages match the query, best match first
+	SearchConversations(ctx context.Context, userID uint, query SearchQuery) ([]SearchResult, error)
+	// CreateAttachment stores an attachment of a conversation owned by userID,
+	// or returns ErrConversationNotFound. Attachments are deleted with their
+	// conversation.
+	CreateAttachment(ctx context.Context, attachment *models.Attachment, userID uint) error
+	// GetAttachment returns an attachment, or ErrAttachmentNotFound
+	GetAttachment(ctx context.Context, attachmentID uuid.UUID) (*models.Attachment, error)
+	// ListAttachments returns the attachments of a conversation, oldest first
+	ListAttachments(ctx context.Context, conversationID uuid.UUID) ([]models.Attachment, error)
+	// ListUserAttachments returns the attachments uploaded by a user, which
+	// are deleted with the user
+	ListUserAttachments(ctx context.Context, userID uint) ([]models.Attachment, error)
+	// GetAttachmentUsage returns the total size of the attachments uploaded
+	// by a user
+	GetAttachmentUsage(ctx context.Context, userID uint) (int64, error)
+	// GetAttachmentUsageForUpdate returns the total size of the attachments
+	// uploaded by a user. Within a transaction the user stays locked until
+	// the transaction ends, which serializes uploads against their quota.
+	GetAttachmentUsageForUpdate(ctx context.Context, userID uint) (int64, error)
+}
+
+// GormConversationRepository implements ConversationRepository on top of GORM
+type GormConversationRepository struct {
 	db *gorm.DB
 }
 
-func NewConversationRepository(db *gorm.DB) *ConversationRepository {
-	return &ConversationRepository{db: db}
+func NewConversationRepository(db *gorm.DB) *GormConversationRepository {
+	return &GormConversationRepository{db: db}
 }
 
 // CreateConversation creates a new conversation
-func (r *ConversationRepository) CreateConversation(conversation *models.Conversation) error {
-	return r.db.Create(conversation).Error
+func (r *GormConversationRepository) CreateConversation(ctx context.Context, conversation *models.Conversation) error {
+	return Conn(ctx, r.db).Create(conversation).Error
 }
 
-// CreateMessage creates a new message in a conversation
-func (r *ConversationRepository) CreateMessage(message *models.Message) error {
-	return r.db.Create(message).Error
+// CreateMessage creates a new message in a conversation owned by the user,
+// checking ownership in the same statement. It returns ErrConversationNotFound
+// if the conversation does not exist or is not owned by the user.
+func (r *GormConversationRepository) CreateMessage(ctx context.Context, message *models.Message, userID uint) error {
+	if message.Status == "" {
+		message.Status = constants.MessageStatusComplete
+	}
+	result := Conn(ctx, r.db).Exec(`
+		INSERT INTO messages (message_id, conversation_id, parent_message_id, sender, content, metadata, timestamp, tool_calls, tool_call_id, tool_name, parts, status, streamed_at)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		WHERE EXISTS (SELECT 1 FROM conversations WHERE conversation_id = ? AND user_id = ?)`,
+		message.MessageID, message.ConversationID, message.ParentMessageID, message.Sender, message.Content, message.Metadata, message.Timestamp,
+		message.ToolCalls, message.ToolCallID, message.ToolName, message.Parts, message.Status, message.StreamedAt,
+		message.ConversationID, userID,
+	)
+	return ownedRowResult(result)
 }
 
-// GetConversationHistory retrieves all messages for a conversation
-func (r *ConversationRepository) GetConversationHistory(conversationID uuid.UUID) ([]models.Message, error) {
-	var messages []models.Message
-	err := r.db.Where("conversation_id = ?", conversationID).Order("timestamp ASC").Find(&messages).Error
-	return messages, err
+// GetMessage retrieves a message of a conversation
+func (r *GormConversationRepository) GetMessage(ctx context.Context, conversationID, messageID uuid.UUID) (*models.Message, error) {
+	var message models.Message
+	err := Conn(ctx, r.db).Where("conversation_id = ? AND message_id = ?", conversationID, messageID).First(&message).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	return &message, nil
+}
+
+// GetMessageWithSiblings retrieves a message of a conversation and its siblings
+func (r *GormConversationRepository) GetMessageWithSiblings(ctx context.Context, conversationID, messageID uuid.UUID) (*BranchMessage, error) {
+	message, err := r.GetMessage(ctx, conversationID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	branch, err := r.loadSiblings(ctx, conversationID, []models.Message{*message})
+	if err != nil {
+		return nil, err
+	}
+	return &branch[0], nil
+}
+
+// GetConversationHistory retrieves a page of the branch ending at leafID, or
+// at the active leaf, for a conversation owned by the user in a single query,
+// then the siblings of the messages on the page. It returns
+// ErrConversationNotFound if the conversation does not exist or is not owned
+// by the user.
+func (r *GormConversationRepository) GetConversationHistory(ctx context.Context, conversationID uuid.UUID, userID uint, leafID *uuid.UUID, page MessagePage) (*BranchPage, error) {
+	// Pages before a key, and the latest page, are read newest first
+	var cursor *MessageKey
+	comparison, order := "", "DESC"
+	switch {
+	case page.After != nil:
+		cursor, comparison, order = page.After, ">", "ASC"
+	case page.Before != nil:
+		cursor, comparison = page.Before, "<"
+	}
+	// pastCursor is the condition for a message of table to lie on the page
+	// side of the cursor, and inPage adds the metadata filters to it
+	pastCursor := func(table string, args []interface{}) (string, []interface{}) {
+		if cursor == nil {
+			return "TRUE", args
+		}
+		condition := fmt.Sprintf("(%[1]s.timestamp, %[1]s.message_id) %[2]s (?, ?)", table, comparison)
+		return condition, append(args, cursor.Timestamp, cursor.MessageID)
+	}
+	inPage := func(table string, args []interface{}) (string, []interface{}) {
+		condition, args := pastCursor(table, args)
+		condition += metadataCondition(r.db.Dialector.Name(), table+".metadata", page.Metadata, func(value interface{}) string {
+			args = append(args, value)
+			return "?"
+		})
+		return condition, args
+	}
+
+	// The branch is walked up from the leaf through the parents, counting
+	// the messages that belong on the page as page_rows. Replies are always
+	// newer than their parent, so the walk gets older at every step: a page
+	// after the cursor stops at the cursor, and other pages stop as soon as
+	// they have one message more than fits.
+	anchorInPage, args := inPage("messages", nil)
+	args = append(args, leafID, conversationID, conversationID)
+	stepInPage, args := inPage("messages", args)
+	walk := "branch.page_rows <= ?"
+	if page.After != nil {
+		walk, args = pastCursor("branch", args)
+	} else {
+		args = append(args, page.Limit)
+	}
+	branchInPage, args := inPage("branch", args)
+	args = append(args, conversationID, userID, page.Limit+1)
+
+	// The LEFT JOIN yields one row with NULL message columns for an owned
+	// conversation without messages in the page, and no rows at all for a
+	// missing or foreign one. Every row carries the active leaf of the
+	// conversation.
+	var rows []struct {
+		models.Message
+		ActiveLeafID *uuid.UUID
+	}
+	err := Conn(ctx, r.db).Raw(`
+		WITH RECURSIVE branch AS (
+			SELECT messages.*, CASE WHEN `+anchorInPage+` THEN 1 ELSE 0 END AS page_rows FROM messages
+			WHERE messages.message_id = COALESCE(?, (
+				SELECT active_leaf_id FROM conversations WHERE conversation_id = ?
+			)) AND messages.conversation_id = ?
+			UNION ALL
+			SELECT messages.*, branch.page_rows + CASE WHEN `+stepInPage+` THEN 1 ELSE 0 END FROM messages
+			JOIN branch ON messages.message_id = branch.parent_message_id
+			WHERE `+walk+`
+		)
+		SELECT branch.*, conversations.active_leaf_id FROM conversations
+		LEFT JOIN branch ON `+branchInPage+`
+		WHERE conversations.conversation_id = ? AND conversations.user_id = ?
+		ORDER BY branch.timestamp `+order+`, branch.message_id `+order+`
+		LIMIT ?`, args...).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrConversationNotFound
+	}
+	if leafID == nil {
+		leafID = rows[0].ActiveLeafID
+	}
+
+	messages := make([]models.Message, 0, len(rows))
+	for _, row := range rows {
+		if row.MessageID != uuid.Nil {
+			messages = append(messages, row.Message)
+		}
+	}
+	messages, more := trimPage(messages, page.Limit, order == "DESC")
+
+	branch, err := r.loadSiblings(ctx, conversationID, messages)
+	if err != nil {
+		return nil, err
+	}
+	return &BranchPage{Messages: branch, LeafID: leafID, More: more}, nil
 }
 
-// GetAllConversationsByUserID retrieves all conversations for a user
-func (r *ConversationRepository) GetAllConversationsByUserID(userID uint) ([]models.Conversation, error) {
+// loadSiblings pairs messages of a conversation with their siblings
+func (r *GormConversationRepository) loadSiblings(ctx context.Context, conversationID uuid.UUID, messages []models.Message) ([]BranchMessage, error) {
+	if len(messages) == 0 {
+		return []BranchMessage{}, nil
+	}
+
+	var parentIDs []uuid.UUID
+	hasRoot := false
+	for _, message := range messages {
+		if message.ParentMessageID == nil {
+			hasRoot = true
+		} else {
+			parentIDs = append(parentIDs, *message.ParentMessageID)
+		}
+	}
+	sameParent := r.db.Where("parent_message_id IN ?", parentIDs)
+	if hasRoot {
+		sameParent = sameParent.Or("parent_message_id IS NULL")
+	}
+
+	var candidates []models.Message
+	err := Conn(ctx, r.db).Select("message_id", "parent_message_id").
+		Where("conversation_id = ?", conversationID).
+		Where(sameParent).
+		Order("timestamp ASC, message_id ASC").
+		Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+	return withSiblings(messages, candidates), nil
+}
+
+// GetLatestMessage retrieves the most recent message of a conversation
+func (r *GormConversationRepository) GetLatestMessage(ctx context.Context, conversationID uuid.UUID) (*models.Message, error) {
+	var message models.Message
+	err := Conn(ctx, r.db).Where("conversation_id = ?", conversationID).
+		Order("timestamp DESC, message_id DESC").
+		Take(&message).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	return &message, nil
+}
+
+// GetLatestLeaf finds the most recent message in the subtree rooted at a
+// message of a conversation owned by the user, in a single query. Replies are
+// always newer than the message they reply to, so it is a leaf.
+func (r *GormConversationRepository) GetLatestLeaf(ctx context.Context, conversationID uuid.UUID, userID uint, messageID uuid.UUID) (uuid.UUID, error) {
+	// As in GetConversationHistory, the LEFT JOIN yields one row with a NULL
+	// message for an owned conversation without the message, and no rows at
+	// all for a missing or foreign one
+	var rows []struct {
+		MessageID *uuid.UUID
+	}
+	err := Conn(ctx, r.db).Raw(`
+		WITH RECURSIVE subtree AS (
+			SELECT message_id, timestamp FROM messages
+			WHERE message_id = ? AND conversation_id = ?
+			UNION ALL
+			SELECT messages.message_id, messages.timestamp FROM messages
+			JOIN subtree ON messages.parent_message_id = subtree.message_id
+		)
+		SELECT subtree.message_id FROM conversations
+		LEFT JOIN subtree ON TRUE
+		WHERE conversations.conversation_id = ? AND conversations.user_id = ?
+		ORDER BY subtree.timestamp DESC, subtree.message_id DESC
+		LIMIT 1`, messageID, conversationID, conversationID, userID).Scan(&rows).Error
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if len(rows) == 0 {
+		return uuid.Nil, ErrConversationNotFound
+	}
+	if rows[0].MessageID == nil {
+		return uuid.Nil, ErrMessageNotFound
+	}
+	return *rows[0].MessageID, nil
+}
+
+// ListConversationsByUserID retrieves a page of conversations for a user
+func (r *GormConversationRepository) ListConversationsByUserID(ctx context.Context, userID uint, page ConversationPage) ([]models.Conversation, bool, error) {
+	query := Conn(ctx, r.db).Where("user_id = ?", userID)
+	if page.Pinned != nil {
+		query = query.Where("is_pinned = ?", *page.Pinned)
+	}
+
+	// The list is in descending key order, so pages before a key are read
+	// in ascending order
+	order := "DESC"
+	switch {
+	case page.After != nil:
+		query = query.Where("(is_pinned, updated_at, conversation_id) < (?, ?, ?)",
+			page.After.IsPinned, page.After.UpdatedAt, page.After.ConversationID)
+	case page.Before != nil:
+		query = query.Where("(is_pinned, updated_at, conversation_id) > (?, ?, ?)",
+			page.Before.IsPinned, page.Before.UpdatedAt, page.Before.ConversationID)
+		order = "ASC"
+	}
+
 	var conversations []models.Conversation
-	err := r.db.Where("user_id = ?", userID).Order("updated_at DESC").Find(&conversations).Error
-	return conversations, err
+	err := query.Order("is_pinned " + order + ", updated_at " + order + ", conversation_id " + order).
+		Limit(page.Limit + 1).
+		Find(&conversations).Error
+	if err != nil {
+		return nil, false, err
+	}
+	conversations, more := trimPage(conversations, page.Limit, order == "ASC")
+	return conversations, more, nil
 }
 
 // GetConversationByID retrieves a conversation by ID
-func (r *ConversationRepository) GetConversationByID(conversationID uuid.UUID) (*models.Conversation, error) {
+func (r *GormConversationRepository) GetConversationByID(ctx context.Context, conversationID uuid.UUID) (*models.Conversation, error) {
+	var conversation models.Conversation
+	err := Conn(ctx, r.db).Where("conversation_id = ?", conversationID).First(&conversation).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrConversationNotFound
+		}
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// GetConversationForUpdate retrieves a conversation owned by the user and
+// locks its row for the rest of the transaction. SQLite has no row locks, but
+// only allows one writer at a time.
+func (r *GormConversationRepository) GetConversationForUpdate(ctx context.Context, conversationID uuid.UUID, userID uint) (*models.Conversation, error) {
 	var conversation models.Conversation
-	err := r.db.Where("conversation_id = ?", conversationID).First(&conversation).Error
+	err := Conn(ctx, r.db).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+		First(&conversation).Error
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrConversationNotFound
+		}
 		return nil, err
 	}
 	return &conversation, nil
 }
 
 // UpdateConversationTimestamp updates the updated_at field of a conversation
-func (r *ConversationRepository) UpdateConversationTimestamp(conversationID uuid.UUID) error {
-	return r.db.Model(&models.Conversation{}).Where("conversation_id = ?", conversationID).Update("updated_at", "NOW()").Error
+func (r *GormConversationRepository) UpdateConversationTimestamp(ctx context.Context, conversationID uuid.UUID) error {
+	return Conn(ctx, r.db).Model(&models.Conversation{}).Where("conversation_id = ?", conversationID).Update("updated_at", time.Now()).Error
 }
 
-// DeleteConversation deletes a conversation and all its messages
-func (r *ConversationRepository) DeleteConversation(conversationID uuid.UUID) error {
-	return r.db.Where("conversation_id = ?", conversationID).Delete(&models.Conversation{}).Error
+// SetActiveLeaf updates the active_leaf_id field of a conversation
+func (r *GormConversationRepository) SetActiveLeaf(ctx context.Context, conversationID uuid.UUID, leafID *uuid.UUID) error {
+	return Conn(ctx, r.db).Model(&models.Conversation{}).Where("conversation_id = ?", conversationID).Update("active_leaf_id", leafID).Error
+}
+
+// UpdateMessageContent updates the content and edited_at fields of a message
+func (r *GormConversationRepository) UpdateMessageContent(ctx context.Context, conversationID, messageID uuid.UUID, content string, editedAt time.Time) error {
+	result := Conn(ctx, r.db).Model(&models.Message{}).
+		Where("conversation_id = ? AND message_id = ?", conversationID, messageID).
+		Updates(map[string]interface{}{"content": content, "parts": nil, "edited_at": editedAt})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrMessageNotFound
+	}
+	return nil
+}
+
+// AppendMessageContent appends to the content of an open message in a single
+// statement, so that appends are never lost to concurrent writers
+func (r *GormConversationRepository) AppendMessageContent(ctx context.Context, conversationID, messageID uuid.UUID, delta, status string, metadata *string, streamedAt time.Time) error {
+	updates := map[string]interface{}{
+		"content":     gorm.Expr("content || ?", delta),
+		"status":      status,
+		"streamed_at": streamedAt,
+	}
+	if metadata != nil {
+		updates["metadata"] = *metadata
+	}
+	result := Conn(ctx, r.db).Model(&models.Message{}).
+		Where("conversation_id = ? AND message_id = ? AND status IN ? AND redacted_at IS NULL",
+			conversationID, messageID, []string{constants.MessageStatusPending, constants.MessageStatusStreaming}).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrMessageNotFound
+	}
+	return nil
 }
 
-// UpdateConversationPin updates the is_pinned status of a conversation
-func (r *ConversationRepository) UpdateConversationPin(conversationID uuid.UUID, isPinned bool) error {
-	return r.db.Model(&models.Conversation{}).Where("conversation_id = ?", conversationID).Update("is_pinned", isPinned).Error
+// ExpireStreamingMessages marks the stale open messages of a conversation as
+// failed
+func (r *GormConversationRepository) ExpireStreamingMessages(ctx context.Context, conversationID uuid.UUID, staleBefore time.Time) (int64, error) {
+	result := Conn(ctx, r.db).Model(&models.Message{}).
+		Where("conversation_id = ? AND status IN ? AND (streamed_at IS NULL OR streamed_at < ?)",
+			conversationID, []string{constants.MessageStatusPending, constants.MessageStatusStreaming}, staleBefore).
+		Update("status", constants.MessageStatusFailed)
+	return result.RowsAffected, result.Error
+}
+
+// RedactMessage replaces the content of a message with a tombstone and
+// records the redaction
+func (r *GormConversationRepository) RedactMessage(ctx context.Context, conversationID, messageID uuid.UUID, tombstone string, redactedBy uint, redactedAt time.Time) error {
+	result := Conn(ctx, r.db).Model(&models.Message{}).
+		Where("conversation_id = ? AND message_id = ?", conversationID, messageID).
+		Updates(map[string]interface{}{
+			"content":      tombstone,
+			"metadata":     nil,
+			"parts":        nil,
+			"tool_calls":   nil,
+			"tool_call_id": nil,
+			"tool_name":    nil,
+			"redacted_at":  redactedAt,
+			"redacted_by":  redactedBy,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrMessageNotFound
+	}
+	return nil
+}
+
+// DeleteMessageSubtree deletes a message and its replies in a single
+// statement, collecting the replies with a recursive query
+func (r *GormConversationRepository) DeleteMessageSubtree(ctx context.Context, conversationID, messageID uuid.UUID) (int64, error) {
+	result := Conn(ctx, r.db).Exec(`
+		WITH RECURSIVE subtree AS (
+			SELECT message_id FROM messages
+			WHERE message_id = ? AND conversation_id = ?
+			UNION ALL
+			SELECT messages.message_id FROM messages
+			JOIN subtree ON messages.parent_message_id = subtree.message_id
+		)
+		DELETE FROM messages WHERE message_id IN (SELECT message_id FROM subtree)`,
+		messageID, conversationID,
+	)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return 0, ErrMessageNotFound
+	}
+	return result.RowsAffected, nil
+}
+
+// DeleteConversation deletes a conversation owned by the user; its messages
+// are removed by the ON DELETE CASCADE foreign key
+func (r *GormConversationRepository) DeleteConversation(ctx context.Context, conversationID uuid.UUID, userID uint) error {
+	result := Conn(ctx, r.db).Where("conversation_id = ? AND user_id = ?", conversationID, userID).Delete(&models.Conversation{})
+	return ownedRowResult(result)
+}
+
+// UpdateConversationPin updates the is_pinned status of a conversation owned by the user
+func (r *GormConversationRepository) UpdateConversationPin(ctx context.Context, conversationID uuid.UUID, userID uint, isPinned bool) error {
+	result := Conn(ctx, r.db).Model(&models.Conversation{}).
+		Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+		Update("is_pinned", isPinned)
+	return ownedRowResult(result)
+}
+
+// CreateAttachment creates an attachment of a conversation owned by the user,
+// checking ownership in the same statement
+func (r *GormConversationRepository) CreateAttachment(ctx context.Context, attachment *models.Attachment, userID uint) error {
+	result := Conn(ctx, r.db).Exec(`
+		INSERT INTO attachments (attachment_id, conversation_id, user_id, filename, content_type, size_bytes, storage_key, created_at)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?
+		WHERE EXISTS (SELECT 1 FROM conversations WHERE conversation_id = ? AND user_id = ?)`,
+		attachment.AttachmentID, attachment.ConversationID, attachment.UserID, attachment.Filename, attachment.ContentType,
+		attachment.SizeBytes, attachment.StorageKey, attachment.CreatedAt,
+		attachment.ConversationID, userID,
+	)
+	return ownedRowResult(result)
+}
+
+// GetAttachment retrieves an attachment by ID
+func (r *GormConversationRepository) GetAttachment(ctx context.Context, attachmentID uuid.UUID) (*models.Attachment, error) {
+	var attachment models.Attachment
+	err := Conn(ctx, r.db).Where("attachment_id = ?", attachmentID).First(&attachment).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// ListAttachments retrieves the attachments of a conversation
+func (r *GormConversationRepository) ListAttachments(ctx context.Context, conversationID uuid.UUID) ([]models.Attachment, error) {
+	var attachments []models.Attachment
+	err := Conn(ctx, r.db).Where("conversation_id = ?", conversationID).
+		Order("created_at ASC, attachment_id ASC").
+		Find(&attachments).Error
+	return attachments, err
+}
+
+// ListUserAttachments retrieves the attachments uploaded by a user
+func (r *GormConversationRepository) ListUserAttachments(ctx context.Context, userID uint) ([]models.Attachment, error) {
+	var attachments []models.Attachment
+	err := Conn(ctx, r.db).Where("user_id = ?", userID).
+		Order("created_at ASC, attachment_id ASC").
+		Find(&attachments).Error
+	return attachments, err
+}
+
+// GetAttachmentUsage sums the sizes of a user's attachments
+func (r *GormConversationRepository) GetAttachmentUsage(ctx context.Context, userID uint) (int64, error) {
+	var usage int64
+	err := Conn(ctx, r.db).Model(&models.Attachment{}).
+		Where("user_id = ?", userID).
+		Select("COALESCE(SUM(size_bytes), 0)").
+		Scan(&usage).Error
+	return usage, err
+}
+
+// GetAttachmentUsageForUpdate locks the user's row, then sums the sizes of
+// their attachments
+func (r *GormConversationRepository) GetAttachmentUsageForUpdate(ctx context.Context, userID uint) (int64, error) {
+	var locked []uint
+	err := Conn(ctx, r.db).Model(&models.User{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ?", userID).
+		Pluck("user_id", &locked).Error
+	if err != nil {
+		return 0, err
+	}
+	return r.GetAttachmentUsage(ctx, userID)
+}
+
+// SearchConversations searches the user's conversations. On Postgres it uses
+// the full-text indexes on titles and message content; other databases fall
+// back to matching every word of the query with LIKE.
+func (r *GormConversationRepository) SearchConversations(ctx context.Context, userID uint, query SearchQuery) ([]SearchResult, error) {
+	if r.db.Dialector.Name() == "postgres" {
+		return r.searchFullText(ctx, userID, query)
+	}
+	return r.searchLike(ctx, userID, query)
+}
+
+// tsQuery parses the search text of a full-text query. The to_tsvector
+// expressions matched against it must be the ones indexed by migration
+// 0004_full_text_search.
+const tsQuery = "websearch_to_tsquery('english', @text)"
+
+// headlineOptions configures ts_headline to produce snippets like rankSearch,
+// once passed through markHighlights
+var headlineOptions = fmt.Sprintf(`StartSel="%s", StopSel="%s", MinWords=15, MaxWords=35, MaxFragments=1`, highlightStartSentinel, highlightStopSentinel)
+
+// searchFullText searches with Postgres full-text search: one query ranks
+// the conversations and a second fetches the best matches of each. Redacted
+// messages never match.
+func (r *GormConversationRepository) searchFullText(ctx context.Context, userID uint, query SearchQuery) ([]SearchResult, error) {
+	args := map[string]interface{}{
+		"text":     query.Text,
+		"user_id":  userID,
+		"headline": headlineOptions,
+		"limit":    query.Limit,
+	}
+	messageFilter := " AND messages.redacted_at IS NULL"
+	if query.Sender != "" {
+		messageFilter += " AND messages.sender = @sender"
+		args["sender"] = query.Sender
+	}
+	if query.From != nil {
+		messageFilter += " AND messages.timestamp >= @from"
+		args["from"] = *query.From
+	}
+	if query.To != nil {
+		messageFilter += " AND messages.timestamp < @to"
+		args["to"] = *query.To
+	}
+	messageFilter += metadataCondition("postgres", "messages.metadata", query.Metadata, func(value interface{}) string {
+		name := fmt.Sprintf("metadata_%d", len(args))
+		args[name] = value
+		return "@" + name
+	})
+	conversationFilter := ""
+	if query.Pinned != nil {
+		conversationFilter = " AND conversations.is_pinned = @pinned"
+		args["pinned"] = *query.Pinned
+	}
+	titleMatch := "to_tsvector('english', conversations.title) @@ " + tsQuery
+	if query.filtersMessages() {
+		titleMatch = "FALSE"
+	}
+
+	var rows []struct {
+		models.Conversation
+		MatchCount   int
+		TitleSnippet string
+		Rank         float64
+	}
+	err := Conn(ctx, r.db).Raw(`
+		WITH matched AS (
+			SELECT messages.conversation_id, COUNT(*) AS match_count,
+				MAX(ts_rank(to_tsvector('english', messages.content), `+tsQuery+`)) AS rank
+			FROM messages
+			JOIN conversations ON conversations.conversation_id = messages.conversation_id
+			WHERE conversations.user_id = @user_id`+conversationFilter+`
+				AND to_tsvector('english', messages.content) @@ `+tsQuery+messageFilter+`
+			GROUP BY messages.conversation_id
+		)
+		SELECT conversations.*,
+			COALESCE(matched.match_count, 0) AS match_count,
+			CASE WHEN `+titleMatch+` THEN ts_headline('english', conversations.title, `+tsQuery+`, @headline) ELSE '' END AS title_snippet,
+			COALESCE(matched.rank, 0) +
+				CASE WHEN `+titleMatch+` THEN ts_rank(to_tsvector('english', conversations.title), `+tsQuery+`) ELSE 0 END AS rank
+		FROM conversations
+		LEFT JOIN matched ON matched.conversation_id = conversations.conversation_id
+		WHERE conversations.user_id = @user_id`+conversationFilter+`
+			AND (matched.conversation_id IS NOT NULL OR `+titleMatch+`)
+		ORDER BY rank DESC, conversations.is_pinned DESC, conversations.updated_at DESC, conversations.conversation_id DESC
+		LIMIT @limit
+	`, args).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(rows))
+	byID := make(map[uuid.UUID]*SearchResult, len(rows))
+	var matchedIDs []uuid.UUID
+	for _, row := range rows {
+		results = append(results, SearchResult{
+			Conversation: row.Conversation,
+			Rank:         row.Rank,
+			TitleSnippet: markHighlights(row.TitleSnippet),
+			MatchCount:   row.MatchCount,
+		})
+		if row.MatchCount > 0 {
+			matchedIDs = append(matchedIDs, row.ConversationID)
+		}
+	}
+	for i := range results {
+		byID[results[i].Conversation.ConversationID] = &results[i]
+	}
+	if len(matchedIDs) == 0 {
+		return results, nil
+	}
+
+	// Snippets are only generated for the messages returned
+	args["conversation_ids"] = matchedIDs
+	args["per_conversation"] = SearchMatchesPerConversation
+	var matches []struct {
+		SearchMatch
+		ConversationID uuid.UUID
+	}
+	err = Conn(ctx, r.db).Raw(`
+		SELECT message_id, conversation_id, sender, timestamp,
+			ts_headline('english', content, `+tsQuery+`, @headline) AS snippet
+		FROM (
+			SELECT messages.*, ROW_NUMBER() OVER (
+				PARTITION BY messages.conversation_id
+				ORDER BY ts_rank(to_tsvector('english', messages.content), `+tsQuery+`) DESC, messages.timestamp DESC
+			) AS position
+			FROM messages
+			WHERE messages.conversation_id IN @conversation_ids
+				AND to_tsvector('english', messages.content) @@ `+tsQuery+messageFilter+`
+		) ranked
+		WHERE position <= @per_conversation
+		ORDER BY conversation_id, position
+	`, args).Scan(&matches).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, match := range matches {
+		if result, ok := byID[match.ConversationID]; ok {
+			match.Snippet = markHighlights(match.Snippet)
+			result.Matches = append(result.Matches, match.SearchMatch)
+		}
+	}
+	return results, nil
+}
+
+// searchLike loads the messages and conversations containing every word of
+// the query and ranks them with rankSearch
+func (r *GormConversationRepository) searchLike(ctx context.Context, userID uint, query SearchQuery) ([]SearchResult, error) {
+	terms := searchTerms(query.Text)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	messagesQuery := Conn(ctx, r.db).Table("messages").
+		Select("messages.*").
+		Joins("JOIN conversations ON conversations.conversation_id = messages.conversation_id").
+		Where("conversations.user_id = ? AND messages.redacted_at IS NULL", userID)
+	if query.Pinned != nil {
+		messagesQuery = messagesQuery.Where("conversations.is_pinned = ?", *query.Pinned)
+	}
+	if query.Sender != "" {
+		messagesQuery = messagesQuery.Where("messages.sender = ?", query.Sender)
+	}
+	if query.From != nil {
+		messagesQuery = messagesQuery.Where("messages.timestamp >= ?", *query.From)
+	}
+	if query.To != nil {
+		messagesQuery = messagesQuery.Where("messages.timestamp < ?", *query.To)
+	}
+	if len(query.Metadata) > 0 {
+		var metadataArgs []interface{}
+		condition := metadataCondition(r.db.Dialector.Name(), "messages.metadata", query.Metadata, func(value interface{}) string {
+			metadataArgs = append(metadataArgs, value)
+			return "?"
+		})
+		messagesQuery = messagesQuery.Where(strings.TrimPrefix(condition, " AND "), metadataArgs...)
+	}
+	for _, term := range terms {
+		messagesQuery = messagesQuery.Where(`LOWER(messages.content) LIKE ? ESCAPE '\'`, likePattern(term))
+	}
+	var messages []models.Message
+	if err := messagesQuery.Scan(&messages).Error; err != nil {
+		return nil, err
+	}
+
+	var conversationIDs []uuid.UUID
+	seen := make(map[uuid.UUID]bool)
+	for _, message := range messages {
+		if !seen[message.ConversationID] {
+			seen[message.ConversationID] = true
+			conversationIDs = append(conversationIDs, message.ConversationID)
+		}
+	}
+	matched := r.db.Where("conversation_id IN ?", conversationIDs)
+	if !query.filtersMessages() {
+		titleMatch := r.db
+		for _, term := range terms {
+			titleMatch = titleMatch.Where(`LOWER(title) LIKE ? ESCAPE '\'`, likePattern(term))
+		}
+		matched = matched.Or(titleMatch)
+	} else if len(conversationIDs) == 0 {
+		return nil, nil
+	}
+
+	conversationsQuery := Conn(ctx, r.db).Where("user_id = ?", userID).Where(matched)
+	if query.Pinned != nil {
+		conversationsQuery = conversationsQuery.Where("is_pinned = ?", *query.Pinned)
+	}
+	var conversations []models.Conversation
+	if err := conversationsQuery.Find(&conversations).Error; err != nil {
+		return nil, err
+	}
+	return rankSearch(query, conversations, messages), nil
+}
+
+// ownedRowResult maps a user-scoped write that matched no row to ErrConversationNotFound
+func ownedRowResult(result *gorm.DB) error {
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrConversationNotFound
+	}
+	return nil
 }
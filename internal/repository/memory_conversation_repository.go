@@ -0,0 +1,490 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+	"user_service/internal/constants"
+	"user_service/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// MemoryConversationRepository implements ConversationRepository in memory.
+// It is intended for tests and single-process development.
+type MemoryConversationRepository struct {
+	mu            sync.RWMutex
+	conversations map[uuid.UUID]models.Conversation
+	messages      map[uuid.UUID][]models.Message
+	attachments   map[uuid.UUID]models.Attachment
+}
+
+// NewMemoryConversationRepository creates an empty in-memory conversation repository
+func NewMemoryConversationRepository() *MemoryConversationRepository {
+	return &MemoryConversationRepository{
+		conversations: make(map[uuid.UUID]models.Conversation),
+		messages:      make(map[uuid.UUID][]models.Message),
+		attachments:   make(map[uuid.UUID]models.Attachment),
+	}
+}
+
+// CreateConversation creates a new conversation
+func (r *MemoryConversationRepository) CreateConversation(ctx context.Context, conversation *models.Conversation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.conversations[conversation.ConversationID] = *conversation
+	return nil
+}
+
+// CreateMessage creates a new message in a conversation owned by the user
+func (r *MemoryConversationRepository) CreateMessage(ctx context.Context, message *models.Message, userID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conversation, ok := r.conversations[message.ConversationID]
+	if !ok || conversation.UserID != userID {
+		return ErrConversationNotFound
+	}
+	if message.Status == "" {
+		message.Status = constants.MessageStatusComplete
+	}
+	r.messages[message.ConversationID] = append(r.messages[message.ConversationID], *message)
+	return nil
+}
+
+// GetMessage retrieves a message of a conversation
+func (r *MemoryConversationRepository) GetMessage(ctx context.Context, conversationID, messageID uuid.UUID) (*models.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, message := range r.messages[conversationID] {
+		if message.MessageID == messageID {
+			return &message, nil
+		}
+	}
+	return nil, ErrMessageNotFound
+}
+
+// GetMessageWithSiblings retrieves a message of a conversation and its siblings
+func (r *MemoryConversationRepository) GetMessageWithSiblings(ctx context.Context, conversationID, messageID uuid.UUID) (*BranchMessage, error) {
+	message, err := r.GetMessage(ctx, conversationID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	branch := withSiblings([]models.Message{*message}, r.sortedMessages(conversationID))
+	return &branch[0], nil
+}
+
+// GetConversationHistory retrieves a page of the branch ending at leafID, or at the active leaf, for a conversation owned by the user
+func (r *MemoryConversationRepository) GetConversationHistory(ctx context.Context, conversationID uuid.UUID, userID uint, leafID *uuid.UUID, page MessagePage) (*BranchPage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	conversation, ok := r.conversations[conversationID]
+	if !ok || conversation.UserID != userID {
+		return nil, ErrConversationNotFound
+	}
+	if leafID == nil {
+		leafID = conversation.ActiveLeafID
+	}
+
+	byID := make(map[uuid.UUID]models.Message)
+	for _, message := range r.messages[conversationID] {
+		byID[message.MessageID] = message
+	}
+
+	// Pages before a key, and the latest page, are read newest first
+	backward := page.After == nil
+	messages := make([]models.Message, 0)
+	for id := leafID; id != nil; {
+		message, ok := byID[*id]
+		if !ok {
+			break
+		}
+		id = message.ParentMessageID
+
+		key := MessageKeyOf(&message)
+		if (page.After != nil && key.compare(*page.After) <= 0) || (page.Before != nil && key.compare(*page.Before) >= 0) {
+			continue
+		}
+		if !matchesMetadata(message.Metadata, page.Metadata) {
+			continue
+		}
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		less := MessageKeyOf(&messages[i]).compare(MessageKeyOf(&messages[j])) < 0
+		return less != backward
+	})
+	messages, more := trimPage(messages, page.Limit, backward)
+	return &BranchPage{Messages: withSiblings(messages, r.sortedMessages(conversationID)), LeafID: leafID, More: more}, nil
+}
+
+// GetLatestMessage retrieves the most recent message of a conversation
+func (r *MemoryConversationRepository) GetLatestMessage(ctx context.Context, conversationID uuid.UUID) (*models.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	messages := r.sortedMessages(conversationID)
+	if len(messages) == 0 {
+		return nil, ErrMessageNotFound
+	}
+	return &messages[len(messages)-1], nil
+}
+
+// GetLatestLeaf finds the most recent message in the subtree rooted at a message of a conversation owned by the user
+func (r *MemoryConversationRepository) GetLatestLeaf(ctx context.Context, conversationID uuid.UUID, userID uint, messageID uuid.UUID) (uuid.UUID, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if conversation, ok := r.conversations[conversationID]; !ok || conversation.UserID != userID {
+		return uuid.Nil, ErrConversationNotFound
+	}
+
+	// Messages are visited oldest first, so parents come before replies
+	inSubtree := make(map[uuid.UUID]bool)
+	var latest *models.Message
+	for _, message := range r.sortedMessages(conversationID) {
+		if message.MessageID == messageID || (message.ParentMessageID != nil && inSubtree[*message.ParentMessageID]) {
+			inSubtree[message.MessageID] = true
+			latest = &message
+		}
+	}
+	if latest == nil {
+		return uuid.Nil, ErrMessageNotFound
+	}
+	return latest.MessageID, nil
+}
+
+// sortedMessages returns the messages of a conversation, oldest first. The
+// caller must hold the lock.
+func (r *MemoryConversationRepository) sortedMessages(conversationID uuid.UUID) []models.Message {
+	messages := append([]models.Message(nil), r.messages[conversationID]...)
+	sort.Slice(messages, func(i, j int) bool {
+		return MessageKeyOf(&messages[i]).compare(MessageKeyOf(&messages[j])) < 0
+	})
+	return messages
+}
+
+// ListConversationsByUserID retrieves a page of conversations for a user
+func (r *MemoryConversationRepository) ListConversationsByUserID(ctx context.Context, userID uint, page ConversationPage) ([]models.Conversation, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// Pages before a key are read in reverse list order
+	backward := page.Before != nil
+	conversations := make([]models.Conversation, 0)
+	for _, conversation := range r.conversations {
+		key := ConversationKeyOf(&conversation)
+		if conversation.UserID != userID ||
+			(page.Pinned != nil && conversation.IsPinned != *page.Pinned) ||
+			(page.After != nil && key.compare(*page.After) <= 0) ||
+			(page.Before != nil && key.compare(*page.Before) >= 0) {
+			continue
+		}
+		conversations = append(conversations, conversation)
+	}
+	sort.Slice(conversations, func(i, j int) bool {
+		less := ConversationKeyOf(&conversations[i]).compare(ConversationKeyOf(&conversations[j])) < 0
+		return less != backward
+	})
+	conversations, more := trimPage(conversations, page.Limit, backward)
+	return conversations, more, nil
+}
+
+// GetConversationByID retrieves a conversation by ID
+func (r *MemoryConversationRepository) GetConversationByID(ctx context.Context, conversationID uuid.UUID) (*models.Conversation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	conversation, ok := r.conversations[conversationID]
+	if !ok {
+		return nil, ErrConversationNotFound
+	}
+	return &conversation, nil
+}
+
+// GetConversationForUpdate retrieves a conversation owned by the user. The
+// memory repository has no transactions, so nothing is locked.
+func (r *MemoryConversationRepository) GetConversationForUpdate(ctx context.Context, conversationID uuid.UUID, userID uint) (*models.Conversation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	conversation, ok := r.conversations[conversationID]
+	if !ok || conversation.UserID != userID {
+		return nil, ErrConversationNotFound
+	}
+	return &conversation, nil
+}
+
+// UpdateConversationTimestamp updates the updated_at field of a conversation
+func (r *MemoryConversationRepository) UpdateConversationTimestamp(ctx context.Context, conversationID uuid.UUID) error {
+	r.update(conversationID, func(c *models.Conversation) {
+		c.UpdatedAt = time.Now()
+	})
+	return nil
+}
+
+// SetActiveLeaf updates the active_leaf_id field of a conversation
+func (r *MemoryConversationRepository) SetActiveLeaf(ctx context.Context, conversationID uuid.UUID, leafID *uuid.UUID) error {
+	r.update(conversationID, func(c *models.Conversation) {
+		c.ActiveLeafID = leafID
+	})
+	return nil
+}
+
+// UpdateMessageContent updates the content and edited_at fields of a message
+func (r *MemoryConversationRepository) UpdateMessageContent(ctx context.Context, conversationID, messageID uuid.UUID, content string, editedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.messages[conversationID] {
+		if message := &r.messages[conversationID][i]; message.MessageID == messageID {
+			message.Content = content
+			message.Parts = nil
+			message.EditedAt = &editedAt
+			return nil
+		}
+	}
+	return ErrMessageNotFound
+}
+
+// AppendMessageContent appends to the content of an open message
+func (r *MemoryConversationRepository) AppendMessageContent(ctx context.Context, conversationID, messageID uuid.UUID, delta, status string, metadata *string, streamedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.messages[conversationID] {
+		message := &r.messages[conversationID][i]
+		if message.MessageID != messageID {
+			continue
+		}
+		if !constants.IsOpenMessageStatus(message.Status) || message.RedactedAt != nil {
+			break
+		}
+		message.Content += delta
+		message.Status = status
+		message.StreamedAt = &streamedAt
+		if metadata != nil {
+			message.Metadata = metadata
+		}
+		return nil
+	}
+	return ErrMessageNotFound
+}
+
+// ExpireStreamingMessages marks the stale open messages of a conversation as failed
+func (r *MemoryConversationRepository) ExpireStreamingMessages(ctx context.Context, conversationID uuid.UUID, staleBefore time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var expired int64
+	for i := range r.messages[conversationID] {
+		message := &r.messages[conversationID][i]
+		if constants.IsOpenMessageStatus(message.Status) && (message.StreamedAt == nil || message.StreamedAt.Before(staleBefore)) {
+			message.Status = constants.MessageStatusFailed
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// RedactMessage replaces the content of a message with a tombstone and records the redaction
+func (r *MemoryConversationRepository) RedactMessage(ctx context.Context, conversationID, messageID uuid.UUID, tombstone string, redactedBy uint, redactedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.messages[conversationID] {
+		if message := &r.messages[conversationID][i]; message.MessageID == messageID {
+			message.Content = tombstone
+			message.Metadata = nil
+			message.Parts = nil
+			message.ToolCalls = nil
+			message.ToolCallID = nil
+			message.ToolName = nil
+			message.RedactedAt = &redactedAt
+			message.RedactedBy = &redactedBy
+			return nil
+		}
+	}
+	return ErrMessageNotFound
+}
+
+// DeleteMessageSubtree deletes a message and its replies
+func (r *MemoryConversationRepository) DeleteMessageSubtree(ctx context.Context, conversationID, messageID uuid.UUID) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Messages are visited oldest first, so parents come before replies
+	inSubtree := make(map[uuid.UUID]bool)
+	for _, message := range r.sortedMessages(conversationID) {
+		if message.MessageID == messageID || (message.ParentMessageID != nil && inSubtree[*message.ParentMessageID]) {
+			inSubtree[message.MessageID] = true
+		}
+	}
+	if len(inSubtree) == 0 {
+		return 0, ErrMessageNotFound
+	}
+
+	kept := make([]models.Message, 0, len(r.messages[conversationID])-len(inSubtree))
+	for _, message := range r.messages[conversationID] {
+		if !inSubtree[message.MessageID] {
+			kept = append(kept, message)
+		}
+	}
+	r.messages[conversationID] = kept
+	return int64(len(inSubtree)), nil
+}
+
+// DeleteConversation deletes a conversation owned by the user and all its messages
+func (r *MemoryConversationRepository) DeleteConversation(ctx context.Context, conversationID uuid.UUID, userID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conversation, ok := r.conversations[conversationID]
+	if !ok || conversation.UserID != userID {
+		return ErrConversationNotFound
+	}
+	delete(r.conversations, conversationID)
+	delete(r.messages, conversationID)
+	for id, attachment := range r.attachments {
+		if attachment.ConversationID == conversationID {
+			delete(r.attachments, id)
+		}
+	}
+	return nil
+}
+
+// UpdateConversationPin updates the is_pinned status of a conversation owned by the user
+func (r *MemoryConversationRepository) UpdateConversationPin(ctx context.Context, conversationID uuid.UUID, userID uint, isPinned bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conversation, ok := r.conversations[conversationID]
+	if !ok || conversation.UserID != userID {
+		return ErrConversationNotFound
+	}
+	conversation.IsPinned = isPinned
+	r.conversations[conversationID] = conversation
+	return nil
+}
+
+// CreateAttachment creates an attachment of a conversation owned by the user
+func (r *MemoryConversationRepository) CreateAttachment(ctx context.Context, attachment *models.Attachment, userID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conversation, ok := r.conversations[attachment.ConversationID]
+	if !ok || conversation.UserID != userID {
+		return ErrConversationNotFound
+	}
+	r.attachments[attachment.AttachmentID] = *attachment
+	return nil
+}
+
+// GetAttachment retrieves an attachment by ID
+func (r *MemoryConversationRepository) GetAttachment(ctx context.Context, attachmentID uuid.UUID) (*models.Attachment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	attachment, ok := r.attachments[attachmentID]
+	if !ok {
+		return nil, ErrAttachmentNotFound
+	}
+	return &attachment, nil
+}
+
+// ListAttachments retrieves the attachments of a conversation, oldest first
+func (r *MemoryConversationRepository) ListAttachments(ctx context.Context, conversationID uuid.UUID) ([]models.Attachment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var attachments []models.Attachment
+	for _, attachment := range r.attachments {
+		if attachment.ConversationID == conversationID {
+			attachments = append(attachments, attachment)
+		}
+	}
+	sort.Slice(attachments, func(i, j int) bool {
+		a, b := attachments[i], attachments[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.AttachmentID.String() < b.AttachmentID.String()
+	})
+	return attachments, nil
+}
+
+// ListUserAttachments retrieves the attachments uploaded by a user
+func (r *MemoryConversationRepository) ListUserAttachments(ctx context.Context, userID uint) ([]models.Attachment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var attachments []models.Attachment
+	for _, attachment := range r.attachments {
+		if attachment.UserID == userID {
+			attachments = append(attachments, attachment)
+		}
+	}
+	sort.Slice(attachments, func(i, j int) bool {
+		a, b := attachments[i], attachments[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.AttachmentID.String() < b.AttachmentID.String()
+	})
+	return attachments, nil
+}
+
+// GetAttachmentUsage sums the sizes of a user's attachments
+func (r *MemoryConversationRepository) GetAttachmentUsage(ctx context.Context, userID uint) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var usage int64
+	for _, attachment := range r.attachments {
+		if attachment.UserID == userID {
+			usage += attachment.SizeBytes
+		}
+	}
+	return usage, nil
+}
+
+// GetAttachmentUsageForUpdate sums the sizes of a user's attachments. The
+// memory repository has no transactions, so nothing is locked.
+func (r *MemoryConversationRepository) GetAttachmentUsageForUpdate(ctx context.Context, userID uint) (int64, error) {
+	return r.GetAttachmentUsage(ctx, userID)
+}
+
+// SearchConversations searches the user's conversations by matching every
+// word of the query
+func (r *MemoryConversationRepository) SearchConversations(ctx context.Context, userID uint, query SearchQuery) ([]SearchResult, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var conversations []models.Conversation
+	var messages []models.Message
+	for _, conversation := range r.conversations {
+		if conversation.UserID == userID {
+			conversations = append(conversations, conversation)
+			messages = append(messages, r.messages[conversation.ConversationID]...)
+		}
+	}
+	return rankSearch(query, conversations, messages), nil
+}
+
+// update applies change to a stored conversation, if it exists
+func (r *MemoryConversationRepository) update(conversationID uuid.UUID, change func(c *models.Conversation)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if conversation, ok := r.conversations[conversationID]; ok {
+		change(&conversation)
+		r.conversations[conversationID] = conversation
+	}
+}
@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+	"user_service/internal/models"
+)
+
+// MemoryUserRepository implements UserRepository in memory. It is intended
+// for tests and single-process development.
+type MemoryUserRepository struct {
+	mu     sync.RWMutex
+	users  map[uint]models.User
+	nextID uint
+}
+
+// NewMemoryUserRepository creates an empty in-memory user repository
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: make(map[uint]models.User), nextID: 1}
+}
+
+// Create creates a new user
+func (r *MemoryUserRepository) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, taken := r.users[user.UserID]; taken || r.conflicts(user) {
+		return errUserExists
+	}
+
+	now := time.Now()
+	if user.UserID == 0 {
+		user.UserID = r.nextID
+	}
+	if user.UserID >= r.nextID {
+		r.nextID = user.UserID + 1
+	}
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now
+	}
+	if user.UpdatedAt.IsZero() {
+		user.UpdatedAt = now
+	}
+	r.users[user.UserID] = *user
+	return nil
+}
+
+// GetByID retrieves a user by ID
+func (r *MemoryUserRepository) GetByID(ctx context.Context, id uint) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return &user, nil
+}
+
+// GetByEmail retrieves a user by email
+func (r *MemoryUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.Email == email })
+}
+
+// GetByUsername retrieves a user by username
+func (r *MemoryUserRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.Username == username })
+}
+
+// GetAll retrieves all users
+func (r *MemoryUserRepository) GetAll(ctx context.Context) ([]models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := make([]models.User, 0, len(r.users))
+	for _, user := range r.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].UserID < users[j].UserID
+	})
+	return users, nil
+}
+
+// Update updates a user
+func (r *MemoryUserRepository) Update(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conflicts(user) {
+		return errUserExists
+	}
+	user.UpdatedAt = time.Now()
+	updated := *user
+	if stored, ok := r.users[user.UserID]; ok {
+		// Keep the fields changed through their own methods
+		updated.Password = stored.Password
+		updated.PasswordChangedAt = stored.PasswordChangedAt
+		updated.MFALastStep = stored.MFALastStep
+		updated.TokenVersion = stored.TokenVersion
+		updated.CreatedAt = stored.CreatedAt
+	}
+	r.users[user.UserID] = updated
+	return nil
+}
+
+// Delete deletes a user by ID
+func (r *MemoryUserRepository) Delete(ctx context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.users, id)
+	return nil
+}
+
+// EmailExists checks if an email already exists
+func (r *MemoryUserRepository) EmailExists(ctx context.Context, email string) bool {
+	_, err := r.GetByEmail(ctx, email)
+	return err == nil
+}
+
+// UsernameExists checks if a username already exists
+func (r *MemoryUserRepository) UsernameExists(ctx context.Context, username string) bool {
+	_, err := r.GetByUsername(ctx, username)
+	return err == nil
+}
+
+// IncrementTokenVersion bumps a user's token version
+func (r *MemoryUserRepository) IncrementTokenVersion(ctx context.Context, id uint) error {
+	r.update(id, func(u *models.User) bool {
+		u.TokenVersion++
+		return true
+	})
+	return nil
+}
+
+// UpdatePassword sets a user's password hash and records the change time
+func (r *MemoryUserRepository) UpdatePassword(ctx context.Context, id uint, hashedPassword string) error {
+	r.update(id, func(u *models.User) bool {
+		now := time.Now()
+		u.Password = hashedPassword
+		u.PasswordChangedAt = &now
+		return true
+	})
+	return nil
+}
+
+// AdvanceMFAStep records a TOTP time step as used if it is newer than the last accepted one
+func (r *MemoryUserRepository) AdvanceMFAStep(ctx context.Context, id uint, step int64) (bool, error) {
+	return r.update(id, func(u *models.User) bool {
+		if u.MFALastStep >= step {
+			return false
+		}
+		u.MFALastStep = step
+		return true
+	}), nil
+}
+
+// find returns a copy of the first user matching match
+func (r *MemoryUserRepository) find(match func(u *models.User) bool) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if match(&user) {
+			return &user, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+// update applies change to a stored user and reports whether it was applied
+func (r *MemoryUserRepository) update(id uint, change func(u *models.User) bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || !change(&user) {
+		return false
+	}
+	r.users[id] = user
+	return true
+}
+
+// conflicts reports whether another user has the same email or username.
+// The caller must hold the lock.
+func (r *MemoryUserRepository) conflicts(user *models.User) bool {
+	for id, existing := range r.users {
+		if id != user.UserID && (existing.Email == user.Email || existing.Username == user.Username) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// MetadataFilter selects messages by a top-level key of their metadata. Keys
+// are plain identifiers, validated by the caller, so that they can be used in
+// JSON paths unquoted.
+type MetadataFilter struct {
+	Key string
+	// Value is the JSON encoding of a scalar the key must equal, or nil if the
+	// key only has to be present
+	Value json.RawMessage
+}
+
+// metadataCondition returns a SQL condition requiring column to match every
+// filter. bind adds an argument and returns its placeholder, so that the
+// condition fits both positional and named queries.
+func metadataCondition(dialect, column string, filters []MetadataFilter, bind func(interface{}) string) string {
+	condition := ""
+	for _, filter := range filters {
+		switch {
+		case filter.Value == nil:
+			// -> is NULL for a missing key, and a JSON null otherwise
+			condition += " AND " + column + " -> CAST(" + bind(filter.Key) + " AS text) IS NOT NULL"
+		case dialect == "postgres":
+			// Containment can use the GIN index of migration 0008_message_metadata
+			contained, _ := json.Marshal(map[string]json.RawMessage{filter.Key: filter.Value})
+			condition += " AND " + column + " @> CAST(" + bind(string(contained)) + " AS jsonb)"
+		default:
+			// SQLite returns the value in its minified JSON form
+			condition += " AND " + column + " -> CAST(" + bind(filter.Key) + " AS text) = " + bind(string(filter.Value))
+		}
+	}
+	return condition
+}
+
+// matchesMetadata reports whether stored metadata matches every filter
+func matchesMetadata(metadata *string, filters []MetadataFilter) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	if metadata == nil {
+		return false
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(*metadata), &fields); err != nil {
+		return false
+	}
+	for _, filter := range filters {
+		value, ok := fields[filter.Key]
+		if !ok {
+			return false
+		}
+		if filter.Value == nil {
+			continue
+		}
+		var want interface{}
+		if err := json.Unmarshal(filter.Value, &want); err != nil || !reflect.DeepEqual(value, want) {
+			return false
+		}
+	}
+	return true
+}
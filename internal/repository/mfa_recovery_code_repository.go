@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"user_service/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// MFARecoveryCodeRepository handles database operations for MFA recovery codes
+type MFARecoveryCodeRepository struct {
+	db *gorm.DB
+}
+
+// NewMFARecoveryCodeRepository creates a new MFA recovery code repository
+func NewMFARecoveryCodeRepository(db *gorm.DB) *MFARecoveryCodeRepository {
+	return &MFARecoveryCodeRepository{db: db}
+}
+
+// Replace deletes a user's recovery codes and stores a new set
+func (r *MFARecoveryCodeRepository) Replace(ctx context.Context, userID uint, codeHashes []string) error {
+	return Conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		codes := make([]models.MFARecoveryCode, len(codeHashes))
+		for i, hash := range codeHashes {
+			codes[i] = models.MFARecoveryCode{UserID: userID, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// Use consumes an unused recovery code. It reports whether a code was consumed.
+func (r *MFARecoveryCodeRepository) Use(ctx context.Context, userID uint, codeHash string) (bool, error) {
+	result := Conn(ctx, r.db).Model(&models.MFARecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// DeleteAllForUser removes every recovery code belonging to a user
+func (r *MFARecoveryCodeRepository) DeleteAllForUser(ctx context.Context, userID uint) error {
+	return Conn(ctx, r.db).Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error
+}
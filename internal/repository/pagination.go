@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"bytes"
+	"slices"
+	"time"
+	"user_service/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// ConversationKey is the position of a conversation in a user's list, which
+// is ordered pinned first, then most recently updated first
+type ConversationKey struct {
+	IsPinned       bool
+	UpdatedAt      time.Time
+	ConversationID uuid.UUID
+}
+
+// MessageKey is the position of a message in a conversation, which is
+// ordered oldest first
+type MessageKey struct {
+	Timestamp time.Time
+	MessageID uuid.UUID
+}
+
+// ConversationPage selects part of a user's conversation list. At most one of
+// After and Before is set; with neither the page starts at the top of the list.
+type ConversationPage struct {
+	// Pinned, if set, only lists pinned or only unpinned conversations
+	Pinned *bool
+	Limit  int
+	// After selects the conversations following the key in list order
+	After *ConversationKey
+	// Before selects the conversations preceding the key in list order
+	Before *ConversationKey
+}
+
+// MessagePage selects part of a conversation's messages. At most one of After
+// and Before is set; with neither the page holds the latest messages.
+type MessagePage struct {
+	Limit int
+	// After selects the messages following the key
+	After *MessageKey
+	// Before selects the messages preceding the key
+	Before *MessageKey
+	// Metadata restricts the page to messages matching every filter
+	Metadata []MetadataFilter
+}
+
+// ConversationKeyOf returns the list position of a conversation
+func ConversationKeyOf(c *models.Conversation) ConversationKey {
+	return ConversationKey{IsPinned: c.IsPinned, UpdatedAt: c.UpdatedAt, ConversationID: c.ConversationID}
+}
+
+// MessageKeyOf returns the position of a message in its conversation
+func MessageKeyOf(m *models.Message) MessageKey {
+	return MessageKey{Timestamp: m.Timestamp, MessageID: m.MessageID}
+}
+
+// compare returns a negative number if k comes before o in list order, a
+// positive number if it comes after and zero if they are equal
+func (k ConversationKey) compare(o ConversationKey) int {
+	if k.IsPinned != o.IsPinned {
+		if k.IsPinned {
+			return -1
+		}
+		return 1
+	}
+	if c := o.UpdatedAt.Compare(k.UpdatedAt); c != 0 {
+		return c
+	}
+	return bytes.Compare(o.ConversationID[:], k.ConversationID[:])
+}
+
+// compare returns a negative number if k comes before o, a positive number if
+// it comes after and zero if they are equal
+func (k MessageKey) compare(o MessageKey) int {
+	if c := k.Timestamp.Compare(o.Timestamp); c != 0 {
+		return c
+	}
+	return bytes.Compare(k.MessageID[:], o.MessageID[:])
+}
+
+// trimPage drops the extra row fetched to find out whether the list goes on
+// past the page and, for pages read backwards, restores list order. It
+// reports whether there were more rows.
+func trimPage[T any](rows []T, limit int, backward bool) ([]T, bool) {
+	more := len(rows) > limit
+	if more {
+		rows = rows[:limit]
+	}
+	if backward {
+		slices.Reverse(rows)
+	}
+	return rows, more
+}
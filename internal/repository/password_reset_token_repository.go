@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user_service/internal/apperrors"
+	"user_service/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// PasswordResetTokenRepository handles database operations for password reset tokens
+type PasswordResetTokenRepository struct {
+	db *gorm.DB
+}
+
+// NewPasswordResetTokenRepository creates a new password reset token repository
+func NewPasswordResetTokenRepository(db *gorm.DB) *PasswordResetTokenRepository {
+	return &PasswordResetTokenRepository{db: db}
+}
+
+// Create stores a new password reset token
+func (r *PasswordResetTokenRepository) Create(ctx context.Context, token *models.PasswordResetToken) error {
+	return Conn(ctx, r.db).Create(token).Error
+}
+
+// GetByHash retrieves a password reset token by the hash of its value
+func (r *PasswordResetTokenRepository) GetByHash(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error) {
+	var token models.PasswordResetToken
+	if err := Conn(ctx, r.db).Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, apperrors.NotFound("reset_token_not_found", "password reset token not found")
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkUsed consumes a password reset token if it has not been used yet.
+// It reports whether this call consumed the token.
+func (r *PasswordResetTokenRepository) MarkUsed(ctx context.Context, tokenID uint) (bool, error) {
+	result := Conn(ctx, r.db).Model(&models.PasswordResetToken{}).
+		Where("token_id = ? AND used_at IS NULL", tokenID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// InvalidateForUser consumes every outstanding reset token for a user
+func (r *PasswordResetTokenRepository) InvalidateForUser(ctx context.Context, userID uint) error {
+	return Conn(ctx, r.db).Model(&models.PasswordResetToken{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Update("used_at", time.Now()).Error
+}
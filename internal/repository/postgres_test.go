@@ -0,0 +1,54 @@
+//go:build postgres
+
+package repository_test
+
+import (
+	"os"
+	"testing"
+	"user_service/config"
+	"user_service/internal/database"
+	"user_service/internal/migrations"
+	"user_service/internal/repository"
+	"user_service/internal/repository/repotest"
+
+	"gorm.io/gorm"
+)
+
+// TestPostgresRepositories runs the contract suite against the GORM
+// implementations on the database named by TEST_DATABASE_URL, which is
+// migrated and then emptied before every test. The test is skipped when the
+// variable is not set.
+func TestPostgresRepositories(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := database.Open(&config.Config{DatabaseURL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrator, err := migrations.NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	repotest.RunUserRepositoryTests(t, func(t *testing.T) repository.UserRepository {
+		return repository.NewUserRepository(emptyPostgres(t, db))
+	})
+	repotest.RunConversationRepositoryTests(t, func(t *testing.T) (repository.UserRepository, repository.ConversationRepository) {
+		db := emptyPostgres(t, db)
+		return repository.NewUserRepository(db), repository.NewConversationRepository(db)
+	})
+}
+
+// emptyPostgres deletes every row written by the suite
+func emptyPostgres(t *testing.T, db *gorm.DB) *gorm.DB {
+	t.Helper()
+	if err := db.Exec("TRUNCATE users, conversations, messages, attachments RESTART IDENTITY CASCADE").Error; err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
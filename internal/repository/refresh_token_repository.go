@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user_service/internal/apperrors"
+	"user_service/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// RefreshTokenRepository handles database operations for refresh tokens
+type RefreshTokenRepository struct {
+	db *gorm.DB
+}
+
+// NewRefreshTokenRepository creates a new refresh token repository
+func NewRefreshTokenRepository(db *gorm.DB) *RefreshTokenRepository {
+	return &RefreshTokenRepository{db: db}
+}
+
+// Create stores a new refresh token
+func (r *RefreshTokenRepository) Create(ctx context.Context, token *models.RefreshToken) error {
+	return Conn(ctx, r.db).Create(token).Error
+}
+
+// GetByHash retrieves a refresh token by the hash of its value
+func (r *RefreshTokenRepository) GetByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	if err := Conn(ctx, r.db).Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, apperrors.NotFound("refresh_token_not_found", "refresh token not found")
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// Revoke marks a refresh token as revoked if it is still active.
+// It reports whether this call performed the revocation, so that two
+// concurrent rotations of the same token cannot both succeed.
+func (r *RefreshTokenRepository) Revoke(ctx context.Context, tokenID uint) (bool, error) {
+	result := Conn(ctx, r.db).Model(&models.RefreshToken{}).
+		Where("token_id = ? AND revoked_at IS NULL", tokenID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// SetReplacedBy links a rotated refresh token to its successor
+func (r *RefreshTokenRepository) SetReplacedBy(ctx context.Context, tokenID uint, replacedBy uint) error {
+	return Conn(ctx, r.db).Model(&models.RefreshToken{}).Where("token_id = ?", tokenID).Update("replaced_by", replacedBy).Error
+}
+
+// RevokeFamily revokes every active refresh token in a token family
+func (r *RefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	return Conn(ctx, r.db).Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeAllForUser revokes every active refresh token belonging to a user
+func (r *RefreshTokenRepository) RevokeAllForUser(ctx context.Context, userID uint) error {
+	return Conn(ctx, r.db).Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
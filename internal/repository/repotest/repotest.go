@@ -0,0 +1,1481 @@
+// Package repotest is a contract test suite for repository implementations.
+// Every UserRepository and ConversationRepository must pass it, so that the
+// GORM and in-memory implementations stay interchangeable. Call it from a
+// test with a factory for the implementation under test, for example:
+//
+//	repotest.RunUserRepositoryTests(t, func(t *testing.T) repository.UserRepository {
+//		return repository.NewMemoryUserRepository()
+//	})
+//
+// The GORM implementations can be run against a :memory: SQLite database
+// opened with database.InitDB.
+package repotest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"user_service/internal/apperrors"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// ctx is passed to every repository call made by the suite
+var ctx = context.Background()
+
+// Pages large enough to hold everything a test creates
+var (
+	allMessages      = repository.MessagePage{Limit: 1000}
+	allConversations = repository.ConversationPage{Limit: 1000}
+)
+
+// UserRepositoryFactory returns an empty repository for a single test
+type UserRepositoryFactory func(t *testing.T) repository.UserRepository
+
+// ConversationRepositoryFactory returns empty repositories for a single test.
+// Conversations may reference users, so both are created together.
+type ConversationRepositoryFactory func(t *testing.T) (repository.UserRepository, repository.ConversationRepository)
+
+// RunUserRepositoryTests runs the UserRepository contract
+func RunUserRepositoryTests(t *testing.T, newRepo UserRepositoryFactory) {
+	t.Run("CreateAssignsIDAndTimestamps", func(t *testing.T) {
+		repo := newRepo(t)
+		user := newUser("alice")
+		mustCreateUser(t, repo, user)
+
+		if user.UserID == 0 {
+			t.Fatal("expected an ID to be assigned")
+		}
+		if user.CreatedAt.IsZero() || user.UpdatedAt.IsZero() {
+			t.Fatal("expected timestamps to be set")
+		}
+	})
+
+	t.Run("LookupsReturnStoredUser", func(t *testing.T) {
+		repo := newRepo(t)
+		user := newUser("alice")
+		mustCreateUser(t, repo, user)
+
+		lookups := map[string]func() (*models.User, error){
+			"GetByID":       func() (*models.User, error) { return repo.GetByID(ctx, user.UserID) },
+			"GetByEmail":    func() (*models.User, error) { return repo.GetByEmail(ctx, user.Email) },
+			"GetByUsername": func() (*models.User, error) { return repo.GetByUsername(ctx, user.Username) },
+		}
+		for name, lookup := range lookups {
+			got, err := lookup()
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if got.UserID != user.UserID || got.Email != user.Email || got.Username != user.Username {
+				t.Fatalf("%s: got %+v, want %+v", name, got, user)
+			}
+		}
+	})
+
+	t.Run("LookupsReturnNotFound", func(t *testing.T) {
+		repo := newRepo(t)
+
+		if _, err := repo.GetByID(ctx, 42); !errors.Is(err, repository.ErrUserNotFound) {
+			t.Fatalf("GetByID: got %v, want ErrUserNotFound", err)
+		}
+		if _, err := repo.GetByEmail(ctx, "nobody@example.com"); !errors.Is(err, repository.ErrUserNotFound) {
+			t.Fatalf("GetByEmail: got %v, want ErrUserNotFound", err)
+		}
+		if _, err := repo.GetByUsername(ctx, "nobody"); !errors.Is(err, repository.ErrUserNotFound) {
+			t.Fatalf("GetByUsername: got %v, want ErrUserNotFound", err)
+		}
+	})
+
+	t.Run("EmailAndUsernameAreUnique", func(t *testing.T) {
+		repo := newRepo(t)
+		mustCreateUser(t, repo, newUser("alice"))
+
+		sameEmail := newUser("bob")
+		sameEmail.Email = "alice@example.com"
+		if err := repo.Create(ctx, sameEmail); !errors.Is(err, apperrors.ErrConflict) {
+			t.Fatalf("duplicate email: got %v, want a conflict", err)
+		}
+
+		sameUsername := newUser("carol")
+		sameUsername.Username = "alice"
+		if err := repo.Create(ctx, sameUsername); !errors.Is(err, apperrors.ErrConflict) {
+			t.Fatalf("duplicate username: got %v, want a conflict", err)
+		}
+
+		if !repo.EmailExists(ctx, "alice@example.com") || !repo.UsernameExists(ctx, "alice") {
+			t.Fatal("expected existing email and username to be reported")
+		}
+		if repo.EmailExists(ctx, "bob@example.com") || repo.UsernameExists(ctx, "bob") {
+			t.Fatal("expected unknown email and username not to be reported")
+		}
+	})
+
+	t.Run("UpdateRejectsTakenEmail", func(t *testing.T) {
+		repo := newRepo(t)
+		mustCreateUser(t, repo, newUser("alice"))
+		bob := newUser("bob")
+		mustCreateUser(t, repo, bob)
+
+		bob.Email = "alice@example.com"
+		if err := repo.Update(ctx, bob); !errors.Is(err, apperrors.ErrConflict) {
+			t.Fatalf("got %v, want a conflict", err)
+		}
+	})
+
+	t.Run("UpdateSavesFields", func(t *testing.T) {
+		repo := newRepo(t)
+		user := newUser("alice")
+		mustCreateUser(t, repo, user)
+
+		user.FirstName = "Alicia"
+		user.Role = "admin"
+		if err := repo.Update(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := repo.GetByID(ctx, user.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.FirstName != "Alicia" || got.Role != "admin" {
+			t.Fatalf("got %+v, want updated fields", got)
+		}
+	})
+
+	t.Run("UpdateKeepsConcurrentChanges", func(t *testing.T) {
+		repo := newRepo(t)
+		user := newUser("alice")
+		mustCreateUser(t, repo, user)
+
+		// A copy loaded before the token version, MFA step and password change
+		stale, err := repo.GetByID(ctx, user.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.IncrementTokenVersion(ctx, user.UserID); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := repo.AdvanceMFAStep(ctx, user.UserID, 42); err != nil || !ok {
+			t.Fatalf("AdvanceMFAStep: got %v, %v", ok, err)
+		}
+		if err := repo.UpdatePassword(ctx, user.UserID, "new-hash"); err != nil {
+			t.Fatal(err)
+		}
+
+		stale.FirstName = "Alicia"
+		if err := repo.Update(ctx, stale); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := repo.GetByID(ctx, user.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.FirstName != "Alicia" {
+			t.Fatalf("got first name %q, want the update applied", got.FirstName)
+		}
+		if got.TokenVersion != 1 || got.MFALastStep != 42 || got.Password != "new-hash" || got.PasswordChangedAt == nil {
+			t.Fatalf("got token version %d, MFA step %d, password %q: the stale copy undid concurrent changes",
+				got.TokenVersion, got.MFALastStep, got.Password)
+		}
+	})
+
+	t.Run("GetAllIsOrderedByID", func(t *testing.T) {
+		repo := newRepo(t)
+		for _, name := range []string{"carol", "alice", "bob"} {
+			mustCreateUser(t, repo, newUser(name))
+		}
+
+		users, err := repo.GetAll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != 3 {
+			t.Fatalf("got %d users, want 3", len(users))
+		}
+		for i := 1; i < len(users); i++ {
+			if users[i-1].UserID >= users[i].UserID {
+				t.Fatalf("users not ordered by ID: %d before %d", users[i-1].UserID, users[i].UserID)
+			}
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		repo := newRepo(t)
+		user := newUser("alice")
+		mustCreateUser(t, repo, user)
+
+		if err := repo.Delete(ctx, user.UserID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.GetByID(ctx, user.UserID); !errors.Is(err, repository.ErrUserNotFound) {
+			t.Fatalf("got %v, want ErrUserNotFound", err)
+		}
+		if err := repo.Delete(ctx, user.UserID); err != nil {
+			t.Fatalf("deleting a missing user: %v", err)
+		}
+	})
+
+	t.Run("IncrementTokenVersion", func(t *testing.T) {
+		repo := newRepo(t)
+		user := newUser("alice")
+		mustCreateUser(t, repo, user)
+
+		for i := 0; i < 2; i++ {
+			if err := repo.IncrementTokenVersion(ctx, user.UserID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := repo.GetByID(ctx, user.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.TokenVersion != 2 {
+			t.Fatalf("got token version %d, want 2", got.TokenVersion)
+		}
+	})
+
+	t.Run("UpdatePassword", func(t *testing.T) {
+		repo := newRepo(t)
+		user := newUser("alice")
+		mustCreateUser(t, repo, user)
+
+		if err := repo.UpdatePassword(ctx, user.UserID, "new-hash"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := repo.GetByID(ctx, user.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Password != "new-hash" || got.PasswordChangedAt == nil {
+			t.Fatalf("got password %q changed at %v", got.Password, got.PasswordChangedAt)
+		}
+	})
+
+	t.Run("AdvanceMFAStepOnlyMovesForward", func(t *testing.T) {
+		repo := newRepo(t)
+		user := newUser("alice")
+		mustCreateUser(t, repo, user)
+
+		steps := []struct {
+			step int64
+			want bool
+		}{{10, true}, {10, false}, {9, false}, {11, true}}
+		for _, s := range steps {
+			got, err := repo.AdvanceMFAStep(ctx, user.UserID, s.step)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != s.want {
+				t.Fatalf("step %d: got %v, want %v", s.step, got, s.want)
+			}
+		}
+	})
+}
+
+// RunConversationRepositoryTests runs the ConversationRepository contract
+func RunConversationRepositoryTests(t *testing.T, newRepos ConversationRepositoryFactory) {
+	t.Run("GetConversationByID", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+
+		got, err := repo.GetConversationByID(ctx, conversation.ConversationID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.UserID != owner || got.Title != conversation.Title {
+			t.Fatalf("got %+v, want %+v", got, conversation)
+		}
+
+		if _, err := repo.GetConversationByID(ctx, uuid.New()); !errors.Is(err, repository.ErrConversationNotFound) {
+			t.Fatalf("got %v, want ErrConversationNotFound", err)
+		}
+	})
+
+	t.Run("HistoryFollowsBranchToLeaf", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+
+		// root -> first -> reply, and an alternative to first
+		start := time.Now().Add(-time.Hour)
+		root := mustAppendMessage(t, repo, owner, conversation, nil, start)
+		first := mustAppendMessage(t, repo, owner, conversation, root, start.Add(time.Minute))
+		reply := mustAppendMessage(t, repo, owner, conversation, first, start.Add(2*time.Minute))
+		alternative := mustAppendMessage(t, repo, owner, conversation, root, start.Add(3*time.Minute))
+
+		branch, _, err := history(repo, conversation.ConversationID, owner, &reply.MessageID, allMessages)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBranch(t, branch, root, first, reply)
+		if len(branch[1].SiblingIDs) != 2 || branch[1].SiblingIDs[0] != first.MessageID || branch[1].SiblingIDs[1] != alternative.MessageID {
+			t.Fatalf("got siblings %v, want first and alternative, oldest first", branch[1].SiblingIDs)
+		}
+		if len(branch[0].SiblingIDs) != 1 || len(branch[2].SiblingIDs) != 1 {
+			t.Fatal("messages without alternatives must only list themselves as siblings")
+		}
+
+		branch, _, err = history(repo, conversation.ConversationID, owner, &alternative.MessageID, allMessages)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBranch(t, branch, root, alternative)
+	})
+
+	t.Run("HistoryDefaultsToActiveBranch", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+
+		start := time.Now().Add(-time.Hour)
+		root := mustAppendMessage(t, repo, owner, conversation, nil, start)
+		first := mustAppendMessage(t, repo, owner, conversation, root, start.Add(time.Minute))
+		mustAppendMessage(t, repo, owner, conversation, root, start.Add(2*time.Minute))
+		if err := repo.SetActiveLeaf(ctx, conversation.ConversationID, &first.MessageID); err != nil {
+			t.Fatal(err)
+		}
+
+		page, err := repo.GetConversationHistory(ctx, conversation.ConversationID, owner, nil, allMessages)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBranch(t, page.Messages, root, first)
+		if page.LeafID == nil || *page.LeafID != first.MessageID {
+			t.Fatalf("got leaf %v, want the active leaf %v", page.LeafID, first.MessageID)
+		}
+
+		page, err = repo.GetConversationHistory(ctx, conversation.ConversationID, owner, &root.MessageID, allMessages)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBranch(t, page.Messages, root)
+		if page.LeafID == nil || *page.LeafID != root.MessageID {
+			t.Fatalf("got leaf %v, want the requested leaf %v", page.LeafID, root.MessageID)
+		}
+	})
+
+	t.Run("LatestLeafIsNewestMessageInSubtree", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+
+		start := time.Now().Add(-time.Hour)
+		root := mustAppendMessage(t, repo, owner, conversation, nil, start)
+		first := mustAppendMessage(t, repo, owner, conversation, root, start.Add(time.Minute))
+		alternative := mustAppendMessage(t, repo, owner, conversation, root, start.Add(2*time.Minute))
+		reply := mustAppendMessage(t, repo, owner, conversation, first, start.Add(3*time.Minute))
+
+		leaves := map[*models.Message]*models.Message{root: reply, first: reply, alternative: alternative, reply: reply}
+		for from, want := range leaves {
+			got, err := repo.GetLatestLeaf(ctx, conversation.ConversationID, owner, from.MessageID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want.MessageID {
+				t.Fatalf("latest leaf under %v: got %v, want %v", from.MessageID, got, want.MessageID)
+			}
+		}
+
+		otherConversation := mustCreateConversation(t, repo, owner, time.Now())
+		if _, err := repo.GetLatestLeaf(ctx, otherConversation.ConversationID, owner, root.MessageID); !errors.Is(err, repository.ErrMessageNotFound) {
+			t.Fatalf("message of another conversation: got %v, want ErrMessageNotFound", err)
+		}
+		other := createOwner(t, users, "bob")
+		if _, err := repo.GetLatestLeaf(ctx, conversation.ConversationID, other, root.MessageID); !errors.Is(err, repository.ErrConversationNotFound) {
+			t.Fatalf("conversation of another user: got %v, want ErrConversationNotFound", err)
+		}
+		if _, err := repo.GetLatestLeaf(ctx, uuid.New(), owner, root.MessageID); !errors.Is(err, repository.ErrConversationNotFound) {
+			t.Fatalf("missing conversation: got %v, want ErrConversationNotFound", err)
+		}
+		if _, err := repo.GetMessage(ctx, conversation.ConversationID, uuid.New()); !errors.Is(err, repository.ErrMessageNotFound) {
+			t.Fatalf("GetMessage: got %v, want ErrMessageNotFound", err)
+		}
+	})
+
+	t.Run("GetMessageWithSiblings", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+
+		start := time.Now().Add(-time.Hour)
+		root := mustAppendMessage(t, repo, owner, conversation, nil, start)
+		first := mustAppendMessage(t, repo, owner, conversation, root, start.Add(time.Minute))
+		second := mustAppendMessage(t, repo, owner, conversation, root, start.Add(2*time.Minute))
+		mustAppendMessage(t, repo, owner, conversation, first, start.Add(3*time.Minute))
+
+		got, err := repo.GetMessageWithSiblings(ctx, conversation.ConversationID, second.MessageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.MessageID != second.MessageID || !slices.Equal(got.SiblingIDs, []uuid.UUID{first.MessageID, second.MessageID}) {
+			t.Fatalf("got %v with siblings %v, want %v with siblings [%v %v]", got.MessageID, got.SiblingIDs, second.MessageID, first.MessageID, second.MessageID)
+		}
+		if got, err = repo.GetMessageWithSiblings(ctx, conversation.ConversationID, root.MessageID); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got.SiblingIDs, []uuid.UUID{root.MessageID}) {
+			t.Fatalf("root: got siblings %v, want [%v]", got.SiblingIDs, root.MessageID)
+		}
+
+		if _, err := repo.GetMessageWithSiblings(ctx, uuid.New(), root.MessageID); !errors.Is(err, repository.ErrMessageNotFound) {
+			t.Fatalf("message of another conversation: got %v, want ErrMessageNotFound", err)
+		}
+	})
+
+	t.Run("ActiveLeafIsStored", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		other := createOwner(t, users, "bob")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+		message := mustAppendMessage(t, repo, owner, conversation, nil, time.Now())
+
+		if err := repo.SetActiveLeaf(ctx, conversation.ConversationID, &message.MessageID); err != nil {
+			t.Fatal(err)
+		}
+		got, err := repo.GetConversationForUpdate(ctx, conversation.ConversationID, owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ActiveLeafID == nil || *got.ActiveLeafID != message.MessageID {
+			t.Fatalf("got active leaf %v, want %v", got.ActiveLeafID, message.MessageID)
+		}
+
+		if _, err := repo.GetConversationForUpdate(ctx, conversation.ConversationID, other); !errors.Is(err, repository.ErrConversationNotFound) {
+			t.Fatalf("GetConversationForUpdate by another user: got %v, want ErrConversationNotFound", err)
+		}
+
+		if err := repo.SetActiveLeaf(ctx, conversation.ConversationID, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got, err = repo.GetConversationForUpdate(ctx, conversation.ConversationID, owner); err != nil {
+			t.Fatal(err)
+		}
+		if got.ActiveLeafID != nil {
+			t.Fatalf("got active leaf %v after clearing it", *got.ActiveLeafID)
+		}
+	})
+
+	t.Run("EmptyConversationHasNoMessages", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+
+		messages, _, err := history(repo, conversation.ConversationID, owner, nil, allMessages)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != 0 {
+			t.Fatalf("got %d messages, want 0", len(messages))
+		}
+	})
+
+	t.Run("OtherUsersConversationsAreNotFound", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		other := createOwner(t, users, "bob")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+
+		message := newMessage(conversation.ConversationID, time.Now())
+		if err := repo.CreateMessage(ctx, message, other); !errors.Is(err, repository.ErrConversationNotFound) {
+			t.Fatalf("CreateMessage: got %v, want ErrConversationNotFound", err)
+		}
+		if _, _, err := history(repo, conversation.ConversationID, other, nil, allMessages); !errors.Is(err, repository.ErrConversationNotFound) {
+			t.Fatalf("GetConversationHistory: got %v, want ErrConversationNotFound", err)
+		}
+		if err := repo.CreateMessage(ctx, newMessage(uuid.New(), time.Now()), owner); !errors.Is(err, repository.ErrConversationNotFound) {
+			t.Fatalf("CreateMessage in missing conversation: got %v, want ErrConversationNotFound", err)
+		}
+		if err := repo.UpdateConversationPin(ctx, conversation.ConversationID, other, true); !errors.Is(err, repository.ErrConversationNotFound) {
+			t.Fatalf("UpdateConversationPin: got %v, want ErrConversationNotFound", err)
+		}
+		if err := repo.DeleteConversation(ctx, conversation.ConversationID, other); !errors.Is(err, repository.ErrConversationNotFound) {
+			t.Fatalf("DeleteConversation: got %v, want ErrConversationNotFound", err)
+		}
+
+		// Nothing may have changed for the owner
+		got, err := repo.GetConversationByID(ctx, conversation.ConversationID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.IsPinned {
+			t.Fatal("conversation pinned by another user")
+		}
+	})
+
+	t.Run("ListIsMostRecentlyUpdatedFirst", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		other := createOwner(t, users, "bob")
+
+		now := time.Now()
+		oldest := mustCreateConversation(t, repo, owner, now.Add(-2*time.Hour))
+		newest := mustCreateConversation(t, repo, owner, now.Add(-time.Hour))
+		mustCreateConversation(t, repo, other, now)
+
+		if err := repo.UpdateConversationTimestamp(ctx, oldest.ConversationID); err != nil {
+			t.Fatal(err)
+		}
+
+		conversations, _, err := repo.ListConversationsByUserID(ctx, owner, allConversations)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(conversations) != 2 {
+			t.Fatalf("got %d conversations, want 2", len(conversations))
+		}
+		if conversations[0].ConversationID != oldest.ConversationID || conversations[1].ConversationID != newest.ConversationID {
+			t.Fatal("conversations not ordered by most recent update")
+		}
+	})
+
+	t.Run("ListPagesCoverEveryConversationOnce", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+
+		// Equal timestamps must still be paged through in a stable order
+		now := time.Now()
+		var want []uuid.UUID
+		for i := 0; i < 7; i++ {
+			conversation := mustCreateConversation(t, repo, owner, now.Add(-time.Duration(i/2)*time.Minute))
+			if i == 3 || i == 6 {
+				if err := repo.UpdateConversationPin(ctx, conversation.ConversationID, owner, true); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want = append(want, conversation.ConversationID)
+		}
+
+		// Walk forwards from the top, then backwards from the last page
+		var forward []models.Conversation
+		page := repository.ConversationPage{Limit: 3}
+		for {
+			conversations, more, err := repo.ListConversationsByUserID(ctx, owner, page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			forward = append(forward, conversations...)
+			if !more {
+				break
+			}
+			last := repository.ConversationKeyOf(&conversations[len(conversations)-1])
+			page = repository.ConversationPage{Limit: 3, After: &last}
+		}
+		if len(forward) != len(want) {
+			t.Fatalf("got %d conversations walking forwards, want %d", len(forward), len(want))
+		}
+		if !forward[0].IsPinned || !forward[1].IsPinned || forward[2].IsPinned {
+			t.Fatal("pinned conversations not listed first")
+		}
+		seen := make(map[uuid.UUID]bool)
+		for _, conversation := range forward {
+			if seen[conversation.ConversationID] {
+				t.Fatalf("conversation %s listed twice", conversation.ConversationID)
+			}
+			seen[conversation.ConversationID] = true
+		}
+
+		var backward []models.Conversation
+		first := repository.ConversationKeyOf(&forward[len(forward)-1])
+		backward = append(backward, forward[len(forward)-1])
+		for {
+			page := repository.ConversationPage{Limit: 3, Before: &first}
+			conversations, more, err := repo.ListConversationsByUserID(ctx, owner, page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			backward = append(conversations, backward...)
+			if !more {
+				break
+			}
+			first = repository.ConversationKeyOf(&conversations[0])
+		}
+		for i := range forward {
+			if backward[i].ConversationID != forward[i].ConversationID {
+				t.Fatalf("walking backwards listed %s at position %d, want %s", backward[i].ConversationID, i, forward[i].ConversationID)
+			}
+		}
+	})
+
+	t.Run("ListCanBeFilteredByPin", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		pinned := mustCreateConversation(t, repo, owner, time.Now().Add(-time.Hour))
+		mustCreateConversation(t, repo, owner, time.Now())
+		if err := repo.UpdateConversationPin(ctx, pinned.ConversationID, owner, true); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, isPinned := range []bool{true, false} {
+			conversations, more, err := repo.ListConversationsByUserID(ctx, owner, repository.ConversationPage{Limit: 10, Pinned: &isPinned})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(conversations) != 1 || more || conversations[0].IsPinned != isPinned {
+				t.Fatalf("pinned=%v: got %d conversations, want 1 matching", isPinned, len(conversations))
+			}
+		}
+	})
+
+	t.Run("HistoryPagesStartAtLatest", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+
+		start := time.Now().Add(-time.Hour)
+		var leaf *models.Message
+		for i := 0; i < 5; i++ {
+			leaf = mustAppendMessage(t, repo, owner, conversation, leaf, start.Add(time.Duration(i/2)*time.Minute))
+		}
+		all, _, err := history(repo, conversation.ConversationID, owner, &leaf.MessageID, allMessages)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The default page holds the latest messages, oldest first
+		latest, more, err := history(repo, conversation.ConversationID, owner, &leaf.MessageID, repository.MessagePage{Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !more || len(latest) != 2 || latest[0].MessageID != all[3].MessageID || latest[1].MessageID != all[4].MessageID {
+			t.Fatal("default page does not hold the latest messages")
+		}
+
+		// Walk back to the first message
+		before := repository.MessageKeyOf(&latest[0].Message)
+		earlier, more, err := history(repo, conversation.ConversationID, owner, &leaf.MessageID, repository.MessagePage{Limit: 3, Before: &before})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if more || len(earlier) != 3 || earlier[0].MessageID != all[0].MessageID || earlier[2].MessageID != all[2].MessageID {
+			t.Fatal("page before the latest messages is wrong")
+		}
+
+		// And forwards again
+		after := repository.MessageKeyOf(&earlier[1].Message)
+		later, more, err := history(repo, conversation.ConversationID, owner, &leaf.MessageID, repository.MessagePage{Limit: 2, After: &after})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !more || len(later) != 2 || later[0].MessageID != all[2].MessageID || later[1].MessageID != all[3].MessageID {
+			t.Fatal("page after a message is wrong")
+		}
+	})
+
+	t.Run("SearchMatchesTitlesAndMessagesOfOwner", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		other := createOwner(t, users, "bob")
+
+		titled := mustCreateTitledConversation(t, repo, owner, "Kubernetes notes", time.Now())
+		withMessage := mustCreateConversation(t, repo, owner, time.Now())
+		message := newMessage(withMessage.ConversationID, time.Now())
+		message.Content = "How do I scale a Kubernetes cluster?"
+		if err := repo.CreateMessage(ctx, message, owner); err != nil {
+			t.Fatal(err)
+		}
+		mustCreateConversation(t, repo, owner, time.Now())
+		foreign := mustCreateConversation(t, repo, other, time.Now())
+		if err := repo.CreateMessage(ctx, &models.Message{
+			MessageID: uuid.New(), ConversationID: foreign.ConversationID, Sender: "user",
+			Content: "kubernetes", Timestamp: time.Now(),
+		}, other); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := repo.SearchConversations(ctx, owner, repository.SearchQuery{Text: "kubernetes", Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("got %d results, want 2", len(results))
+		}
+		byID := make(map[uuid.UUID]repository.SearchResult)
+		for _, result := range results {
+			byID[result.Conversation.ConversationID] = result
+		}
+
+		if got, ok := byID[titled.ConversationID]; !ok || !strings.Contains(got.TitleSnippet, repository.HighlightStart) || got.MatchCount != 0 {
+			t.Fatalf("title match: got %+v", got)
+		}
+		got, ok := byID[withMessage.ConversationID]
+		if !ok || got.TitleSnippet != "" || got.MatchCount != 1 || len(got.Matches) != 1 {
+			t.Fatalf("message match: got %+v", got)
+		}
+		if got.Matches[0].MessageID != message.MessageID || !strings.Contains(got.Matches[0].Snippet, repository.HighlightStart) {
+			t.Fatalf("message match: got %+v", got.Matches[0])
+		}
+	})
+
+	t.Run("SearchSnippetsAreEscaped", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+
+		conversation := mustCreateTitledConversation(t, repo, owner, "<b>Kubernetes</b>", time.Now())
+		message := newMessage(conversation.ConversationID, time.Now())
+		message.Content = `<img src=x onerror="alert(1)"> kubernetes & co`
+		if err := repo.CreateMessage(ctx, message, owner); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := repo.SearchConversations(ctx, owner, repository.SearchQuery{Text: "kubernetes", Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || len(results[0].Matches) != 1 {
+			t.Fatalf("got %+v, want one conversation with one match", results)
+		}
+		if got, want := results[0].TitleSnippet, "&lt;b&gt;"+repository.HighlightStart+"Kubernetes"+repository.HighlightStop+"&lt;/b&gt;"; got != want {
+			t.Fatalf("got title snippet %q, want %q", got, want)
+		}
+		snippet := results[0].Matches[0].Snippet
+		if strings.Contains(snippet, "<img") || !strings.Contains(snippet, "&lt;img") ||
+			!strings.Contains(snippet, repository.HighlightStart+"kubernetes"+repository.HighlightStop+" &amp; co") {
+			t.Fatalf("snippet %q is not escaped", snippet)
+		}
+	})
+
+	t.Run("SearchSkipsRedactedMessages", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+		message := newMessage(conversation.ConversationID, time.Now())
+		message.Content = "kubernetes secrets"
+		if err := repo.CreateMessage(ctx, message, owner); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.RedactMessage(ctx, conversation.ConversationID, message.MessageID, "kubernetes redacted", owner, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := repo.SearchConversations(ctx, owner, repository.SearchQuery{Text: "kubernetes", Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 0 {
+			t.Fatalf("got %d results, want none for a redacted message", len(results))
+		}
+	})
+
+	t.Run("SearchFilters", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		start := time.Now().Add(-time.Hour)
+
+		// One conversation per sender, each with a single matching message
+		bySender := make(map[string]*models.Conversation)
+		for i, sender := range []string{"user", "ai"} {
+			conversation := mustCreateTitledConversation(t, repo, owner, "Database migration", start)
+			message := newMessage(conversation.ConversationID, start.Add(time.Duration(i)*time.Minute))
+			message.Sender = sender
+			message.Content = "the database migration failed"
+			message.Metadata = metadataFor(sender)
+			if err := repo.CreateMessage(ctx, message, owner); err != nil {
+				t.Fatal(err)
+			}
+			bySender[sender] = conversation
+		}
+		if err := repo.UpdateConversationPin(ctx, bySender["ai"].ConversationID, owner, true); err != nil {
+			t.Fatal(err)
+		}
+
+		from, to := start.Add(30*time.Second), start.Add(2*time.Minute)
+		pinned := false
+		tests := map[string]struct {
+			query repository.SearchQuery
+			want  *models.Conversation
+		}{
+			"Sender": {repository.SearchQuery{Sender: "ai"}, bySender["ai"]},
+			"From":   {repository.SearchQuery{From: &from}, bySender["ai"]},
+			"To":     {repository.SearchQuery{To: &from}, bySender["user"]},
+			"Range":  {repository.SearchQuery{From: &from, To: &to}, bySender["ai"]},
+			"Pinned": {repository.SearchQuery{Pinned: &pinned}, bySender["user"]},
+			"MetadataKey": {repository.SearchQuery{Metadata: []repository.MetadataFilter{
+				{Key: "prompt_tokens"},
+			}}, bySender["ai"]},
+			"MetadataValue": {repository.SearchQuery{Metadata: []repository.MetadataFilter{
+				{Key: "model", Value: json.RawMessage(`"gpt-4o"`)},
+				{Key: "prompt_tokens", Value: json.RawMessage(`12`)},
+			}}, bySender["ai"]},
+			"MetadataBool": {repository.SearchQuery{Metadata: []repository.MetadataFilter{
+				{Key: "draft", Value: json.RawMessage(`true`)},
+			}}, bySender["user"]},
+		}
+		for name, tt := range tests {
+			tt.query.Text, tt.query.Limit = "migration", 10
+			results, err := repo.SearchConversations(ctx, owner, tt.query)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if len(results) != 1 || results[0].Conversation.ConversationID != tt.want.ConversationID {
+				t.Fatalf("%s: got %d results, want only the %q conversation", name, len(results), tt.want.Title)
+			}
+			if (tt.query.Sender != "" || len(tt.query.Metadata) > 0) && results[0].TitleSnippet != "" {
+				t.Fatalf("%s: titles must not match when messages are filtered", name)
+			}
+		}
+	})
+
+	t.Run("HistoryPagesFollowDivergedBranch", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+
+		// root - fork - old - oldLeaf, then a newer branch from fork whose
+		// messages are all newer than the old ones. Only root and fork have
+		// metadata.
+		start := time.Now().Add(-time.Hour)
+		metadata := `{"pinned":true}`
+		root := newMessage(conversation.ConversationID, start)
+		root.Metadata = &metadata
+		fork := newMessage(conversation.ConversationID, start.Add(time.Minute))
+		fork.ParentMessageID = &root.MessageID
+		fork.Metadata = &metadata
+		for _, message := range []*models.Message{root, fork} {
+			if err := repo.CreateMessage(ctx, message, owner); err != nil {
+				t.Fatal(err)
+			}
+		}
+		old := mustAppendMessage(t, repo, owner, conversation, fork, start.Add(2*time.Minute))
+		mustAppendMessage(t, repo, owner, conversation, old, start.Add(3*time.Minute))
+		branch := []*models.Message{root, fork}
+		for i := 4; i < 8; i++ {
+			branch = append(branch, mustAppendMessage(t, repo, owner, conversation, branch[len(branch)-1], start.Add(time.Duration(i)*time.Minute)))
+		}
+		leaf := &branch[len(branch)-1].MessageID
+
+		// Newest first, two at a time, back past the fork
+		var got []repository.BranchMessage
+		page := repository.MessagePage{Limit: 2}
+		for {
+			messages, more, err := history(repo, conversation.ConversationID, owner, leaf, page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(messages, got...)
+			if !more {
+				break
+			}
+			before := repository.MessageKeyOf(&messages[0].Message)
+			page.Before = &before
+		}
+		assertBranch(t, got, branch...)
+
+		// Oldest first, from a cursor before the fork
+		after := repository.MessageKeyOf(root)
+		got = nil
+		page = repository.MessagePage{Limit: 2, After: &after}
+		for {
+			messages, more, err := history(repo, conversation.ConversationID, owner, leaf, page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, messages...)
+			if !more {
+				break
+			}
+			after := repository.MessageKeyOf(&messages[len(messages)-1].Message)
+			page.After = &after
+		}
+		assertBranch(t, got, branch[1:]...)
+
+		// A cursor from the other branch only pages through this one
+		before := repository.MessageKeyOf(old)
+		got, more, err := history(repo, conversation.ConversationID, owner, leaf, repository.MessagePage{Limit: 1, Before: &before})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !more {
+			t.Fatal("page before the cursor does not report the root")
+		}
+		assertBranch(t, got, fork)
+
+		// Filtered pages count only matching messages towards the limit
+		filtered := repository.MessagePage{Limit: 1, Metadata: []repository.MetadataFilter{{Key: "pinned"}}}
+		got, more, err = history(repo, conversation.ConversationID, owner, leaf, filtered)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !more {
+			t.Fatal("filtered page does not report the root")
+		}
+		assertBranch(t, got, fork)
+	})
+
+	t.Run("HistoryFiltersByMetadata", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+
+		start := time.Now().Add(-time.Hour)
+		var branch []*models.Message
+		var parent *models.Message
+		for i, sender := range []string{"user", "ai", "user", "ai"} {
+			message := newMessage(conversation.ConversationID, start.Add(time.Duration(i)*time.Minute))
+			message.Sender = sender
+			message.Metadata = metadataFor(sender)
+			if parent != nil {
+				message.ParentMessageID = &parent.MessageID
+			}
+			if err := repo.CreateMessage(ctx, message, owner); err != nil {
+				t.Fatal(err)
+			}
+			branch = append(branch, message)
+			parent = message
+		}
+		leaf := &parent.MessageID
+
+		tests := map[string]struct {
+			filters []repository.MetadataFilter
+			want    []*models.Message
+		}{
+			"Key":          {[]repository.MetadataFilter{{Key: "model"}}, []*models.Message{branch[1], branch[3]}},
+			"Value":        {[]repository.MetadataFilter{{Key: "draft", Value: json.RawMessage(`true`)}}, []*models.Message{branch[0], branch[2]}},
+			"WrongType":    {[]repository.MetadataFilter{{Key: "prompt_tokens", Value: json.RawMessage(`"12"`)}}, nil},
+			"MissingKey":   {[]repository.MetadataFilter{{Key: "latency_ms"}}, nil},
+			"AllMustMatch": {[]repository.MetadataFilter{{Key: "model"}, {Key: "draft"}}, nil},
+		}
+		for name, tt := range tests {
+			got, _, err := history(repo, conversation.ConversationID, owner, leaf, repository.MessagePage{Limit: 10, Metadata: tt.filters})
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("%s: got %d messages, want %d", name, len(got), len(tt.want))
+			}
+			assertBranch(t, got, tt.want...)
+		}
+
+		// A page without matches still tells an owned conversation from a missing one
+		if _, _, err := history(repo, uuid.New(), owner, leaf, repository.MessagePage{Limit: 10, Metadata: tests["MissingKey"].filters}); !errors.Is(err, repository.ErrConversationNotFound) {
+			t.Fatalf("missing conversation: got %v, want ErrConversationNotFound", err)
+		}
+	})
+
+	t.Run("ToolMessagesAreStored", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+
+		calls, callID, name := `[{"id":"call_1","name":"search","arguments":{"q":"go"}}]`, "call_1", "search"
+		request := newMessage(conversation.ConversationID, time.Now().Add(-time.Minute))
+		request.Sender, request.ToolCalls = "ai", &calls
+		result := newMessage(conversation.ConversationID, time.Now())
+		result.Sender, result.ParentMessageID = "tool", &request.MessageID
+		result.ToolCallID, result.ToolName = &callID, &name
+		for _, message := range []*models.Message{request, result} {
+			if err := repo.CreateMessage(ctx, message, owner); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got, err := repo.GetMessage(ctx, conversation.ConversationID, request.MessageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stored, want interface{}
+		if got.ToolCalls == nil || json.Unmarshal([]byte(*got.ToolCalls), &stored) != nil || json.Unmarshal([]byte(calls), &want) != nil || !reflect.DeepEqual(stored, want) {
+			t.Fatalf("got tool calls %v, want %s", got.ToolCalls, calls)
+		}
+		if got, err = repo.GetMessage(ctx, conversation.ConversationID, result.MessageID); err != nil {
+			t.Fatal(err)
+		}
+		if got.Sender != "tool" || got.ToolCallID == nil || *got.ToolCallID != callID || got.ToolName == nil || *got.ToolName != name {
+			t.Fatalf("got tool result from %q answering %v of %v", got.Sender, got.ToolCallID, got.ToolName)
+		}
+	})
+
+	t.Run("PartsAreStoredUntilRedaction", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+
+		parts := `[{"type":"text","text":"see"},{"type":"code","text":"x := 1","language":"go"}]`
+		message := newMessage(conversation.ConversationID, time.Now())
+		message.Parts = &parts
+		if err := repo.CreateMessage(ctx, message, owner); err != nil {
+			t.Fatal(err)
+		}
+		got, err := repo.GetMessage(ctx, conversation.ConversationID, message.MessageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stored, want interface{}
+		if got.Parts == nil || json.Unmarshal([]byte(*got.Parts), &stored) != nil || json.Unmarshal([]byte(parts), &want) != nil || !reflect.DeepEqual(stored, want) {
+			t.Fatalf("got parts %v, want %s", got.Parts, parts)
+		}
+
+		if err := repo.RedactMessage(ctx, conversation.ConversationID, message.MessageID, "gone", owner, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if got, err = repo.GetMessage(ctx, conversation.ConversationID, message.MessageID); err != nil {
+			t.Fatal(err)
+		}
+		if got.Parts != nil {
+			t.Fatalf("got parts %s after redaction, want none", *got.Parts)
+		}
+	})
+
+	t.Run("UpdateMessageContent", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+		message := mustAppendMessage(t, repo, owner, conversation, nil, time.Now())
+
+		editedAt := time.Now().Add(time.Minute)
+		if err := repo.UpdateMessageContent(ctx, conversation.ConversationID, message.MessageID, "edited", editedAt); err != nil {
+			t.Fatal(err)
+		}
+		got, err := repo.GetMessage(ctx, conversation.ConversationID, message.MessageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Content != "edited" || got.EditedAt == nil || !got.EditedAt.Equal(editedAt) {
+			t.Fatalf("got content %q edited at %v, want %q at %v", got.Content, got.EditedAt, "edited", editedAt)
+		}
+
+		if err := repo.UpdateMessageContent(ctx, uuid.New(), message.MessageID, "x", editedAt); !errors.Is(err, repository.ErrMessageNotFound) {
+			t.Fatalf("message of another conversation: got %v, want ErrMessageNotFound", err)
+		}
+	})
+
+	t.Run("RedactMessage", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+		message := mustAppendMessage(t, repo, owner, conversation, nil, time.Now())
+
+		redactedAt := time.Now().Add(time.Minute)
+		if err := repo.RedactMessage(ctx, conversation.ConversationID, message.MessageID, "gone", owner, redactedAt); err != nil {
+			t.Fatal(err)
+		}
+		got, err := repo.GetMessage(ctx, conversation.ConversationID, message.MessageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Content != "gone" || got.RedactedAt == nil || !got.RedactedAt.Equal(redactedAt) || got.RedactedBy == nil || *got.RedactedBy != owner {
+			t.Fatalf("got content %q redacted at %v by %v, want %q at %v by %d", got.Content, got.RedactedAt, got.RedactedBy, "gone", redactedAt, owner)
+		}
+
+		if err := repo.RedactMessage(ctx, uuid.New(), message.MessageID, "x", owner, redactedAt); !errors.Is(err, repository.ErrMessageNotFound) {
+			t.Fatalf("message of another conversation: got %v, want ErrMessageNotFound", err)
+		}
+
+		// Tool calls and the call a result answers are redacted too
+		calls, callID, name := `[{"id":"call_1","name":"search","arguments":{"q":"secret"}}]`, "call_1", "search"
+		request := newMessage(conversation.ConversationID, time.Now())
+		request.Sender, request.ToolCalls = "ai", &calls
+		result := newMessage(conversation.ConversationID, time.Now())
+		result.Sender, result.ParentMessageID = "tool", &request.MessageID
+		result.ToolCallID, result.ToolName = &callID, &name
+		for _, message := range []*models.Message{request, result} {
+			if err := repo.CreateMessage(ctx, message, owner); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.RedactMessage(ctx, conversation.ConversationID, message.MessageID, "gone", owner, redactedAt); err != nil {
+				t.Fatal(err)
+			}
+			got, err := repo.GetMessage(ctx, conversation.ConversationID, message.MessageID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ToolCalls != nil || got.ToolCallID != nil || got.ToolName != nil {
+				t.Fatalf("got tool calls %v answering %v of %v after redaction, want none", got.ToolCalls, got.ToolCallID, got.ToolName)
+			}
+		}
+	})
+
+	t.Run("AppendMessageContent", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+		complete := mustAppendMessage(t, repo, owner, conversation, nil, time.Now())
+		if got, err := repo.GetMessage(ctx, conversation.ConversationID, complete.MessageID); err != nil || got.Status != "complete" {
+			t.Fatalf("got status %q (%v) for a message stored without one, want complete", got.Status, err)
+		}
+
+		message := newMessage(conversation.ConversationID, time.Now())
+		message.Sender, message.Content, message.Status = "ai", "", "pending"
+		if err := repo.CreateMessage(ctx, message, owner); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.AppendMessageContent(ctx, conversation.ConversationID, message.MessageID, "Hel", "streaming", nil, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		metadata := `{"completion_tokens":2}`
+		if err := repo.AppendMessageContent(ctx, conversation.ConversationID, message.MessageID, "lo", "complete", &metadata, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		got, err := repo.GetMessage(ctx, conversation.ConversationID, message.MessageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Content != "Hello" || got.Status != "complete" || got.Metadata == nil || *got.Metadata != metadata {
+			t.Fatalf("got content %q status %q metadata %v, want %q complete %s", got.Content, got.Status, got.Metadata, "Hello", metadata)
+		}
+
+		// Only open messages receive content
+		if err := repo.AppendMessageContent(ctx, conversation.ConversationID, message.MessageID, "!", "streaming", nil, time.Now()); !errors.Is(err, repository.ErrMessageNotFound) {
+			t.Fatalf("complete message: got %v, want ErrMessageNotFound", err)
+		}
+		if err := repo.AppendMessageContent(ctx, uuid.New(), message.MessageID, "!", "streaming", nil, time.Now()); !errors.Is(err, repository.ErrMessageNotFound) {
+			t.Fatalf("message of another conversation: got %v, want ErrMessageNotFound", err)
+		}
+		redacted := newMessage(conversation.ConversationID, time.Now())
+		redacted.Sender, redacted.Status = "ai", "streaming"
+		if err := repo.CreateMessage(ctx, redacted, owner); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.RedactMessage(ctx, conversation.ConversationID, redacted.MessageID, "gone", owner, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.AppendMessageContent(ctx, conversation.ConversationID, redacted.MessageID, "!", "streaming", nil, time.Now()); !errors.Is(err, repository.ErrMessageNotFound) {
+			t.Fatalf("redacted message: got %v, want ErrMessageNotFound", err)
+		}
+	})
+
+	t.Run("ExpireStreamingMessages", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+		other := mustCreateConversation(t, repo, owner, time.Now())
+
+		now := time.Now()
+		open := func(conversation *models.Conversation, status string, streamedAt time.Time) *models.Message {
+			message := newMessage(conversation.ConversationID, now)
+			message.Sender, message.Status, message.StreamedAt = "ai", status, &streamedAt
+			if err := repo.CreateMessage(ctx, message, owner); err != nil {
+				t.Fatal(err)
+			}
+			return message
+		}
+		stalePending := open(conversation, "pending", now.Add(-time.Hour))
+		staleStreaming := open(conversation, "streaming", now.Add(-time.Hour))
+		fresh := open(conversation, "streaming", now)
+		elsewhere := open(other, "streaming", now.Add(-time.Hour))
+		complete := mustAppendMessage(t, repo, owner, conversation, nil, now.Add(-time.Hour))
+
+		// Saving content keeps a stream fresh
+		if err := repo.AppendMessageContent(ctx, conversation.ConversationID, staleStreaming.MessageID, "x", "streaming", nil, now); err != nil {
+			t.Fatal(err)
+		}
+
+		expired, err := repo.ExpireStreamingMessages(ctx, conversation.ConversationID, now.Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expired != 1 {
+			t.Fatalf("expired %d messages, want 1", expired)
+		}
+		for _, want := range []struct {
+			message *models.Message
+			status  string
+		}{{stalePending, "failed"}, {staleStreaming, "streaming"}, {fresh, "streaming"}, {elsewhere, "streaming"}, {complete, "complete"}} {
+			got, err := repo.GetMessage(ctx, want.message.ConversationID, want.message.MessageID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != want.status {
+				t.Fatalf("got status %q, want %q", got.Status, want.status)
+			}
+		}
+	})
+
+	t.Run("DeleteMessageSubtree", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+
+		start := time.Now().Add(-time.Hour)
+		root := mustAppendMessage(t, repo, owner, conversation, nil, start)
+		first := mustAppendMessage(t, repo, owner, conversation, root, start.Add(time.Minute))
+		alternative := mustAppendMessage(t, repo, owner, conversation, root, start.Add(2*time.Minute))
+		mustAppendMessage(t, repo, owner, conversation, first, start.Add(3*time.Minute))
+
+		if _, err := repo.DeleteMessageSubtree(ctx, uuid.New(), first.MessageID); !errors.Is(err, repository.ErrMessageNotFound) {
+			t.Fatalf("message of another conversation: got %v, want ErrMessageNotFound", err)
+		}
+		deleted, err := repo.DeleteMessageSubtree(ctx, conversation.ConversationID, first.MessageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted != 2 {
+			t.Fatalf("got %d messages deleted, want 2", deleted)
+		}
+
+		latest, err := repo.GetLatestMessage(ctx, conversation.ConversationID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if latest.MessageID != alternative.MessageID {
+			t.Fatalf("got latest message %v, want %v", latest.MessageID, alternative.MessageID)
+		}
+		branch, _, err := history(repo, conversation.ConversationID, owner, &alternative.MessageID, repository.MessagePage{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBranch(t, branch, root, alternative)
+
+		if deleted, err = repo.DeleteMessageSubtree(ctx, conversation.ConversationID, root.MessageID); err != nil {
+			t.Fatal(err)
+		}
+		if deleted != 2 {
+			t.Fatalf("got %d messages deleted, want 2", deleted)
+		}
+		if _, err := repo.GetLatestMessage(ctx, conversation.ConversationID); !errors.Is(err, repository.ErrMessageNotFound) {
+			t.Fatalf("GetLatestMessage of empty conversation: got %v, want ErrMessageNotFound", err)
+		}
+	})
+
+	t.Run("UpdateConversationPin", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+
+		if err := repo.UpdateConversationPin(ctx, conversation.ConversationID, owner, true); err != nil {
+			t.Fatal(err)
+		}
+		got, err := repo.GetConversationByID(ctx, conversation.ConversationID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.IsPinned {
+			t.Fatal("expected conversation to be pinned")
+		}
+	})
+
+	t.Run("Attachments", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		other := createOwner(t, users, "bob")
+		first := mustCreateConversation(t, repo, owner, time.Now())
+		second := mustCreateConversation(t, repo, owner, time.Now())
+
+		now := time.Now()
+		older := mustCreateAttachment(t, repo, owner, first, 100, now.Add(-time.Minute))
+		newer := mustCreateAttachment(t, repo, owner, first, 20, now)
+		mustCreateAttachment(t, repo, owner, second, 3, now)
+
+		stray := newAttachment(owner, first, 1, now)
+		if err := repo.CreateAttachment(ctx, stray, other); !errors.Is(err, repository.ErrConversationNotFound) {
+			t.Fatalf("attachment to another user's conversation: got %v, want ErrConversationNotFound", err)
+		}
+
+		got, err := repo.GetAttachment(ctx, older.AttachmentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ConversationID != first.ConversationID || got.Filename != older.Filename || got.SizeBytes != 100 || got.StorageKey != older.StorageKey {
+			t.Fatalf("got %+v, want %+v", got, older)
+		}
+		if _, err := repo.GetAttachment(ctx, uuid.New()); !errors.Is(err, repository.ErrAttachmentNotFound) {
+			t.Fatalf("unknown attachment: got %v, want ErrAttachmentNotFound", err)
+		}
+
+		list, err := repo.ListAttachments(ctx, first.ConversationID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 2 || list[0].AttachmentID != older.AttachmentID || list[1].AttachmentID != newer.AttachmentID {
+			t.Fatalf("got %d attachments, want the 2 of the conversation oldest first", len(list))
+		}
+		assertAttachmentUsage(t, repo, owner, 123)
+		assertAttachmentUsage(t, repo, other, 0)
+		if list, err = repo.ListUserAttachments(ctx, owner); err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 3 || list[0].AttachmentID != older.AttachmentID {
+			t.Fatalf("got %d attachments of the user, want 3 oldest first", len(list))
+		}
+		if list, err = repo.ListUserAttachments(ctx, other); err != nil || len(list) != 0 {
+			t.Fatalf("got %d attachments (%v) of a user without any, want none", len(list), err)
+		}
+
+		// Attachments are deleted with their conversation
+		if err := repo.DeleteConversation(ctx, first.ConversationID, owner); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.GetAttachment(ctx, older.AttachmentID); !errors.Is(err, repository.ErrAttachmentNotFound) {
+			t.Fatalf("attachment of deleted conversation: got %v, want ErrAttachmentNotFound", err)
+		}
+		assertAttachmentUsage(t, repo, owner, 3)
+	})
+
+	t.Run("DeleteRemovesMessages", func(t *testing.T) {
+		users, repo := newRepos(t)
+		owner := createOwner(t, users, "alice")
+		conversation := mustCreateConversation(t, repo, owner, time.Now())
+		message := mustAppendMessage(t, repo, owner, conversation, nil, time.Now())
+
+		if err := repo.DeleteConversation(ctx, conversation.ConversationID, owner); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.GetConversationByID(ctx, conversation.ConversationID); !errors.Is(err, repository.ErrConversationNotFound) {
+			t.Fatalf("got %v, want ErrConversationNotFound", err)
+		}
+		if _, _, err := history(repo, conversation.ConversationID, owner, &message.MessageID, allMessages); !errors.Is(err, repository.ErrConversationNotFound) {
+			t.Fatalf("got %v, want ErrConversationNotFound", err)
+		}
+
+		// Recreating the conversation must not bring old messages back
+		if err := repo.CreateConversation(ctx, conversation); err != nil {
+			t.Fatal(err)
+		}
+		messages, _, err := history(repo, conversation.ConversationID, owner, &message.MessageID, allMessages)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != 0 {
+			t.Fatalf("got %d messages after delete, want 0", len(messages))
+		}
+	})
+}
+
+func newUser(name string) *models.User {
+	return &models.User{
+		Email:     name + "@example.com",
+		Username:  name,
+		Password:  "hash",
+		FirstName: name,
+		LastName:  "Test",
+		Role:      "user",
+	}
+}
+
+func mustCreateUser(t *testing.T, repo repository.UserRepository, user *models.User) {
+	t.Helper()
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("creating user %s: %v", user.Username, err)
+	}
+}
+
+func createOwner(t *testing.T, repo repository.UserRepository, name string) uint {
+	t.Helper()
+	user := newUser(name)
+	mustCreateUser(t, repo, user)
+	return user.UserID
+}
+
+func mustCreateConversation(t *testing.T, repo repository.ConversationRepository, userID uint, updatedAt time.Time) *models.Conversation {
+	t.Helper()
+	return mustCreateTitledConversation(t, repo, userID, "Test conversation", updatedAt)
+}
+
+func mustCreateTitledConversation(t *testing.T, repo repository.ConversationRepository, userID uint, title string, updatedAt time.Time) *models.Conversation {
+	t.Helper()
+	conversation := &models.Conversation{
+		ConversationID: uuid.New(),
+		UserID:         userID,
+		Title:          title,
+		CreatedAt:      updatedAt,
+		UpdatedAt:      updatedAt,
+	}
+	if err := repo.CreateConversation(ctx, conversation); err != nil {
+		t.Fatalf("creating conversation: %v", err)
+	}
+	return conversation
+}
+
+func newMessage(conversationID uuid.UUID, timestamp time.Time) *models.Message {
+	return &models.Message{
+		MessageID:      uuid.New(),
+		ConversationID: conversationID,
+		Sender:         "user",
+		Content:        "hello",
+		Timestamp:      timestamp,
+	}
+}
+
+// metadataFor returns the metadata stored by the metadata filter tests
+func metadataFor(sender string) *string {
+	metadata := `{"draft":true}`
+	if sender == "ai" {
+		metadata = `{"model":"gpt-4o","prompt_tokens":12}`
+	}
+	return &metadata
+}
+
+// mustAppendMessage stores a reply to parent, or a root message if parent is nil
+func mustAppendMessage(t *testing.T, repo repository.ConversationRepository, userID uint, conversation *models.Conversation, parent *models.Message, timestamp time.Time) *models.Message {
+	t.Helper()
+	message := newMessage(conversation.ConversationID, timestamp)
+	if parent != nil {
+		message.ParentMessageID = &parent.MessageID
+	}
+	if err := repo.CreateMessage(ctx, message, userID); err != nil {
+		t.Fatalf("creating message: %v", err)
+	}
+	return message
+}
+
+func newAttachment(userID uint, conversation *models.Conversation, size int64, createdAt time.Time) *models.Attachment {
+	id := uuid.New()
+	return &models.Attachment{
+		AttachmentID:   id,
+		ConversationID: conversation.ConversationID,
+		UserID:         userID,
+		Filename:       "notes.txt",
+		ContentType:    "text/plain",
+		SizeBytes:      size,
+		StorageKey:     "attachments/" + id.String(),
+		CreatedAt:      createdAt,
+	}
+}
+
+func mustCreateAttachment(t *testing.T, repo repository.ConversationRepository, userID uint, conversation *models.Conversation, size int64, createdAt time.Time) *models.Attachment {
+	t.Helper()
+	attachment := newAttachment(userID, conversation, size, createdAt)
+	if err := repo.CreateAttachment(ctx, attachment, userID); err != nil {
+		t.Fatalf("creating attachment: %v", err)
+	}
+	return attachment
+}
+
+// assertAttachmentUsage checks the usage of a user, read with and without
+// locking the user
+func assertAttachmentUsage(t *testing.T, repo repository.ConversationRepository, userID uint, want int64) {
+	t.Helper()
+	for name, getUsage := range map[string]func(context.Context, uint) (int64, error){
+		"GetAttachmentUsage":          repo.GetAttachmentUsage,
+		"GetAttachmentUsageForUpdate": repo.GetAttachmentUsageForUpdate,
+	} {
+		usage, err := getUsage(ctx, userID)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if usage != want {
+			t.Fatalf("%s: got attachment usage %d for user %d, want %d", name, usage, userID, want)
+		}
+	}
+}
+
+// history returns the messages of a page of history and whether more follow
+func history(repo repository.ConversationRepository, conversationID uuid.UUID, userID uint, leafID *uuid.UUID, page repository.MessagePage) ([]repository.BranchMessage, bool, error) {
+	branch, err := repo.GetConversationHistory(ctx, conversationID, userID, leafID, page)
+	if err != nil {
+		return nil, false, err
+	}
+	return branch.Messages, branch.More, nil
+}
+
+// assertBranch checks that a branch holds exactly the given messages, in order
+func assertBranch(t *testing.T, branch []repository.BranchMessage, want ...*models.Message) {
+	t.Helper()
+	if len(branch) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(branch), len(want))
+	}
+	for i := range want {
+		if branch[i].MessageID != want[i].MessageID {
+			t.Fatalf("message %d: got %v, want %v", i, branch[i].MessageID, want[i].MessageID)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"user_service/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RevokedTokenRepository handles database operations for the access token denylist
+type RevokedTokenRepository struct {
+	db *gorm.DB
+}
+
+// NewRevokedTokenRepository creates a new revoked token repository
+func NewRevokedTokenRepository(db *gorm.DB) *RevokedTokenRepository {
+	return &RevokedTokenRepository{db: db}
+}
+
+// Create adds a token ID to the denylist, ignoring duplicates
+func (r *RevokedTokenRepository) Create(ctx context.Context, token *models.RevokedToken) error {
+	return Conn(ctx, r.db).Clauses(clause.OnConflict{DoNothing: true}).Create(token).Error
+}
+
+// IsRevoked checks if a token ID is on the denylist
+func (r *RevokedTokenRepository) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	var count int64
+	if err := Conn(ctx, r.db).Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// DeleteExpired removes denylist entries for tokens that have already expired
+func (r *RevokedTokenRepository) DeleteExpired(ctx context.Context) error {
+	return Conn(ctx, r.db).Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{}).Error
+}
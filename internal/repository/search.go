@@ -0,0 +1,280 @@
+package repository
+
+import (
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"user_service/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Search results mark matched words in snippets with these strings. The rest
+// of a snippet is HTML-escaped.
+const (
+	HighlightStart = "<mark>"
+	HighlightStop  = "</mark>"
+)
+
+// Databases mark matched words with these private use characters, which
+// markHighlights replaces with the highlight tags once the text is escaped
+const (
+	highlightStartSentinel = "\uE000"
+	highlightStopSentinel  = "\uE001"
+)
+
+// highlightReplacer replaces the sentinels of escaped text with the highlight
+// tags
+var highlightReplacer = strings.NewReplacer(highlightStartSentinel, HighlightStart, highlightStopSentinel, HighlightStop)
+
+// markHighlights turns text highlighted with the sentinels into an escaped
+// snippet
+func markHighlights(s string) string {
+	return highlightReplacer.Replace(html.EscapeString(s))
+}
+
+// SearchMatchesPerConversation is the number of matching messages returned
+// with each search result
+const SearchMatchesPerConversation = 3
+
+// SearchQuery selects the conversations of a user whose title or messages
+// match Text
+type SearchQuery struct {
+	Text string
+	// Sender, From, To and Metadata restrict the messages searched. With any
+	// of them set conversations only match through their messages, not their
+	// title.
+	Sender string
+	// From is inclusive and To exclusive
+	From, To *time.Time
+	// Metadata restricts the messages searched to those matching every filter
+	Metadata []MetadataFilter
+	// Pinned, if set, only searches pinned or only unpinned conversations
+	Pinned *bool
+	Limit  int
+}
+
+// filtersMessages reports whether the query restricts the messages searched
+func (q SearchQuery) filtersMessages() bool {
+	return q.Sender != "" || q.From != nil || q.To != nil || len(q.Metadata) > 0
+}
+
+// SearchMatch is a message matching a search
+type SearchMatch struct {
+	MessageID uuid.UUID
+	Sender    string
+	Timestamp time.Time
+	Snippet   string
+}
+
+// SearchResult is a conversation matching a search
+type SearchResult struct {
+	Conversation models.Conversation
+	// Rank orders the results of a single search, best first. Values are not
+	// comparable between searches or storage backends.
+	Rank float64
+	// TitleSnippet is the highlighted title, or empty if the title did not match
+	TitleSnippet string
+	// MatchCount is the number of matching messages, of which the best
+	// SearchMatchesPerConversation are in Matches
+	MatchCount int
+	Matches    []SearchMatch
+}
+
+// searchTerms splits search text into the words matched by backends without
+// full-text search. Quotes are dropped; every other character is literal.
+func searchTerms(text string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, field := range strings.Fields(strings.ToLower(text)) {
+		term := strings.Trim(field, `"`)
+		if term != "" && !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// textMatcher finds search terms in text, case-insensitively
+type textMatcher struct {
+	terms []*regexp.Regexp
+	any   *regexp.Regexp
+}
+
+func newTextMatcher(terms []string) *textMatcher {
+	// Longer terms first, so that the alternation prefers them
+	sorted := append([]string(nil), terms...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+	m := &textMatcher{}
+	quoted := make([]string, 0, len(sorted))
+	for _, term := range sorted {
+		q := regexp.QuoteMeta(term)
+		m.terms = append(m.terms, regexp.MustCompile("(?i)"+q))
+		quoted = append(quoted, q)
+	}
+	m.any = regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+	return m
+}
+
+// score returns the number of term occurrences in s, or zero unless every
+// term occurs
+func (m *textMatcher) score(s string) int {
+	total := 0
+	for _, term := range m.terms {
+		n := len(term.FindAllStringIndex(s, -1))
+		if n == 0 {
+			return 0
+		}
+		total += n
+	}
+	return total
+}
+
+// highlight escapes s and marks every term occurrence in it
+func (m *textMatcher) highlight(s string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range m.any.FindAllStringIndex(s, -1) {
+		b.WriteString(html.EscapeString(s[last:loc[0]]))
+		b.WriteString(HighlightStart + html.EscapeString(s[loc[0]:loc[1]]) + HighlightStop)
+		last = loc[1]
+	}
+	b.WriteString(html.EscapeString(s[last:]))
+	return b.String()
+}
+
+// Context kept around the first match of a message snippet, in bytes
+const (
+	snippetBefore = 60
+	snippetAfter  = 140
+)
+
+// snippet returns the highlighted part of s around its first match, cut at
+// word boundaries
+func (m *textMatcher) snippet(s string) string {
+	loc := m.any.FindStringIndex(s)
+	if loc == nil {
+		return ""
+	}
+	start, end := loc[0]-snippetBefore, loc[1]+snippetAfter
+	if start <= 0 {
+		start = 0
+	} else {
+		for start < loc[0] && !utf8.RuneStart(s[start]) {
+			start++
+		}
+		if i := strings.IndexAny(s[start:loc[0]], " \n\t"); i >= 0 {
+			start += i + 1
+		}
+	}
+	if end >= len(s) {
+		end = len(s)
+	} else {
+		for end > loc[1] && !utf8.RuneStart(s[end]) {
+			end--
+		}
+		if i := strings.LastIndexAny(s[loc[1]:end], " \n\t"); i >= 0 {
+			end = loc[1] + i
+		}
+	}
+	return m.highlight(s[start:end])
+}
+
+// matchesMessage reports whether a message passes the sender, date and
+// metadata filters
+func (q SearchQuery) matchesMessage(message *models.Message) bool {
+	return (q.Sender == "" || message.Sender == q.Sender) &&
+		(q.From == nil || !message.Timestamp.Before(*q.From)) &&
+		(q.To == nil || message.Timestamp.Before(*q.To)) &&
+		matchesMetadata(message.Metadata, q.Metadata)
+}
+
+// rankSearch evaluates a search in Go, for backends without full-text
+// search. conversations must all belong to the searching user and messages
+// must belong to those conversations; both may include non-matching rows.
+// Redacted messages never match.
+func rankSearch(query SearchQuery, conversations []models.Conversation, messages []models.Message) []SearchResult {
+	terms := searchTerms(query.Text)
+	if len(terms) == 0 {
+		return nil
+	}
+	matcher := newTextMatcher(terms)
+
+	type scoredMessage struct {
+		message *models.Message
+		score   int
+	}
+	matchesByConversation := make(map[uuid.UUID][]scoredMessage)
+	for i := range messages {
+		message := &messages[i]
+		if message.RedactedAt != nil || !query.matchesMessage(message) {
+			continue
+		}
+		if score := matcher.score(message.Content); score > 0 {
+			matchesByConversation[message.ConversationID] = append(matchesByConversation[message.ConversationID], scoredMessage{message, score})
+		}
+	}
+
+	var results []SearchResult
+	for _, conversation := range conversations {
+		if query.Pinned != nil && conversation.IsPinned != *query.Pinned {
+			continue
+		}
+		result := SearchResult{Conversation: conversation}
+		if !query.filtersMessages() {
+			if score := matcher.score(conversation.Title); score > 0 {
+				result.Rank = float64(score)
+				result.TitleSnippet = matcher.highlight(conversation.Title)
+			}
+		}
+
+		matches := matchesByConversation[conversation.ConversationID]
+		if result.TitleSnippet == "" && len(matches) == 0 {
+			continue
+		}
+		sort.Slice(matches, func(i, j int) bool {
+			if matches[i].score != matches[j].score {
+				return matches[i].score > matches[j].score
+			}
+			return matches[i].message.Timestamp.After(matches[j].message.Timestamp)
+		})
+		if len(matches) > 0 {
+			result.Rank += float64(matches[0].score)
+		}
+		result.MatchCount = len(matches)
+		for i := 0; i < len(matches) && i < SearchMatchesPerConversation; i++ {
+			message := matches[i].message
+			result.Matches = append(result.Matches, SearchMatch{
+				MessageID: message.MessageID,
+				Sender:    message.Sender,
+				Timestamp: message.Timestamp,
+				Snippet:   matcher.snippet(message.Content),
+			})
+		}
+		results = append(results, result)
+	}
+
+	// Ties are broken by list order
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return ConversationKeyOf(&results[i].Conversation).compare(ConversationKeyOf(&results[j].Conversation)) < 0
+	})
+	if len(results) > query.Limit {
+		results = results[:query.Limit]
+	}
+	return results
+}
+
+// likePattern returns a LIKE pattern matching term anywhere in a lowercased
+// column, escaped with a backslash
+func likePattern(term string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(term)
+	return "%" + escaped + "%"
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Transactor runs a unit of work atomically. Repository calls made with the
+// context passed to fn take part in the transaction; calling
+// WithinTransaction again with that context joins it instead of starting a
+// new one.
+type Transactor interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type txKey struct{}
+
+// GormTransactor implements Transactor with database transactions
+type GormTransactor struct {
+	db *gorm.DB
+}
+
+func NewTransactor(db *gorm.DB) *GormTransactor {
+	return &GormTransactor{db: db}
+}
+
+// WithinTransaction runs fn in a transaction that is committed if fn returns
+// nil and rolled back otherwise
+func (t *GormTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return fn(ctx)
+	}
+	return t.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
+
+// Conn returns the transaction carried by ctx, or db bound to ctx when there
+// is none. Everything that queries db with a context that may come from
+// WithinTransaction must use it: GORM repositories use it for every query, as
+// do other stores on the same database. Otherwise the query waits for a
+// second connection, which never comes on SQLite, where the pool has one.
+func Conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx
+	}
+	return db.WithContext(ctx)
+}
+
+// MemoryTransactor implements Transactor for the in-memory repositories. Each
+// of their writes is atomic on its own, but a failing unit of work does not
+// roll back the writes it already made.
+type MemoryTransactor struct{}
+
+func NewMemoryTransactor() MemoryTransactor {
+	return MemoryTransactor{}
+}
+
+// WithinTransaction runs fn directly
+func (MemoryTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
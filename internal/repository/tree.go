@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"user_service/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// BranchMessage is a message on a branch of a conversation together with its
+// siblings, the messages with the same parent
+type BranchMessage struct {
+	models.Message
+	// SiblingIDs lists the message and its siblings, oldest first
+	SiblingIDs []uuid.UUID
+}
+
+// BranchPage is a page of the messages on a branch of a conversation
+type BranchPage struct {
+	Messages []BranchMessage
+	// LeafID is the last message of the branch, or nil if it has none
+	LeafID *uuid.UUID
+	// More reports whether more messages follow the page in the direction it
+	// was read
+	More bool
+}
+
+// withSiblings pairs messages with their siblings. candidates must include
+// every message sharing a parent with one of messages, oldest first, and may
+// include others.
+func withSiblings(messages []models.Message, candidates []models.Message) []BranchMessage {
+	// Root messages are grouped under uuid.Nil
+	children := make(map[uuid.UUID][]uuid.UUID)
+	for _, candidate := range candidates {
+		parent := parentKey(&candidate)
+		children[parent] = append(children[parent], candidate.MessageID)
+	}
+
+	branch := make([]BranchMessage, 0, len(messages))
+	for _, message := range messages {
+		branch = append(branch, BranchMessage{
+			Message:    message,
+			SiblingIDs: children[parentKey(&message)],
+		})
+	}
+	return branch
+}
+
+// parentKey returns the parent of a message, or uuid.Nil for a root message
+func parentKey(message *models.Message) uuid.UUID {
+	if message.ParentMessageID == nil {
+		return uuid.Nil
+	}
+	return *message.ParentMessageID
+}
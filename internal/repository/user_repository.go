@@ -1,33 +1,77 @@
 package repository
 
 import (
+	"context"
 	"errors"
+	"time"
+	"user_service/internal/apperrors"
 	"user_service/internal/models"
 
 	"gorm.io/gorm"
 )
 
-// UserRepository handles database operations for users
-type UserRepository struct {
+// ErrUserNotFound is returned when a user does not exist
+var ErrUserNotFound = apperrors.NotFound("user_not_found", "user not found")
+
+// errUserExists is returned when an email or username is already taken
+var errUserExists = apperrors.Conflict("user_exists", "email or username already exists")
+
+// UserRepository stores users. Implementations must be safe for concurrent
+// use, keep emails and usernames unique and return ErrUserNotFound from the
+// lookups when no user matches.
+type UserRepository interface {
+	// Create stores a new user and assigns its ID
+	Create(ctx context.Context, user *models.User) error
+	GetByID(ctx context.Context, id uint) (*models.User, error)
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	GetByUsername(ctx context.Context, username string) (*models.User, error)
+	// GetAll returns every user ordered by ID
+	GetAll(ctx context.Context) ([]models.User, error)
+	// Update saves the fields of an existing user, except the password, token
+	// version and last MFA step. Those only change through their own methods,
+	// so that saving a copy loaded before such a change does not undo it.
+	Update(ctx context.Context, user *models.User) error
+	// Delete removes a user; deleting a missing user is not an error
+	Delete(ctx context.Context, id uint) error
+	EmailExists(ctx context.Context, email string) bool
+	UsernameExists(ctx context.Context, username string) bool
+	// IncrementTokenVersion bumps a user's token version, invalidating all
+	// previously issued access tokens
+	IncrementTokenVersion(ctx context.Context, id uint) error
+	// UpdatePassword sets a user's password hash and records the change time
+	UpdatePassword(ctx context.Context, id uint, hashedPassword string) error
+	// AdvanceMFAStep records a TOTP time step as used if it is newer than the
+	// last accepted one. It reports whether the step was accepted.
+	AdvanceMFAStep(ctx context.Context, id uint, step int64) (bool, error)
+}
+
+// GormUserRepository implements UserRepository on top of GORM
+type GormUserRepository struct {
 	db *gorm.DB
 }
 
 // NewUserRepository creates a new user repository
-func NewUserRepository(db *gorm.DB) *UserRepository {
-	return &UserRepository{db: db}
+func NewUserRepository(db *gorm.DB) *GormUserRepository {
+	return &GormUserRepository{db: db}
 }
 
 // Create creates a new user
-func (r *UserRepository) Create(user *models.User) error {
-	return r.db.Create(user).Error
+func (r *GormUserRepository) Create(ctx context.Context, user *models.User) error {
+	if err := Conn(ctx, r.db).Create(user).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return errUserExists
+		}
+		return err
+	}
+	return nil
 }
 
 // GetByID retrieves a user by ID
-func (r *UserRepository) GetByID(id uint) (*models.User, error) {
+func (r *GormUserRepository) GetByID(ctx context.Context, id uint) (*models.User, error) {
 	var user models.User
-	if err := r.db.First(&user, id).Error; err != nil {
+	if err := Conn(ctx, r.db).First(&user, id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
@@ -35,11 +79,11 @@ func (r *UserRepository) GetByID(id uint) (*models.User, error) {
 }
 
 // GetByEmail retrieves a user by email
-func (r *UserRepository) GetByEmail(email string) (*models.User, error) {
+func (r *GormUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
 	var user models.User
-	if err := r.db.Where("email = ?", email).First(&user).Error; err != nil {
+	if err := Conn(ctx, r.db).Where("email = ?", email).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
@@ -47,11 +91,11 @@ func (r *UserRepository) GetByEmail(email string) (*models.User, error) {
 }
 
 // GetByUsername retrieves a user by username
-func (r *UserRepository) GetByUsername(username string) (*models.User, error) {
+func (r *GormUserRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
 	var user models.User
-	if err := r.db.Where("username = ?", username).First(&user).Error; err != nil {
+	if err := Conn(ctx, r.db).Where("username = ?", username).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
@@ -59,34 +103,70 @@ func (r *UserRepository) GetByUsername(username string) (*models.User, error) {
 }
 
 // GetAll retrieves all users
-func (r *UserRepository) GetAll() ([]models.User, error) {
+func (r *GormUserRepository) GetAll(ctx context.Context) ([]models.User, error) {
 	var users []models.User
-	if err := r.db.Find(&users).Error; err != nil {
+	if err := Conn(ctx, r.db).Order("user_id ASC").Find(&users).Error; err != nil {
 		return nil, err
 	}
 	return users, nil
 }
 
-// Update updates a user
-func (r *UserRepository) Update(user *models.User) error {
-	return r.db.Save(user).Error
+// userOwnColumns are the columns Update leaves to the methods that change them
+var userOwnColumns = []string{"password", "password_changed_at", "mfa_last_step", "token_version", "created_at"}
+
+// Update updates a user, except the columns in userOwnColumns
+func (r *GormUserRepository) Update(ctx context.Context, user *models.User) error {
+	if err := Conn(ctx, r.db).Model(user).Select("*").Omit(userOwnColumns...).Updates(user).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return errUserExists
+		}
+		return err
+	}
+	return nil
 }
 
 // Delete deletes a user by ID
-func (r *UserRepository) Delete(id uint) error {
-	return r.db.Delete(&models.User{}, id).Error
+func (r *GormUserRepository) Delete(ctx context.Context, id uint) error {
+	return Conn(ctx, r.db).Delete(&models.User{}, id).Error
 }
 
 // EmailExists checks if an email already exists
-func (r *UserRepository) EmailExists(email string) bool {
+func (r *GormUserRepository) EmailExists(ctx context.Context, email string) bool {
 	var count int64
-	r.db.Model(&models.User{}).Where("email = ?", email).Count(&count)
+	Conn(ctx, r.db).Model(&models.User{}).Where("email = ?", email).Count(&count)
 	return count > 0
 }
 
 // UsernameExists checks if a username already exists
-func (r *UserRepository) UsernameExists(username string) bool {
+func (r *GormUserRepository) UsernameExists(ctx context.Context, username string) bool {
 	var count int64
-	r.db.Model(&models.User{}).Where("username = ?", username).Count(&count)
+	Conn(ctx, r.db).Model(&models.User{}).Where("username = ?", username).Count(&count)
 	return count > 0
 }
+
+// IncrementTokenVersion bumps a user's token version, invalidating all
+// previously issued access tokens
+func (r *GormUserRepository) IncrementTokenVersion(ctx context.Context, id uint) error {
+	return Conn(ctx, r.db).Model(&models.User{}).Where("user_id = ?", id).
+		UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error
+}
+
+// UpdatePassword sets a user's password hash and records the change time
+func (r *GormUserRepository) UpdatePassword(ctx context.Context, id uint, hashedPassword string) error {
+	return Conn(ctx, r.db).Model(&models.User{}).Where("user_id = ?", id).Updates(map[string]interface{}{
+		"password":            hashedPassword,
+		"password_changed_at": time.Now(),
+	}).Error
+}
+
+// AdvanceMFAStep records a TOTP time step as used if it is newer than the
+// last accepted one. It reports whether the step was accepted.
+func (r *GormUserRepository) AdvanceMFAStep(ctx context.Context, id uint, step int64) (bool, error) {
+	result := Conn(ctx, r.db).Model(&models.User{}).
+		Where("user_id = ? AND mfa_last_step < ?", id, step).
+		UpdateColumn("mfa_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
@@ -1,26 +1,65 @@
 package user
 
 import (
+	"user_service/config"
+	"user_service/internal/constants"
 	conversationHandlers "user_service/internal/handlers/conversation"
 	userHandlers "user_service/internal/handlers/user"
+	"user_service/internal/mailer"
 	"user_service/internal/middleware"
 	"user_service/internal/repository"
 	conversationServices "user_service/internal/service/conversation"
 	userServices "user_service/internal/service/user"
+	"user_service/internal/storage"
+	"user_service/internal/throttle"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-func SetupRoutes(router *gin.Engine, db *gorm.DB) {
+// LongLivedRoutes are the routes whose responses stay open, and so must not be
+// cut off by the request timeout
+var LongLivedRoutes = []string{
+	"/user_service/v1/conversations/:conversation_id/stream",
+}
+
+// SetupRoutes wires services and handlers and registers all routes. Users and
+// conversations are read through the given repositories; token state is
+// stored in db, and the content of attachments in blobs.
+func SetupRoutes(
+	router *gin.Engine,
+	db *gorm.DB,
+	cfg *config.Config,
+	mail mailer.Mailer,
+	throttleStore throttle.Store,
+	blobs storage.BlobStore,
+	userRepo repository.UserRepository,
+	conversationRepo repository.ConversationRepository,
+) {
 	// Initialize repositories
-	userRepo := repository.NewUserRepository(db)
-	conversationRepo := repository.NewConversationRepository(db)
+	refreshTokenRepo := repository.NewRefreshTokenRepository(db)
+	revokedTokenRepo := repository.NewRevokedTokenRepository(db)
+	passwordResetRepo := repository.NewPasswordResetTokenRepository(db)
+	mfaRecoveryRepo := repository.NewMFARecoveryCodeRepository(db)
+	transactor := repository.NewTransactor(db)
+
+	// Initialize throttling
+	rateLimiter := throttle.NewRateLimiter(throttleStore)
+	lockout := throttle.NewLockout(throttleStore, cfg.LoginMaxFailures, cfg.LoginLockoutBase, cfg.LoginLockoutMax)
 
 	// Initialize services
-	userService := userServices.NewUserService(userRepo)
-	authService := userServices.NewAuthService(userRepo)
-	conversationService := conversationServices.NewConversationService(conversationRepo)
+	emailVerifier := userServices.NewEmailVerifier(userRepo, mail, cfg.AppBaseURL, cfg.EmailVerification)
+	userService := userServices.NewUserService(userRepo, conversationRepo, blobs, emailVerifier)
+	authService := userServices.NewAuthService(userRepo, refreshTokenRepo, revokedTokenRepo, passwordResetRepo, emailVerifier, mfaRecoveryRepo, transactor, lockout, mail, cfg.AppBaseURL)
+	conversationService := conversationServices.NewConversationService(conversationRepo, transactor, blobs, conversationServices.AttachmentSettings{
+		MaxBytes:     cfg.MaxAttachmentBytes,
+		QuotaBytes:   cfg.AttachmentQuotaBytes,
+		URLTTL:       cfg.AttachmentURLTTL,
+		DownloadPath: "/user_service/v1/attachments",
+	}, conversationServices.StreamSettings{
+		FlushInterval: cfg.StreamFlushInterval,
+		IdleTimeout:   cfg.StreamIdleTimeout,
+	})
 
 	// Initialize handlers
 	userHandler := userHandlers.NewUserHandler(userService)
@@ -38,33 +77,55 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB) {
 	// API v1 routes
 	v1 := router.Group("/user_service/v1")
 	{
-		// Authentication routes (public)
+		// Authentication routes
 		auth := v1.Group("/auth")
 		{
-			auth.POST("/register", authHandler.Register)
-			auth.POST("/login", authHandler.Login)
+			auth.POST("/register", middleware.RateLimit(rateLimiter, "register", cfg.RegisterRateLimit, cfg.RegisterRateWindow), authHandler.Register)
+			auth.POST("/login", middleware.RateLimit(rateLimiter, "login", cfg.LoginRateLimit, cfg.LoginRateWindow), authHandler.Login)
+			auth.POST("/refresh", authHandler.Refresh)
+			auth.POST("/password/forgot", middleware.RateLimit(rateLimiter, "password_forgot", cfg.PasswordResetRateLimit, cfg.PasswordResetRateWindow), authHandler.ForgotPassword)
+			auth.POST("/password/reset", middleware.RateLimit(rateLimiter, "password_reset", cfg.PasswordResetRateLimit, cfg.PasswordResetRateWindow), authHandler.ResetPassword)
+			auth.POST("/verify-email", authHandler.VerifyEmail)
+			auth.POST("/verify-email/resend", authHandler.ResendVerification)
+			auth.POST("/mfa/verify", middleware.RateLimit(rateLimiter, "mfa_verify", cfg.LoginRateLimit, cfg.LoginRateWindow), authHandler.VerifyMFA)
+
+			// Session revocation (protected)
+			auth.POST("/logout", middleware.Auth(authService), authHandler.Logout)
+			auth.POST("/logout-all", middleware.Auth(authService), authHandler.LogoutAll)
+
+			// Two-factor enrollment (protected)
+			auth.POST("/mfa/enroll", middleware.Auth(authService), authHandler.EnrollMFA)
+			auth.POST("/mfa/confirm", middleware.Auth(authService), authHandler.ConfirmMFA)
+			auth.POST("/mfa/disable", middleware.Auth(authService), authHandler.DisableMFA)
 		}
 
+		// Conversation access may additionally require a verified email
+		requireVerifiedEmail := middleware.RequireVerifiedEmail(emailVerifier.RequiredForConversations())
+
 		// User routes (protected)
 		users := v1.Group("/users")
 		users.Use(middleware.Auth(authService)) // Apply JWT middleware
 		{
-			users.POST("/", userHandler.CreateUser)
-			users.GET("/:id", userHandler.GetUser)
-			users.PUT("/:id", userHandler.UpdateUser)
-			users.DELETE("/:id", userHandler.DeleteUser)
+			users.POST("/", middleware.RequireRole(constants.UserRoleAdmin), userHandler.CreateUser)
+			users.GET("/:id", middleware.RequireSelfOrAdmin("id"), userHandler.GetUser)
+			users.PUT("/:id", middleware.RequireSelfOrAdmin("id"), userHandler.UpdateUser)
+			users.PUT("/:id/password", middleware.RequireSelfOrAdmin("id"), authHandler.ChangePassword)
+			users.DELETE("/:id", middleware.RequireSelfOrAdmin("id"), userHandler.DeleteUser)
 
 			// Get all conversations for a user
-			users.GET("/:id/conversations", conversationHandler.GetAllConversations)
+			users.GET("/:id/conversations", requireVerifiedEmail, conversationHandler.GetAllConversations)
 		}
 
 		// Conversation routes (protected)
 		conversations := v1.Group("/conversations")
-		conversations.Use(middleware.Auth(authService)) // Apply JWT middleware
+		conversations.Use(middleware.Auth(authService), requireVerifiedEmail) // Apply JWT middleware
 		{
 			// Create new conversation
 			conversations.POST("/", conversationHandler.CreateConversation)
 
+			// Search the caller's conversations
+			conversations.GET("/search", conversationHandler.SearchConversations)
+
 			// Delete conversation
 			conversations.DELETE("/:conversation_id", conversationHandler.DeleteConversation)
 
@@ -74,8 +135,33 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB) {
 			// Add message to conversation
 			conversations.POST("/:conversation_id/messages", conversationHandler.AddMessage)
 
+			// Edit a message, adding a new version of it
+			conversations.PUT("/:conversation_id/messages/:message_id", conversationHandler.EditMessage)
+
+			// Regenerate an AI message, adding a new version of it
+			conversations.POST("/:conversation_id/messages/:message_id/regenerate", conversationHandler.RegenerateMessage)
+
+			// Append content to an AI message opened for streaming
+			conversations.POST("/:conversation_id/messages/:message_id/chunks", conversationHandler.AppendChunk)
+
+			// Delete a message and its replies, or redact it
+			conversations.DELETE("/:conversation_id/messages/:message_id", conversationHandler.DeleteMessage)
+
 			// Get conversation history
 			conversations.GET("/:conversation_id/history", conversationHandler.GetConversationHistory)
+
+			// Switch the active branch of a conversation
+			conversations.PUT("/:conversation_id/active-branch", conversationHandler.SwitchBranch)
+
+			// Upload and list the attachments of a conversation
+			conversations.POST("/:conversation_id/attachments", conversationHandler.UploadAttachment)
+			conversations.GET("/:conversation_id/attachments", conversationHandler.ListAttachments)
+
+			// Subscribe to new messages and streamed chunks (Server-Sent Events)
+			conversations.GET("/:conversation_id/stream", conversationHandler.StreamConversation)
 		}
+
+		// Attachment content, authenticated by the signature of its URL
+		v1.GET("/attachments/:attachment_id/content", conversationHandler.DownloadAttachment)
 	}
 }
@@ -0,0 +1,330 @@
+package conversation
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+	"user_service/internal/apperrors"
+	dto "user_service/internal/dto/conversation"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+	"user_service/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// AttachmentSettings configures attachment uploads and their download URLs
+type AttachmentSettings struct {
+	// MaxBytes is the largest accepted upload
+	MaxBytes int64
+	// QuotaBytes is the total size of the attachments a user may keep
+	QuotaBytes int64
+	// URLTTL is how long a download URL stays valid
+	URLTTL time.Duration
+	// DownloadPath is the path download URLs start with; the attachment ID
+	// and /content follow it
+	DownloadPath string
+}
+
+// Attachment errors
+var (
+	ErrUnsupportedAttachment  = apperrors.UnsupportedMediaType("unsupported_attachment_type", "attachments must be PNG, JPEG, GIF or WebP images, PDF documents, or plain text, Markdown, CSV or JSON files")
+	ErrAttachmentQuotaReached = apperrors.Forbidden("attachment_quota_exceeded", "the upload would exceed your attachment storage quota")
+	ErrInvalidDownloadURL     = apperrors.Forbidden("invalid_download_url", "download URL is invalid or has expired")
+)
+
+// allowedAttachmentTypes are the accepted content types of attachments. The
+// type of an upload is detected from its content; the declared type only
+// refines text, which detection cannot tell apart.
+var allowedAttachmentTypes = map[string]bool{
+	"image/png":        true,
+	"image/jpeg":       true,
+	"image/gif":        true,
+	"image/webp":       true,
+	"application/pdf":  true,
+	"text/plain":       true,
+	"text/markdown":    true,
+	"text/csv":         true,
+	"application/json": true,
+}
+
+// textAttachmentTypes are the declared types a plain text upload may keep
+var textAttachmentTypes = map[string]bool{
+	"text/markdown":    true,
+	"text/csv":         true,
+	"application/json": true,
+}
+
+// sniffLen is the number of bytes http.DetectContentType considers
+const sniffLen = 512
+
+// MaxUploadBytes returns the largest request body accepted for an upload:
+// the largest attachment with room for the multipart framing around it
+func (s *ConversationService) MaxUploadBytes() int64 {
+	return s.attachments.MaxBytes + 64<<10
+}
+
+// UploadAttachment stores a file uploaded to a conversation owned by the
+// user. The type of the file is detected from its content and must be one of
+// the accepted types, and it must fit both the size limit and the user's
+// quota. The content is stored before the attachment is recorded, and removed
+// again if recording it fails.
+func (s *ConversationService) UploadAttachment(ctx context.Context, conversationID uuid.UUID, userID uint, req *dto.UploadAttachmentRequest) (*dto.AttachmentItem, error) {
+	header := req.File
+	if header.Size > s.attachments.MaxBytes {
+		return nil, errAttachmentTooLarge(s.attachments.MaxBytes)
+	}
+	// Fail early, before the content is stored; ownership and quota are
+	// checked again when the attachment is recorded
+	conversation, err := s.conversationRepo.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if conversation.UserID != userID {
+		return nil, repository.ErrConversationNotFound
+	}
+	usage, err := s.conversationRepo.GetAttachmentUsage(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkQuota(usage, header.Size); err != nil {
+		return nil, err
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	head = head[:n]
+	contentType := detectAttachmentType(head, header.Filename, header.Header.Get("Content-Type"))
+	if !allowedAttachmentTypes[contentType] {
+		return nil, ErrUnsupportedAttachment
+	}
+
+	attachmentID := uuid.New()
+	attachment := &models.Attachment{
+		AttachmentID:   attachmentID,
+		ConversationID: conversationID,
+		UserID:         userID,
+		Filename:       attachmentFilename(header.Filename),
+		ContentType:    contentType,
+		SizeBytes:      header.Size,
+		StorageKey:     fmt.Sprintf("attachments/%d/%s", userID, attachmentID),
+		CreatedAt:      time.Now(),
+	}
+	if err := s.blobs.Put(ctx, attachment.StorageKey, io.MultiReader(bytes.NewReader(head), file)); err != nil {
+		return nil, err
+	}
+
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		// Lock the user, so that their other uploads wait for this one to be
+		// counted, then the conversation, so that it cannot be deleted, and
+		// its blobs with it, while the attachment is recorded. Deleting the
+		// user takes the locks in the same order.
+		usage, err := s.conversationRepo.GetAttachmentUsageForUpdate(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if _, err := s.conversationRepo.GetConversationForUpdate(ctx, conversationID, userID); err != nil {
+			return err
+		}
+		if err := s.checkQuota(usage, attachment.SizeBytes); err != nil {
+			return err
+		}
+		return s.conversationRepo.CreateAttachment(ctx, attachment, userID)
+	})
+	if err != nil {
+		s.deleteBlobs(ctx, []models.Attachment{*attachment})
+		return nil, err
+	}
+
+	return s.attachmentItem(attachment)
+}
+
+// ListAttachments lists the attachments of a conversation owned by the user,
+// with fresh download URLs. Conversations owned by someone else are reported
+// as not found.
+func (s *ConversationService) ListAttachments(ctx context.Context, conversationID uuid.UUID, userID uint) (*dto.ListAttachmentsResponse, error) {
+	conversation, err := s.conversationRepo.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if conversation.UserID != userID {
+		return nil, repository.ErrConversationNotFound
+	}
+
+	attachments, err := s.conversationRepo.ListAttachments(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := s.conversationRepo.GetAttachmentUsage(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &dto.ListAttachmentsResponse{
+		Attachments: make([]dto.AttachmentItem, 0, len(attachments)),
+		UsageBytes:  usage,
+		QuotaBytes:  s.attachments.QuotaBytes,
+	}
+	for i := range attachments {
+		item, err := s.attachmentItem(&attachments[i])
+		if err != nil {
+			return nil, err
+		}
+		response.Attachments = append(response.Attachments, *item)
+	}
+	return response, nil
+}
+
+// OpenAttachment returns an attachment and its content for a signed download
+// URL. The caller must close the content.
+func (s *ConversationService) OpenAttachment(ctx context.Context, attachmentID uuid.UUID, query *dto.DownloadAttachmentQuery) (*models.Attachment, io.ReadCloser, error) {
+	key, err := attachmentURLKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	expected := downloadSignature(key, attachmentID, query.Expires)
+	if !hmac.Equal([]byte(expected), []byte(query.Signature)) || time.Now().Unix() > query.Expires {
+		return nil, nil, ErrInvalidDownloadURL
+	}
+
+	attachment, err := s.conversationRepo.GetAttachment(ctx, attachmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := s.blobs.Open(ctx, attachment.StorageKey)
+	if errors.Is(err, storage.ErrBlobNotFound) {
+		return nil, nil, repository.ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return attachment, content, nil
+}
+
+// checkQuota fails if adding size bytes to a user's usage would take them
+// over their quota
+func (s *ConversationService) checkQuota(usage, size int64) error {
+	if usage+size > s.attachments.QuotaBytes {
+		return ErrAttachmentQuotaReached
+	}
+	return nil
+}
+
+// deleteBlobs removes the content of deleted attachments. It outlives the
+// request, and failures are only logged: a blob left behind is unreachable
+// storage, not an error for the caller.
+func (s *ConversationService) deleteBlobs(ctx context.Context, attachments []models.Attachment) {
+	ctx = context.WithoutCancel(ctx)
+	for _, attachment := range attachments {
+		if err := s.blobs.Delete(ctx, attachment.StorageKey); err != nil {
+			log.Printf("failed to delete blob of attachment %s: %v", attachment.AttachmentID, err)
+		}
+	}
+}
+
+// attachmentItem describes an attachment with a freshly signed download URL
+func (s *ConversationService) attachmentItem(attachment *models.Attachment) (*dto.AttachmentItem, error) {
+	key, err := attachmentURLKey()
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(s.attachments.URLTTL).Truncate(time.Second)
+	expires := expiresAt.Unix()
+	query := url.Values{
+		"expires":   {strconv.FormatInt(expires, 10)},
+		"signature": {downloadSignature(key, attachment.AttachmentID, expires)},
+	}
+	return &dto.AttachmentItem{
+		AttachmentID:         attachment.AttachmentID,
+		ConversationID:       attachment.ConversationID,
+		Filename:             attachment.Filename,
+		ContentType:          attachment.ContentType,
+		SizeBytes:            attachment.SizeBytes,
+		CreatedAt:            attachment.CreatedAt,
+		DownloadURL:          fmt.Sprintf("%s/%s/content?%s", s.attachments.DownloadPath, attachment.AttachmentID, query.Encode()),
+		DownloadURLExpiresAt: expiresAt,
+	}, nil
+}
+
+// attachmentURLKey derives the key download URLs are signed with from
+// JWT_SECRET, as the auth service does for its own tokens
+func attachmentURLKey() ([]byte, error) {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return nil, errors.New("JWT_SECRET not configured")
+	}
+	return []byte(jwtSecret + ":attachment_download"), nil
+}
+
+// downloadSignature signs the download URL of an attachment valid until
+// expires, in Unix seconds
+func downloadSignature(key []byte, attachmentID uuid.UUID, expires int64) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s:%d", attachmentID, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func errAttachmentTooLarge(maxBytes int64) error {
+	return apperrors.TooLarge("attachment_too_large", fmt.Sprintf("attachments must be at most %d bytes", maxBytes))
+}
+
+// detectAttachmentType returns the content type of an upload from its first
+// bytes. Text keeps the type declared for it, or implied by the file name, if
+// that is one of the accepted text types.
+func detectAttachmentType(head []byte, filename, declared string) string {
+	detected, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if detected != "text/plain" {
+		return detected
+	}
+	for _, candidate := range []string{declared, mime.TypeByExtension(filepath.Ext(filename))} {
+		if mediaType, _, err := mime.ParseMediaType(candidate); err == nil && textAttachmentTypes[mediaType] {
+			return mediaType
+		}
+	}
+	return detected
+}
+
+// attachmentFilename cleans the file name given for an upload: only the base
+// name is kept, without control characters, and at most 255 bytes of it
+func attachmentFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+	for len(name) > 255 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	return name
+}
@@ -1,27 +1,80 @@
 package conversation
 
 import (
+	"context"
 	"errors"
+	"slices"
+	"strings"
+	"sync"
 	"time"
+	"user_service/internal/apperrors"
+	"user_service/internal/constants"
 	dto "user_service/internal/dto/conversation"
 	"user_service/internal/models"
 	"user_service/internal/repository"
+	"user_service/internal/storage"
 
 	"github.com/google/uuid"
 )
 
+// Domain errors returned by the conversation service
+var (
+	ErrInvalidConversationID = apperrors.BadRequest("invalid_conversation_id", "invalid conversation ID")
+	ErrInvalidMessageID      = apperrors.BadRequest("invalid_message_id", "invalid message ID")
+	ErrInPlaceEditNotAllowed = apperrors.BadRequest("in_place_edit_not_allowed", "only system messages can be edited in place")
+	ErrMessageRedacted       = apperrors.Conflict("message_redacted", "message has been redacted")
+	ErrRegenerateNotAllowed  = apperrors.BadRequest("regenerate_not_allowed", "only AI messages can be regenerated")
+	ErrToolCallNotFound      = apperrors.BadRequest("tool_call_not_found", "tool_call_id does not match an unanswered tool call of the AI message replied to")
+)
+
+// Modes of DeleteMessage
+const (
+	DeleteModeDelete = "delete"
+	DeleteModeRedact = "redact"
+)
+
+// RedactedContent replaces the content of redacted messages
+const RedactedContent = "[message redacted]"
+
+// DefaultSearchResults is the number of search results returned when the
+// query gives no limit
+const DefaultSearchResults = 20
+
+var (
+	errEmptySearch = apperrors.Validation("request validation failed",
+		apperrors.FieldError{Field: "q", Message: "is required"})
+	errSearchRange = apperrors.Validation("request validation failed",
+		apperrors.FieldError{Field: "to", Message: "must be after from"})
+)
+
 type ConversationService struct {
-	conversationRepo *repository.ConversationRepository
+	conversationRepo repository.ConversationRepository
+	transactor       repository.Transactor
+	blobs            storage.BlobStore
+	attachments      AttachmentSettings
+	streams          StreamSettings
+	broker           *broker
+
+	// buffers holds the messages being streamed through this process, by
+	// message ID
+	streamsMu sync.Mutex
+	buffers   map[uuid.UUID]*streamBuffer
 }
 
-func NewConversationService(conversationRepo *repository.ConversationRepository) *ConversationService {
+func NewConversationService(conversationRepo repository.ConversationRepository, transactor repository.Transactor, blobs storage.BlobStore, attachments AttachmentSettings, streams StreamSettings) *ConversationService {
 	return &ConversationService{
 		conversationRepo: conversationRepo,
+		transactor:       transactor,
+		blobs:            blobs,
+		attachments:      attachments,
+		streams:          streams,
+		broker:           newBroker(),
+		buffers:          make(map[uuid.UUID]*streamBuffer),
 	}
 }
 
 // CreateConversation creates a new conversation
-func (s *ConversationService) CreateConversation(userID uint, req *dto.CreateConversationRequest) (*dto.CreateConversationResponse, error) {
+func (s *ConversationService) CreateConversation(ctx context.Context, userID uint, req *dto.CreateConversationRequest) (*dto.CreateConversationResponse, error) {
 	// Generate UUID
 	conversationID := uuid.New()
 
@@ -37,7 +90,7 @@ func (s *ConversationService) CreateConversation(userID uint, req *dto.CreateCon
 	}
 
 	// Save conversation
-	err := s.conversationRepo.CreateConversation(conversation)
+	err := s.conversationRepo.CreateConversation(ctx, conversation)
 	if err != nil {
 		return nil, err
 	}
@@ -52,18 +105,41 @@ func (s *ConversationService) CreateConversation(userID uint, req *dto.CreateCon
 	}, nil
 }
 
-// AddMessage adds a new message to a conversation
-func (s *ConversationService) AddMessage(req *dto.AddMessageRequest) error {
+// AddMessage adds a new message to a conversation owned by the user and
+// makes it the end of the active branch. AI messages may be opened for
+// streaming instead of being given whole. Conversations owned by someone else
+// are reported as not found.
+func (s *ConversationService) AddMessage(ctx context.Context, userID uint, req *dto.AddMessageRequest) (*dto.AddMessageResponse, error) {
 	// Parse conversation ID
 	conversationID, err := uuid.Parse(req.ConversationID)
 	if err != nil {
-		return errors.New("invalid conversation ID")
+		return nil, ErrInvalidConversationID
 	}
-
-	// Verify conversation exists
-	_, err = s.conversationRepo.GetConversationByID(conversationID)
-	if err != nil {
-		return errors.New("conversation not found")
+	if err := validateMessageMetadata(req.Sender, req.Metadata, req.Model); err != nil {
+		return nil, err
+	}
+	if err := validateToolFields(req.Sender, req.ToolCalls, req.ToolCallID); err != nil {
+		return nil, err
+	}
+	if err := validateParts(req.Message, req.Parts); err != nil {
+		return nil, err
+	}
+	if err := validateStatus(req); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	status := constants.MessageStatusComplete
+	var streamedAt *time.Time
+	if req.Status == constants.MessageStatusPending {
+		status, streamedAt = req.Status, &now
+	}
+	var parentID *uuid.UUID
+	if req.ParentMessageID != "" {
+		id, err := uuid.Parse(req.ParentMessageID)
+		if err != nil {
+			return nil, ErrInvalidMessageID
+		}
+		parentID = &id
 	}
 
 	// Create message
@@ -72,57 +148,467 @@ func (s *ConversationService) AddMessage(req *dto.AddMessageRequest) error {
 		ConversationID: conversationID,
 		Sender:         req.Sender,
 		Content:        req.Message,
-		Timestamp:      time.Now(),
+		Timestamp:      now,
+		ToolCalls:      encodeToolCalls(req.ToolCalls),
+		Status:         status,
+		StreamedAt:     streamedAt,
+	}
+
+	// Save the message, move the active branch to it and bump the
+	// conversation together, so that the conversation list order always
+	// reflects the latest message
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		// Lock the conversation, so that concurrent messages reply to each
+		// other instead of branching off the same leaf
+		conversation, err := s.conversationRepo.GetConversationForUpdate(ctx, conversationID, userID)
+		if err != nil {
+			return err
+		}
+		message.ParentMessageID = conversation.ActiveLeafID
+		if parentID != nil {
+			if _, err := s.conversationRepo.GetMessage(ctx, conversationID, *parentID); err != nil {
+				return err
+			}
+			message.ParentMessageID = parentID
+		}
+		if len(req.Parts) > 0 {
+			if message.Content, message.Parts, err = s.resolveParts(ctx, conversationID, req.Parts); err != nil {
+				return err
+			}
+		}
+		if message.Sender == constants.SenderRoleTool {
+			name, err := s.findToolCall(ctx, conversationID, message.ParentMessageID, req.ToolCallID)
+			if err != nil {
+				return err
+			}
+			message.ToolCallID, message.ToolName = &req.ToolCallID, &name
+		}
+		if message.Sender == constants.SenderRoleAI {
+			message.Metadata = encodeMetadata(aiMetadata(req.Metadata, req.Model, conversation))
+		} else {
+			message.Metadata = encodeMetadata(req.Metadata)
+		}
+
+		// Save message, verifying ownership in the same statement
+		if err := s.conversationRepo.CreateMessage(ctx, message, userID); err != nil {
+			return err
+		}
+		if err := s.conversationRepo.SetActiveLeaf(ctx, conversationID, &message.MessageID); err != nil {
+			return err
+		}
+
+		// Update conversation timestamp
+		return s.conversationRepo.UpdateConversationTimestamp(ctx, conversationID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.publishMessage(message)
+
+	return &dto.AddMessageResponse{
+		Message:         "Message added successfully",
+		MessageID:       message.MessageID,
+		ParentMessageID: message.ParentMessageID,
+		Status:          message.Status,
+	}, nil
+}
+
+// EditMessage changes a message of a conversation owned by the user. The
+// original is kept: the new content is added as a sibling of the message,
+// which becomes the end of the active branch, and keeps the tool call fields
+// of the original. System messages can be edited in place instead.
+func (s *ConversationService) EditMessage(ctx context.Context, conversationID, messageID uuid.UUID, userID uint, req *dto.EditMessageRequest) (*dto.EditMessageResponse, error) {
+	if err := validateParts(req.Message, req.Parts); err != nil {
+		return nil, err
+	}
+	if req.InPlace && len(req.Parts) > 0 {
+		return nil, errPartsInPlace
 	}
 
-	// Save message
-	err = s.conversationRepo.CreateMessage(message)
+	response := &dto.EditMessageResponse{EditedInPlace: req.InPlace}
+	var added *models.Message
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if _, err := s.conversationRepo.GetConversationForUpdate(ctx, conversationID, userID); err != nil {
+			return err
+		}
+		original, err := s.conversationRepo.GetMessage(ctx, conversationID, messageID)
+		if err != nil {
+			return err
+		}
+		response.ParentMessageID = original.ParentMessageID
+
+		if req.InPlace {
+			if original.Sender != constants.SenderRoleSystem {
+				return ErrInPlaceEditNotAllowed
+			}
+			if original.RedactedAt != nil {
+				return ErrMessageRedacted
+			}
+			if err := s.conversationRepo.UpdateMessageContent(ctx, conversationID, messageID, req.Message, time.Now()); err != nil {
+				return err
+			}
+			response.MessageID = messageID
+		} else {
+			// Add the new version next to the original
+			message := &models.Message{
+				MessageID:       uuid.New(),
+				ConversationID:  conversationID,
+				ParentMessageID: original.ParentMessageID,
+				Sender:          original.Sender,
+				Content:         req.Message,
+				Timestamp:       time.Now(),
+				ToolCalls:       original.ToolCalls,
+				ToolCallID:      original.ToolCallID,
+				ToolName:        original.ToolName,
+				Status:          constants.MessageStatusComplete,
+			}
+			if len(req.Parts) > 0 {
+				if message.Content, message.Parts, err = s.resolveParts(ctx, conversationID, req.Parts); err != nil {
+					return err
+				}
+			}
+			if err := s.conversationRepo.CreateMessage(ctx, message, userID); err != nil {
+				return err
+			}
+			if err := s.conversationRepo.SetActiveLeaf(ctx, conversationID, &message.MessageID); err != nil {
+				return err
+			}
+			response.MessageID = message.MessageID
+			added = message
+		}
+
+		// Update conversation timestamp
+		return s.conversationRepo.UpdateConversationTimestamp(ctx, conversationID)
+	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if added != nil {
+		s.publishMessage(added)
+	}
+
+	response.Message = "Message edited successfully"
+	return response, nil
+}
+
+// RegenerateMessage adds a new version of an AI message of a conversation
+// owned by the user: a sibling with the same parent, recording the model that
+// produced it, which becomes the end of the active branch.
+func (s *ConversationService) RegenerateMessage(ctx context.Context, conversationID, messageID uuid.UUID, userID uint, req *dto.RegenerateMessageRequest) (*dto.RegenerateMessageResponse, error) {
+	if err := validateMessageMetadata(constants.SenderRoleAI, req.Metadata, req.Model); err != nil {
+		return nil, err
+	}
+	if err := validateToolFields(constants.SenderRoleAI, req.ToolCalls, ""); err != nil {
+		return nil, err
+	}
+	if err := validateParts(req.Message, req.Parts); err != nil {
+		return nil, err
 	}
 
-	// Update conversation timestamp
-	err = s.conversationRepo.UpdateConversationTimestamp(conversationID)
+	response := &dto.RegenerateMessageResponse{}
+	var message *models.Message
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		conversation, err := s.conversationRepo.GetConversationForUpdate(ctx, conversationID, userID)
+		if err != nil {
+			return err
+		}
+		original, err := s.conversationRepo.GetMessage(ctx, conversationID, messageID)
+		if err != nil {
+			return err
+		}
+		if original.Sender != constants.SenderRoleAI {
+			return ErrRegenerateNotAllowed
+		}
+
+		// Add the new version next to the original
+		metadata := aiMetadata(req.Metadata, req.Model, conversation)
+		response.Model = metadata.model()
+		message = &models.Message{
+			MessageID:       uuid.New(),
+			ConversationID:  conversationID,
+			ParentMessageID: original.ParentMessageID,
+			Sender:          constants.SenderRoleAI,
+			Content:         req.Message,
+			Metadata:        encodeMetadata(metadata),
+			Timestamp:       time.Now(),
+			ToolCalls:       encodeToolCalls(req.ToolCalls),
+			Status:          constants.MessageStatusComplete,
+		}
+		if len(req.Parts) > 0 {
+			if message.Content, message.Parts, err = s.resolveParts(ctx, conversationID, req.Parts); err != nil {
+				return err
+			}
+		}
+		if err := s.conversationRepo.CreateMessage(ctx, message, userID); err != nil {
+			return err
+		}
+		if err := s.conversationRepo.SetActiveLeaf(ctx, conversationID, &message.MessageID); err != nil {
+			return err
+		}
+
+		version, err := s.conversationRepo.GetMessageWithSiblings(ctx, conversationID, message.MessageID)
+		if err != nil {
+			return err
+		}
+		response.MessageID = message.MessageID
+		response.ParentMessageID = message.ParentMessageID
+		response.VersionIndex = slices.Index(version.SiblingIDs, message.MessageID)
+		response.VersionCount = len(version.SiblingIDs)
+
+		// Update conversation timestamp
+		return s.conversationRepo.UpdateConversationTimestamp(ctx, conversationID)
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	s.publishMessage(message)
 
-	return nil
+	response.Message = "Message regenerated successfully"
+	return response, nil
 }
 
-// GetConversationHistory retrieves all messages for a conversation
-func (s *ConversationService) GetConversationHistory(conversationID uuid.UUID) (*dto.GetConversationResponse, error) {
-	// Verify conversation exists
-	_, err := s.conversationRepo.GetConversationByID(conversationID)
+// DeleteMessage removes a message from a conversation owned by the user. By
+// default the message is deleted with every reply below it, and the active
+// branch moves to the latest remaining message under the same parent if it ran
+// through the message. In redact mode the message keeps its place in the tree
+// and only its content is replaced.
+func (s *ConversationService) DeleteMessage(ctx context.Context, conversationID, messageID uuid.UUID, userID uint, query *dto.DeleteMessageQuery) (*dto.DeleteMessageResponse, error) {
+	response := &dto.DeleteMessageResponse{Redacted: query.Mode == DeleteModeRedact}
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		conversation, err := s.conversationRepo.GetConversationForUpdate(ctx, conversationID, userID)
+		if err != nil {
+			return err
+		}
+		message, err := s.conversationRepo.GetMessage(ctx, conversationID, messageID)
+		if err != nil {
+			return err
+		}
+
+		if response.Redacted {
+			if err := s.conversationRepo.RedactMessage(ctx, conversationID, messageID, RedactedContent, userID, time.Now()); err != nil {
+				return err
+			}
+		} else {
+			if response.DeletedCount, err = s.conversationRepo.DeleteMessageSubtree(ctx, conversationID, messageID); err != nil {
+				return err
+			}
+			leafID, err := s.repairActiveLeaf(ctx, conversation, message)
+			if err != nil {
+				return err
+			}
+			response.ActiveLeafID = leafID
+		}
+
+		// Update conversation timestamp
+		return s.conversationRepo.UpdateConversationTimestamp(ctx, conversationID)
+	})
 	if err != nil {
-		return nil, errors.New("conversation not found")
+		return nil, err
+	}
+
+	response.Message = "Message deleted successfully"
+	if response.Redacted {
+		response.Message = "Message redacted successfully"
+	}
+	return response, nil
+}
+
+// repairActiveLeaf moves the active branch of a conversation off a deleted
+// subtree, to the latest message under the parent of deleted or, for a root
+// message, to the latest message of the conversation. It returns the
+// resulting active leaf.
+func (s *ConversationService) repairActiveLeaf(ctx context.Context, conversation *models.Conversation, deleted *models.Message) (*uuid.UUID, error) {
+	if conversation.ActiveLeafID == nil {
+		return nil, nil
+	}
+	_, err := s.conversationRepo.GetMessage(ctx, conversation.ConversationID, *conversation.ActiveLeafID)
+	if err == nil {
+		return conversation.ActiveLeafID, nil
+	}
+	if !errors.Is(err, repository.ErrMessageNotFound) {
+		return nil, err
+	}
+
+	var leafID *uuid.UUID
+	if deleted.ParentMessageID != nil {
+		leaf, err := s.conversationRepo.GetLatestLeaf(ctx, conversation.ConversationID, conversation.UserID, *deleted.ParentMessageID)
+		if err != nil {
+			return nil, err
+		}
+		leafID = &leaf
+	} else {
+		latest, err := s.conversationRepo.GetLatestMessage(ctx, conversation.ConversationID)
+		if err != nil && !errors.Is(err, repository.ErrMessageNotFound) {
+			return nil, err
+		}
+		if latest != nil {
+			leafID = &latest.MessageID
+		}
+	}
+	if err := s.conversationRepo.SetActiveLeaf(ctx, conversation.ConversationID, leafID); err != nil {
+		return nil, err
+	}
+	return leafID, nil
+}
+
+// GetConversationHistory retrieves a page of a branch of a conversation owned
+// by the user, by default the active one. Conversations owned by someone else
+// are reported as not found.
+func (s *ConversationService) GetConversationHistory(ctx context.Context, conversationID uuid.UUID, userID uint, query *dto.GetConversationQuery) (*dto.GetConversationResponse, error) {
+	if err := checkCursors(&query.PageQuery); err != nil {
+		return nil, err
+	}
+	page := repository.MessagePage{Limit: pageSize(query.Limit)}
+	var err error
+	if page.Metadata, err = parseMetadataFilters(query.Metadata); err != nil {
+		return nil, err
+	}
+	if page.After, err = decodeMessageCursor(query.After); err != nil {
+		return nil, err
+	}
+	if page.Before, err = decodeMessageCursor(query.Before); err != nil {
+		return nil, err
+	}
+
+	// Find the end of the branch, by default the active leaf, which the
+	// repository resolves. Both lookups check ownership first, so messages of
+	// other users' conversations are reported as conversations not found.
+	var leafID *uuid.UUID
+	if query.LeafID != "" {
+		messageID, err := uuid.Parse(query.LeafID)
+		if err != nil {
+			return nil, ErrInvalidMessageID
+		}
+		leaf, err := s.conversationRepo.GetLatestLeaf(ctx, conversationID, userID, messageID)
+		if err != nil {
+			return nil, err
+		}
+		leafID = &leaf
 	}
 
-	// Get messages
-	messages, err := s.conversationRepo.GetConversationHistory(conversationID)
+	// Get messages, verifying ownership in the same query
+	branch, err := s.conversationRepo.GetConversationHistory(ctx, conversationID, userID, leafID, page)
 	if err != nil {
 		return nil, err
 	}
+	messages, more := branch.Messages, branch.More
+
+	// Show the messages whose stream was abandoned as failed. Reading does
+	// not save that; the next chunk sent to such a message does.
+	for i := range messages {
+		if s.isStale(&messages[i].Message) {
+			messages[i].Status = constants.MessageStatusFailed
+		}
+	}
+
+	// Describe the attachments of parts, which all belong to the conversation
+	attachments := make(map[string]*models.Attachment)
+	if slices.ContainsFunc(messages, func(msg repository.BranchMessage) bool { return msg.Parts != nil }) {
+		list, err := s.conversationRepo.ListAttachments(ctx, conversationID)
+		if err != nil {
+			return nil, err
+		}
+		for i := range list {
+			attachments[list[i].AttachmentID.String()] = &list[i]
+		}
+	}
 
 	// Convert to DTO
 	var messageItems []dto.MessageHistoryItem
 	for _, msg := range messages {
-		messageItems = append(messageItems, dto.MessageHistoryItem{
-			Message:   msg.Content,
-			Role:      msg.Sender,
-			Timestamp: msg.Timestamp,
-		})
+		parts, err := s.partItems(decodeParts(&msg.Message), attachments)
+		if err != nil {
+			return nil, err
+		}
+		item := dto.MessageHistoryItem{
+			MessageID:       msg.MessageID,
+			ParentMessageID: msg.ParentMessageID,
+			Message:         msg.Content,
+			Role:            msg.Sender,
+			Status:          msg.Status,
+			Timestamp:       msg.Timestamp,
+			EditedAt:        msg.EditedAt,
+			RedactedAt:      msg.RedactedAt,
+			RedactedBy:      msg.RedactedBy,
+			Metadata:        rawMetadata(&msg.Message),
+			Model:           decodeMetadata(&msg.Message).model(),
+			ToolCalls:       decodeToolCalls(&msg.Message),
+			ToolCallID:      stringValue(msg.ToolCallID),
+			ToolName:        stringValue(msg.ToolName),
+			Parts:           parts,
+			SiblingIndex:    slices.Index(msg.SiblingIDs, msg.MessageID),
+			SiblingCount:    len(msg.SiblingIDs),
+		}
+		if len(msg.SiblingIDs) > 1 {
+			item.SiblingIDs = msg.SiblingIDs
+		}
+		messageItems = append(messageItems, item)
 	}
 
-	return &dto.GetConversationResponse{
+	response := &dto.GetConversationResponse{
 		Messages: messageItems,
+		LeafID:   branch.LeafID,
+	}
+	if n := len(messages); n > 0 {
+		// more refers to the direction the page was read in: forwards after a
+		// cursor, backwards otherwise, as the default page is the latest one
+		if page.After != nil && more || page.Before != nil {
+			response.NextCursor = encodeMessageCursor(repository.MessageKeyOf(&messages[n-1].Message))
+		}
+		if more || page.After != nil {
+			response.PrevCursor = encodeMessageCursor(repository.MessageKeyOf(&messages[0].Message))
+		}
+	}
+	return response, nil
+}
+
+// SwitchBranch makes the branch through a message the active branch of a
+// conversation owned by the user. The branch is continued to the most recent
+// message below the chosen one.
+func (s *ConversationService) SwitchBranch(ctx context.Context, conversationID uuid.UUID, userID uint, req *dto.SwitchBranchRequest) (*dto.SwitchBranchResponse, error) {
+	messageID, err := uuid.Parse(req.MessageID)
+	if err != nil {
+		return nil, ErrInvalidMessageID
+	}
+
+	var leafID uuid.UUID
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if _, err := s.conversationRepo.GetConversationForUpdate(ctx, conversationID, userID); err != nil {
+			return err
+		}
+		leafID, err = s.conversationRepo.GetLatestLeaf(ctx, conversationID, userID, messageID)
+		if err != nil {
+			return err
+		}
+		return s.conversationRepo.SetActiveLeaf(ctx, conversationID, &leafID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.SwitchBranchResponse{
+		ConversationID: conversationID,
+		ActiveLeafID:   leafID,
+		Message:        "Active branch switched successfully",
 	}, nil
 }
 
-// GetAllConversations retrieves all conversations for a user
-func (s *ConversationService) GetAllConversations(userID uint) (*dto.GetAllConversationsResponse, error) {
+// GetAllConversations retrieves a page of conversations for a user
+func (s *ConversationService) GetAllConversations(ctx context.Context, userID uint, query *dto.GetAllConversationsQuery) (*dto.GetAllConversationsResponse, error) {
+	if err := checkCursors(&query.PageQuery); err != nil {
+		return nil, err
+	}
+	page := repository.ConversationPage{Pinned: query.Pinned, Limit: pageSize(query.Limit)}
+	var err error
+	if page.After, err = decodeConversationCursor(query.After); err != nil {
+		return nil, err
+	}
+	if page.Before, err = decodeConversationCursor(query.Before); err != nil {
+		return nil, err
+	}
+
 	// Get conversations
-	conversations, err := s.conversationRepo.GetAllConversationsByUserID(userID)
+	conversations, more, err := s.conversationRepo.ListConversationsByUserID(ctx, userID, page)
 	if err != nil {
 		return nil, err
 	}
@@ -138,50 +624,120 @@ func (s *ConversationService) GetAllConversations(userID uint) (*dto.GetAllConve
 		})
 	}
 
-	return &dto.GetAllConversationsResponse{
+	response := &dto.GetAllConversationsResponse{
 		Conversations: conversationItems,
-	}, nil
+	}
+	if n := len(conversations); n > 0 {
+		// more refers to the direction the page was read in: backwards before a
+		// cursor, forwards otherwise
+		if more || page.Before != nil {
+			response.NextCursor = encodeConversationCursor(repository.ConversationKeyOf(&conversations[n-1]))
+		}
+		if page.Before != nil && more || page.After != nil {
+			response.PrevCursor = encodeConversationCursor(repository.ConversationKeyOf(&conversations[0]))
+		}
+	}
+	return response, nil
 }
 
-// DeleteConversation deletes a conversation and all its messages
-func (s *ConversationService) DeleteConversation(conversationID uuid.UUID, userID uint) (*dto.DeleteConversationResponse, error) {
-	// Verify conversation exists and belongs to user
-	conversation, err := s.conversationRepo.GetConversationByID(conversationID)
-	if err != nil {
-		return nil, errors.New("conversation not found")
+// SearchConversations searches the titles and messages of the user's
+// conversations, best match first
+func (s *ConversationService) SearchConversations(ctx context.Context, userID uint, query *dto.SearchConversationsQuery) (*dto.SearchConversationsResponse, error) {
+	text := strings.TrimSpace(query.Q)
+	if text == "" {
+		return nil, errEmptySearch
+	}
+	if query.From != nil && query.To != nil && !query.From.Before(*query.To) {
+		return nil, errSearchRange
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultSearchResults
 	}
 
-	// Check if user owns the conversation
-	if conversation.UserID != userID {
-		return nil, errors.New("access denied: you can only delete your own conversations")
+	// Search, scoped to the user's conversations
+	metadata, err := parseMetadataFilters(query.Metadata)
+	if err != nil {
+		return nil, err
 	}
 
-	// Delete conversation (messages will be deleted due to CASCADE)
-	err = s.conversationRepo.DeleteConversation(conversationID)
+	results, err := s.conversationRepo.SearchConversations(ctx, userID, repository.SearchQuery{
+		Text:     text,
+		Sender:   query.Sender,
+		From:     query.From,
+		To:       query.To,
+		Metadata: metadata,
+		Pinned:   query.Pinned,
+		Limit:    limit,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return &dto.DeleteConversationResponse{
-		Message: "Conversation deleted successfully",
+	// Convert to DTO
+	var items []dto.SearchResultItem
+	for _, result := range results {
+		matches := make([]dto.SearchMessageMatch, 0, len(result.Matches))
+		for _, match := range result.Matches {
+			matches = append(matches, dto.SearchMessageMatch{
+				MessageID: match.MessageID,
+				Role:      match.Sender,
+				Timestamp: match.Timestamp,
+				Snippet:   match.Snippet,
+			})
+		}
+		items = append(items, dto.SearchResultItem{
+			ConversationID: result.Conversation.ConversationID,
+			Title:          result.Conversation.Title,
+			IsPinned:       result.Conversation.IsPinned,
+			UpdatedAt:      result.Conversation.UpdatedAt,
+			Rank:           result.Rank,
+			TitleSnippet:   result.TitleSnippet,
+			MatchCount:     result.MatchCount,
+			Matches:        matches,
+		})
+	}
+
+	return &dto.SearchConversationsResponse{
+		Results: items,
 	}, nil
 }
 
-// ToggleConversationPin toggles the pin status of a conversation
-func (s *ConversationService) ToggleConversationPin(conversationID uuid.UUID, userID uint, req *dto.PinConversationRequest) (*dto.PinConversationResponse, error) {
-	// Verify conversation exists and belongs to user
-	conversation, err := s.conversationRepo.GetConversationByID(conversationID)
+// DeleteConversation deletes a conversation with all its messages and
+// attachments, and then the content of the attachments, and ends the
+// subscriptions to it
+func (s *ConversationService) DeleteConversation(ctx context.Context, conversationID uuid.UUID, userID uint) (*dto.DeleteConversationResponse, error) {
+	var attachments []models.Attachment
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		// Lock the conversation, so that no attachment is added after they
+		// are listed
+		if _, err := s.conversationRepo.GetConversationForUpdate(ctx, conversationID, userID); err != nil {
+			return err
+		}
+		var err error
+		if attachments, err = s.conversationRepo.ListAttachments(ctx, conversationID); err != nil {
+			return err
+		}
+
+		// Delete conversation, verifying ownership in the same statement
+		// (messages and attachments will be deleted due to CASCADE)
+		return s.conversationRepo.DeleteConversation(ctx, conversationID, userID)
+	})
 	if err != nil {
-		return nil, errors.New("conversation not found")
+		return nil, err
 	}
+	s.deleteBlobs(ctx, attachments)
+	s.broker.closeConversation(conversationID)
 
-	// Check if user owns the conversation
-	if conversation.UserID != userID {
-		return nil, errors.New("access denied: you can only pin your own conversations")
-	}
+	return &dto.DeleteConversationResponse{
+		Message: "Conversation deleted successfully",
+	}, nil
+}
 
-	// Update pin status
-	err = s.conversationRepo.UpdateConversationPin(conversationID, req.IsPinned)
+// ToggleConversationPin toggles the pin status of a conversation
+func (s *ConversationService) ToggleConversationPin(ctx context.Context, conversationID uuid.UUID, userID uint, req *dto.PinConversationRequest) (*dto.PinConversationResponse, error) {
+	// Update pin status, verifying ownership in the same statement
+	err := s.conversationRepo.UpdateConversationPin(ctx, conversationID, userID, req.IsPinned)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,104 @@
+package conversation
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+	"user_service/internal/apperrors"
+	dto "user_service/internal/dto/conversation"
+	"user_service/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// Page sizes for list endpoints
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 100
+)
+
+// ErrInvalidCursor is returned for a pagination cursor that was not issued by
+// the endpoint it was sent to
+var ErrInvalidCursor = apperrors.BadRequest("invalid_cursor", "invalid pagination cursor")
+
+var errBothCursors = apperrors.Validation("request validation failed",
+	apperrors.FieldError{Field: "before", Message: "cannot be combined with after"})
+
+// cursor is the content of an opaque pagination cursor. Kind keeps cursors of
+// different lists apart.
+type cursor struct {
+	Kind   string    `json:"k"`
+	Pinned bool      `json:"p,omitempty"`
+	Time   time.Time `json:"t"`
+	ID     uuid.UUID `json:"id"`
+}
+
+const (
+	conversationCursorKind = "c"
+	messageCursorKind      = "m"
+)
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// checkCursors rejects a page query that gives both cursors
+func checkCursors(query *dto.PageQuery) error {
+	if query.Before != "" && query.After != "" {
+		return errBothCursors
+	}
+	return nil
+}
+
+// decodeCursor parses a cursor of the given kind. It returns nil for an empty
+// string.
+func decodeCursor(s, kind string) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Kind != kind {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+func encodeConversationCursor(key repository.ConversationKey) string {
+	return encodeCursor(cursor{Kind: conversationCursorKind, Pinned: key.IsPinned, Time: key.UpdatedAt, ID: key.ConversationID})
+}
+
+func decodeConversationCursor(s string) (*repository.ConversationKey, error) {
+	c, err := decodeCursor(s, conversationCursorKind)
+	if c == nil {
+		return nil, err
+	}
+	return &repository.ConversationKey{IsPinned: c.Pinned, UpdatedAt: c.Time, ConversationID: c.ID}, nil
+}
+
+func encodeMessageCursor(key repository.MessageKey) string {
+	return encodeCursor(cursor{Kind: messageCursorKind, Time: key.Timestamp, ID: key.MessageID})
+}
+
+func decodeMessageCursor(s string) (*repository.MessageKey, error) {
+	c, err := decodeCursor(s, messageCursorKind)
+	if c == nil {
+		return nil, err
+	}
+	return &repository.MessageKey{Timestamp: c.Time, MessageID: c.ID}, nil
+}
+
+// pageSize applies the default to a requested page size
+func pageSize(limit int) int {
+	if limit <= 0 {
+		return DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return limit
+}
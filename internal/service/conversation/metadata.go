@@ -0,0 +1,182 @@
+package conversation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"user_service/internal/apperrors"
+	"user_service/internal/constants"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+)
+
+// Limits of message metadata and of the metadata filters of a query
+const (
+	MaxMetadataBytes   = 4096
+	MaxMetadataFilters = 10
+)
+
+// Well-known metadata keys. Their values are validated; other keys may hold
+// any JSON value.
+const (
+	MetadataModel            = "model"
+	MetadataPromptTokens     = "prompt_tokens"
+	MetadataCompletionTokens = "completion_tokens"
+	MetadataFinishReason     = "finish_reason"
+	MetadataLatencyMS        = "latency_ms"
+)
+
+// metadataKeyPattern restricts metadata keys to identifiers, so that they
+// can be filtered on in every storage backend
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,64}$`)
+
+var errModelNotAI = apperrors.Validation("request validation failed",
+	apperrors.FieldError{Field: "model", Message: "is only allowed for ai messages"})
+
+var errModelTwice = apperrors.Validation("request validation failed",
+	apperrors.FieldError{Field: "model", Message: "cannot be combined with metadata.model"})
+
+// messageMetadata is the content of the metadata column of a message
+type messageMetadata map[string]interface{}
+
+// model returns the model that produced an AI message, if recorded
+func (m messageMetadata) model() string {
+	model, _ := m[MetadataModel].(string)
+	return model
+}
+
+func validationError(field, message string) error {
+	return apperrors.Validation("request validation failed", apperrors.FieldError{Field: field, Message: message})
+}
+
+// validateMetadata checks metadata given in a request. Numbers are expected
+// as decoded by encoding/json, as float64.
+func validateMetadata(metadata map[string]interface{}) error {
+	for _, key := range slices.Sorted(maps.Keys(metadata)) {
+		field := "metadata." + key
+		if !metadataKeyPattern.MatchString(key) {
+			return validationError("metadata", "keys must be 1-64 letters, digits or underscores")
+		}
+		switch key {
+		case MetadataModel, MetadataFinishReason:
+			if s, ok := metadata[key].(string); !ok || s == "" || len(s) > 100 {
+				return validationError(field, "must be a non-empty string of at most 100 characters")
+			}
+		case MetadataPromptTokens, MetadataCompletionTokens, MetadataLatencyMS:
+			if n, ok := metadata[key].(float64); !ok || n < 0 || n != math.Trunc(n) {
+				return validationError(field, "must be a non-negative integer")
+			}
+		}
+	}
+	if len(marshalJSON(metadata)) > MaxMetadataBytes {
+		return validationError("metadata", fmt.Sprintf("must be at most %d bytes", MaxMetadataBytes))
+	}
+	return nil
+}
+
+// validateMessageMetadata checks the metadata and model given for a new
+// message. Only AI messages record a model, given either way but not both.
+func validateMessageMetadata(sender string, metadata map[string]interface{}, model *string) error {
+	if err := validateMetadata(metadata); err != nil {
+		return err
+	}
+	_, hasModel := metadata[MetadataModel]
+	switch {
+	case sender != constants.SenderRoleAI && model != nil:
+		return errModelNotAI
+	case sender != constants.SenderRoleAI && hasModel:
+		return validationError("metadata."+MetadataModel, "is only allowed for ai messages")
+	case model != nil && hasModel:
+		return errModelTwice
+	}
+	return nil
+}
+
+// aiMetadata returns the metadata of an AI message: the metadata given,
+// with the requested model or else the model of the conversation
+func aiMetadata(metadata map[string]interface{}, requested *string, conversation *models.Conversation) messageMetadata {
+	result := messageMetadata(maps.Clone(metadata))
+	if result == nil {
+		result = messageMetadata{}
+	}
+	switch {
+	case requested != nil && *requested != "":
+		result[MetadataModel] = *requested
+	case result.model() == "" && conversation.ModelUsed != nil:
+		result[MetadataModel] = *conversation.ModelUsed
+	}
+	return result
+}
+
+// encodeMetadata returns the stored form of metadata, or nil if it is empty
+func encodeMetadata(metadata messageMetadata) *string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	encoded := string(marshalJSON(metadata))
+	return &encoded
+}
+
+// decodeMetadata reads the metadata of a message. Metadata that does not
+// parse is treated as empty.
+func decodeMetadata(message *models.Message) messageMetadata {
+	var metadata messageMetadata
+	if message.Metadata != nil {
+		_ = json.Unmarshal([]byte(*message.Metadata), &metadata)
+	}
+	return metadata
+}
+
+// rawMetadata returns the metadata of a message for a response
+func rawMetadata(message *models.Message) json.RawMessage {
+	if message.Metadata == nil || !json.Valid([]byte(*message.Metadata)) {
+		return nil
+	}
+	return json.RawMessage(*message.Metadata)
+}
+
+// parseMetadataFilters parses metadata query parameters: key selects messages
+// with the key, key:value those where it equals value. A value that is a JSON
+// scalar is matched as one, so 12 matches a number and "12" a string; any
+// other value is matched as a string.
+func parseMetadataFilters(params []string) ([]repository.MetadataFilter, error) {
+	if len(params) > MaxMetadataFilters {
+		return nil, validationError("metadata", fmt.Sprintf("at most %d filters are allowed", MaxMetadataFilters))
+	}
+	filters := make([]repository.MetadataFilter, 0, len(params))
+	for _, param := range params {
+		key, value, hasValue := strings.Cut(param, ":")
+		if !metadataKeyPattern.MatchString(key) {
+			return nil, validationError("metadata", "keys must be 1-64 letters, digits or underscores")
+		}
+		filter := repository.MetadataFilter{Key: key}
+		if hasValue {
+			var scalar interface{}
+			if json.Unmarshal([]byte(value), &scalar) != nil {
+				scalar = value
+			}
+			switch scalar.(type) {
+			case map[string]interface{}, []interface{}:
+				scalar = value
+			}
+			filter.Value = marshalJSON(scalar)
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// marshalJSON encodes a value without escaping HTML characters, so that it
+// compares equal to the JSON text stored by every backend
+func marshalJSON(value interface{}) []byte {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(value)
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
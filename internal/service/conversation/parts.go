@@ -0,0 +1,123 @@
+package conversation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"user_service/internal/apperrors"
+	"user_service/internal/constants"
+	dto "user_service/internal/dto/conversation"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+var errMessageAndParts = apperrors.Validation("request validation failed",
+	apperrors.FieldError{Field: "message", Message: "cannot be combined with parts"})
+
+var errPartsInPlace = apperrors.Validation("request validation failed",
+	apperrors.FieldError{Field: "parts", Message: "cannot be combined with in_place"})
+
+// validateParts checks the parts given for a message, which replace its
+// message text. Attachments are checked by resolveParts.
+func validateParts(message string, parts []dto.MessagePart) error {
+	if len(parts) > 0 && message != "" {
+		return errMessageAndParts
+	}
+	for i, part := range parts {
+		field := fmt.Sprintf("parts[%d]", i)
+		switch part.Type {
+		case constants.PartTypeText, constants.PartTypeCode:
+			if strings.TrimSpace(part.Text) == "" {
+				return validationError(field+".text", "is required")
+			}
+			if part.AttachmentID != "" {
+				return validationError(field+".attachment_id", "is only allowed for image and file parts")
+			}
+			if part.Type == constants.PartTypeText && part.Language != "" {
+				return validationError(field+".language", "is only allowed for code parts")
+			}
+		case constants.PartTypeImage, constants.PartTypeFile:
+			if part.AttachmentID == "" {
+				return validationError(field+".attachment_id", "is required")
+			}
+			if part.Text != "" || part.Language != "" {
+				return validationError(field+".text", "is only allowed for text and code parts")
+			}
+		}
+	}
+	return nil
+}
+
+// resolveParts checks that the attachments referenced by parts belong to the
+// conversation, and returns the plain-text rendering of the parts, which is
+// stored as the content of the message, and their stored form. Text parts
+// are rendered as they are, code parts as fenced blocks and attachments by
+// their file name.
+func (s *ConversationService) resolveParts(ctx context.Context, conversationID uuid.UUID, parts []dto.MessagePart) (string, *string, error) {
+	rendered := make([]string, 0, len(parts))
+	stored := make([]dto.MessagePart, 0, len(parts))
+	for i, part := range parts {
+		field := fmt.Sprintf("parts[%d].attachment_id", i)
+		switch part.Type {
+		case constants.PartTypeText:
+			rendered = append(rendered, part.Text)
+		case constants.PartTypeCode:
+			rendered = append(rendered, "```"+part.Language+"\n"+strings.TrimSuffix(part.Text, "\n")+"\n```")
+		case constants.PartTypeImage, constants.PartTypeFile:
+			attachmentID, err := uuid.Parse(part.AttachmentID)
+			if err != nil {
+				return "", nil, validationError(field, "must be a valid UUID")
+			}
+			attachment, err := s.conversationRepo.GetAttachment(ctx, attachmentID)
+			if errors.Is(err, repository.ErrAttachmentNotFound) || err == nil && attachment.ConversationID != conversationID {
+				return "", nil, validationError(field, "must be an attachment of the conversation")
+			}
+			if err != nil {
+				return "", nil, err
+			}
+			if part.Type == constants.PartTypeImage && !strings.HasPrefix(attachment.ContentType, "image/") {
+				return "", nil, validationError(field, "must be an image attachment")
+			}
+			part.AttachmentID = attachmentID.String()
+			rendered = append(rendered, fmt.Sprintf("[%s: %s]", part.Type, attachment.Filename))
+		}
+		stored = append(stored, part)
+	}
+	encoded := string(marshalJSON(stored))
+	return strings.Join(rendered, "\n\n"), &encoded, nil
+}
+
+// decodeParts reads the parts of a message. Parts that do not parse are
+// treated as absent.
+func decodeParts(message *models.Message) []dto.MessagePart {
+	var parts []dto.MessagePart
+	if message.Parts != nil {
+		_ = json.Unmarshal([]byte(*message.Parts), &parts)
+	}
+	return parts
+}
+
+// partItems describes the parts of a message for a response, with the
+// attachments they reference taken from attachments
+func (s *ConversationService) partItems(parts []dto.MessagePart, attachments map[string]*models.Attachment) ([]dto.MessagePartItem, error) {
+	if len(parts) == 0 {
+		return nil, nil
+	}
+	items := make([]dto.MessagePartItem, 0, len(parts))
+	for _, part := range parts {
+		item := dto.MessagePartItem{MessagePart: part}
+		if attachment, ok := attachments[part.AttachmentID]; ok && part.AttachmentID != "" {
+			described, err := s.attachmentItem(attachment)
+			if err != nil {
+				return nil, err
+			}
+			item.Attachment = described
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
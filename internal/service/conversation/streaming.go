@@ -0,0 +1,478 @@
+package conversation
+
+import (
+	"context"
+	"errors"
+	"log"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+	"user_service/internal/apperrors"
+	"user_service/internal/constants"
+	dto "user_service/internal/dto/conversation"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// StreamSettings configures streamed AI messages.
+//
+// Streams are served by a single process: the subscribers of a conversation,
+// the buffer of a streaming message and its flush and idle timers all live in
+// the process that handles its requests. With several instances, the chunks
+// and subscribers of a conversation must be routed to the same one. Timers do
+// not run while a process is frozen, as on Lambda, and are lost when it
+// stops, so open messages are also expired from their saved state: one that
+// was not saved for IdleTimeout is shown as failed when it is read, and marked
+// failed when a chunk is next sent to it. The flush interval must be shorter
+// than the idle timeout.
+type StreamSettings struct {
+	// FlushInterval is how often the partial content of a streaming message
+	// is saved
+	FlushInterval time.Duration
+	// IdleTimeout is how long a streaming message may go without a chunk
+	// before it is marked failed
+	IdleTimeout time.Duration
+}
+
+// ErrMessageNotStreaming is returned for chunks sent to a message that is not
+// an AI message open for streaming
+var ErrMessageNotStreaming = apperrors.Conflict("message_not_streaming", "message is not open for streaming")
+
+var (
+	errStatusNotAI = apperrors.Validation("request validation failed",
+		apperrors.FieldError{Field: "status", Message: "pending is only allowed for ai messages"})
+	errPartsPending = apperrors.Validation("request validation failed",
+		apperrors.FieldError{Field: "parts", Message: "cannot be combined with status pending"})
+	errMessageRequired = apperrors.Validation("request validation failed",
+		apperrors.FieldError{Field: "message", Message: "is required"})
+)
+
+// Names of the events sent to the subscribers of a conversation
+const (
+	StreamEventMessage  = "message"
+	StreamEventChunk    = "chunk"
+	StreamEventStatus   = "status"
+	StreamEventSnapshot = "snapshot"
+)
+
+// subscriberBuffer is the number of events a subscriber may fall behind by
+// before it is dropped
+const subscriberBuffer = 256
+
+// backgroundWriteTimeout bounds the saves made outside of a request, by the
+// flush and idle timers
+const backgroundWriteTimeout = 10 * time.Second
+
+// StreamEvent is an event sent to the subscribers of a conversation: Name is
+// one of the StreamEvent constants and Data the matching stream event DTO
+type StreamEvent struct {
+	Name string
+	Data interface{}
+}
+
+// Subscription receives the events of a conversation. Events is closed when
+// the subscriber falls too far behind or the conversation is deleted.
+type Subscription struct {
+	// Snapshot describes the messages being streamed when the subscription
+	// started. Chunks at an offset before the end of a snapshot repeat it.
+	Snapshot []StreamEvent
+	Events   <-chan StreamEvent
+	cancel   func()
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.cancel()
+}
+
+// broker fans the events of each conversation out to its subscribers. It
+// only reaches the subscribers connected to this process.
+type broker struct {
+	mu          sync.Mutex
+	subscribers map[uuid.UUID]map[chan StreamEvent]struct{}
+}
+
+func newBroker() *broker {
+	return &broker{subscribers: make(map[uuid.UUID]map[chan StreamEvent]struct{})}
+}
+
+// subscribe registers a subscriber to a conversation and returns its events
+// and the function that unregisters it
+func (b *broker) subscribe(conversationID uuid.UUID) (<-chan StreamEvent, func()) {
+	events := make(chan StreamEvent, subscriberBuffer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[conversationID] == nil {
+		b.subscribers[conversationID] = make(map[chan StreamEvent]struct{})
+	}
+	b.subscribers[conversationID][events] = struct{}{}
+
+	return events, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(conversationID, events)
+	}
+}
+
+// publish sends an event to the subscribers of a conversation without
+// blocking. Subscribers that cannot keep up are dropped, so that they reload
+// the conversation instead of silently missing chunks.
+func (b *broker) publish(conversationID uuid.UUID, event StreamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for events := range b.subscribers[conversationID] {
+		select {
+		case events <- event:
+		default:
+			b.remove(conversationID, events)
+		}
+	}
+}
+
+// closeConversation drops every subscriber of a conversation
+func (b *broker) closeConversation(conversationID uuid.UUID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for events := range b.subscribers[conversationID] {
+		b.remove(conversationID, events)
+	}
+}
+
+// remove drops a subscriber, if still registered. The caller holds b.mu.
+func (b *broker) remove(conversationID uuid.UUID, events chan StreamEvent) {
+	subscribers := b.subscribers[conversationID]
+	if _, ok := subscribers[events]; !ok {
+		return
+	}
+	delete(subscribers, events)
+	close(events)
+	if len(subscribers) == 0 {
+		delete(b.subscribers, conversationID)
+	}
+}
+
+// streamBuffer holds a message being streamed through this process. Chunks
+// are appended in memory and published right away; the content received
+// since the last save is saved every flush interval and when the stream ends.
+type streamBuffer struct {
+	mu             sync.Mutex
+	conversationID uuid.UUID
+	messageID      uuid.UUID
+	userID         uint
+	status         string
+	content        strings.Builder
+	// flushed is the length of the content saved so far
+	flushed    int
+	metadata   messageMetadata
+	lastChunk  time.Time
+	flushTimer *time.Timer
+	idleTimer  *time.Timer
+	done       bool
+}
+
+// AppendChunk appends content to an AI message of a conversation owned by the
+// user that was opened for streaming, and publishes it to the subscribers of
+// the conversation. A chunk with status complete or failed ends the stream:
+// its content and metadata are saved with the final status right away.
+func (s *ConversationService) AppendChunk(ctx context.Context, conversationID, messageID uuid.UUID, userID uint, req *dto.AppendChunkRequest) (*dto.AppendChunkResponse, error) {
+	status := req.Status
+	if status == "" {
+		status = constants.MessageStatusStreaming
+	}
+	if err := validateMetadata(req.Metadata); err != nil {
+		return nil, err
+	}
+
+	buffer, err := s.openStream(ctx, conversationID, messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	if buffer.done {
+		return nil, ErrMessageNotStreaming
+	}
+	buffer.lastChunk = time.Now()
+
+	if status == constants.MessageStatusStreaming {
+		s.appendToBuffer(buffer, req.Content)
+		buffer.status = status
+		if buffer.flushTimer == nil {
+			buffer.flushTimer = time.AfterFunc(s.streams.FlushInterval, func() { s.flushStream(buffer) })
+		}
+	} else {
+		metadata := maps.Clone(buffer.metadata)
+		if metadata == nil {
+			metadata = messageMetadata{}
+		}
+		maps.Copy(metadata, req.Metadata)
+		if len(marshalJSON(metadata)) > MaxMetadataBytes {
+			return nil, validationError("metadata", "would exceed the metadata size limit of the message")
+		}
+		if err := s.finishStream(ctx, buffer, req.Content, status, encodeMetadata(metadata)); err != nil {
+			return nil, err
+		}
+	}
+
+	return &dto.AppendChunkResponse{
+		MessageID: messageID,
+		Status:    buffer.status,
+		Length:    buffer.content.Len(),
+	}, nil
+}
+
+// Subscribe subscribes to the events of a conversation owned by the user: new
+// messages, and the chunks and end of streaming messages. Conversations owned
+// by someone else are reported as not found. The caller must close the
+// subscription.
+func (s *ConversationService) Subscribe(ctx context.Context, conversationID uuid.UUID, userID uint) (*Subscription, error) {
+	conversation, err := s.conversationRepo.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if conversation.UserID != userID {
+		return nil, repository.ErrConversationNotFound
+	}
+
+	// Subscribe before taking the snapshot, so that no chunk falls between
+	// the two
+	events, cancel := s.broker.subscribe(conversationID)
+	subscription := &Subscription{Events: events, cancel: cancel}
+
+	s.streamsMu.Lock()
+	var buffers []*streamBuffer
+	for _, buffer := range s.buffers {
+		if buffer.conversationID == conversationID {
+			buffers = append(buffers, buffer)
+		}
+	}
+	s.streamsMu.Unlock()
+
+	for _, buffer := range buffers {
+		buffer.mu.Lock()
+		if !buffer.done {
+			subscription.Snapshot = append(subscription.Snapshot, StreamEvent{Name: StreamEventSnapshot, Data: dto.StreamSnapshotEvent{
+				MessageID: buffer.messageID,
+				Status:    buffer.status,
+				Message:   buffer.content.String(),
+			}})
+		}
+		buffer.mu.Unlock()
+	}
+	return subscription, nil
+}
+
+// openStream returns the buffer of a message open for streaming, loading
+// the message if it is not buffered yet
+func (s *ConversationService) openStream(ctx context.Context, conversationID, messageID uuid.UUID, userID uint) (*streamBuffer, error) {
+	s.streamsMu.Lock()
+	buffer, ok := s.buffers[messageID]
+	s.streamsMu.Unlock()
+	if ok {
+		if buffer.userID != userID {
+			return nil, repository.ErrConversationNotFound
+		}
+		if buffer.conversationID != conversationID {
+			return nil, repository.ErrMessageNotFound
+		}
+		return buffer, nil
+	}
+
+	conversation, err := s.conversationRepo.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if conversation.UserID != userID {
+		return nil, repository.ErrConversationNotFound
+	}
+	message, err := s.conversationRepo.GetMessage(ctx, conversationID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.Sender != constants.SenderRoleAI || !constants.IsOpenMessageStatus(message.Status) || message.RedactedAt != nil {
+		return nil, ErrMessageNotStreaming
+	}
+	if s.isStale(message) {
+		// Abandoned by the process that streamed it
+		if _, err := s.conversationRepo.ExpireStreamingMessages(ctx, conversationID, s.staleBefore()); err != nil {
+			return nil, err
+		}
+		return nil, ErrMessageNotStreaming
+	}
+
+	buffer = &streamBuffer{
+		conversationID: conversationID,
+		messageID:      messageID,
+		userID:         userID,
+		status:         message.Status,
+		flushed:        len(message.Content),
+		metadata:       decodeMetadata(message),
+		lastChunk:      time.Now(),
+	}
+	buffer.content.WriteString(message.Content)
+
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	if existing, ok := s.buffers[messageID]; ok {
+		return existing, nil
+	}
+	s.buffers[messageID] = buffer
+	buffer.mu.Lock()
+	buffer.idleTimer = time.AfterFunc(s.streams.IdleTimeout, func() { s.expireStream(buffer) })
+	buffer.mu.Unlock()
+	return buffer, nil
+}
+
+// appendToBuffer appends content to a buffered message and publishes it. The
+// caller holds buffer.mu.
+func (s *ConversationService) appendToBuffer(buffer *streamBuffer, content string) {
+	if content == "" {
+		return
+	}
+	offset := buffer.content.Len()
+	buffer.content.WriteString(content)
+	s.broker.publish(buffer.conversationID, StreamEvent{Name: StreamEventChunk, Data: dto.StreamChunkEvent{
+		MessageID: buffer.messageID,
+		Offset:    offset,
+		Content:   content,
+	}})
+}
+
+// flushStream saves the content a streaming message received since the last
+// save. A message that was deleted or redacted meanwhile stops being
+// buffered.
+func (s *ConversationService) flushStream(buffer *streamBuffer) {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	buffer.flushTimer = nil
+	if buffer.done {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), backgroundWriteTimeout)
+	defer cancel()
+	delta := buffer.content.String()[buffer.flushed:]
+	err := s.conversationRepo.AppendMessageContent(ctx, buffer.conversationID, buffer.messageID, delta, buffer.status, nil, time.Now())
+	switch {
+	case errors.Is(err, repository.ErrMessageNotFound):
+		s.closeStream(buffer)
+	case err != nil:
+		log.Printf("failed to save streaming message %s: %v", buffer.messageID, err)
+	default:
+		buffer.flushed = buffer.content.Len()
+	}
+}
+
+// expireStream marks a streaming message that stopped receiving chunks as
+// failed
+func (s *ConversationService) expireStream(buffer *streamBuffer) {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	if buffer.done {
+		return
+	}
+	if idle := time.Since(buffer.lastChunk); idle < s.streams.IdleTimeout {
+		buffer.idleTimer.Reset(s.streams.IdleTimeout - idle)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), backgroundWriteTimeout)
+	defer cancel()
+	if err := s.finishStream(ctx, buffer, "", constants.MessageStatusFailed, nil); err != nil && !errors.Is(err, ErrMessageNotStreaming) {
+		log.Printf("failed to expire streaming message %s: %v", buffer.messageID, err)
+		buffer.idleTimer.Reset(s.streams.IdleTimeout)
+	}
+}
+
+// finishStream saves the unsaved content of a streaming message with its
+// last chunk, its final status and, unless nil, its metadata, and only then
+// publishes the chunk and the end of the stream, so that a failed save can
+// be retried with the same chunk. The caller holds buffer.mu.
+func (s *ConversationService) finishStream(ctx context.Context, buffer *streamBuffer, content, status string, metadata *string) error {
+	delta := buffer.content.String()[buffer.flushed:] + content
+	err := s.conversationRepo.AppendMessageContent(ctx, buffer.conversationID, buffer.messageID, delta, status, metadata, time.Now())
+	if errors.Is(err, repository.ErrMessageNotFound) {
+		// Deleted or redacted while streaming
+		s.closeStream(buffer)
+		return ErrMessageNotStreaming
+	}
+	if err != nil {
+		return err
+	}
+
+	s.appendToBuffer(buffer, content)
+	buffer.flushed = buffer.content.Len()
+	buffer.status = status
+	s.closeStream(buffer)
+	s.broker.publish(buffer.conversationID, StreamEvent{Name: StreamEventStatus, Data: dto.StreamStatusEvent{
+		MessageID: buffer.messageID,
+		Status:    status,
+	}})
+	return nil
+}
+
+// closeStream stops buffering a message. The caller holds buffer.mu.
+func (s *ConversationService) closeStream(buffer *streamBuffer) {
+	buffer.done = true
+	if buffer.flushTimer != nil {
+		buffer.flushTimer.Stop()
+		buffer.flushTimer = nil
+	}
+	if buffer.idleTimer != nil {
+		buffer.idleTimer.Stop()
+	}
+
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	if s.buffers[buffer.messageID] == buffer {
+		delete(s.buffers, buffer.messageID)
+	}
+}
+
+// staleBefore returns the time before which an open message must have been
+// saved to still be streaming
+func (s *ConversationService) staleBefore() time.Time {
+	return time.Now().Add(-s.streams.IdleTimeout)
+}
+
+// isStale reports whether an open message was abandoned: not saved for longer
+// than the idle timeout
+func (s *ConversationService) isStale(message *models.Message) bool {
+	return constants.IsOpenMessageStatus(message.Status) &&
+		(message.StreamedAt == nil || message.StreamedAt.Before(s.staleBefore()))
+}
+
+// publishMessage tells the subscribers of a conversation about a new message
+func (s *ConversationService) publishMessage(message *models.Message) {
+	s.broker.publish(message.ConversationID, StreamEvent{Name: StreamEventMessage, Data: dto.StreamMessageEvent{
+		MessageID:       message.MessageID,
+		ParentMessageID: message.ParentMessageID,
+		Role:            message.Sender,
+		Status:          message.Status,
+		Message:         message.Content,
+		Timestamp:       message.Timestamp,
+	}})
+}
+
+// validateStatus checks the status a new message is added with. Only AI
+// messages can be opened for streaming, with plain content; every other
+// message needs content.
+func validateStatus(req *dto.AddMessageRequest) error {
+	if req.Status == constants.MessageStatusPending {
+		if req.Sender != constants.SenderRoleAI {
+			return errStatusNotAI
+		}
+		if len(req.Parts) > 0 {
+			return errPartsPending
+		}
+		return nil
+	}
+	if req.Message == "" && len(req.ToolCalls) == 0 && len(req.Parts) == 0 {
+		return errMessageRequired
+	}
+	return nil
+}
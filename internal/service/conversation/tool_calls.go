@@ -0,0 +1,122 @@
+package conversation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"user_service/internal/apperrors"
+	"user_service/internal/constants"
+	dto "user_service/internal/dto/conversation"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// MaxToolCallsBytes limits the encoded tool calls of a message
+const MaxToolCallsBytes = 16384
+
+var (
+	errToolCallsNotAI = apperrors.Validation("request validation failed",
+		apperrors.FieldError{Field: "tool_calls", Message: "is only allowed for ai messages"})
+	errToolCallIDRequired = apperrors.Validation("request validation failed",
+		apperrors.FieldError{Field: "tool_call_id", Message: "is required for tool messages"})
+	errToolCallIDNotTool = apperrors.Validation("request validation failed",
+		apperrors.FieldError{Field: "tool_call_id", Message: "is only allowed for tool messages"})
+)
+
+// validateToolFields checks the tool calls and tool call ID given for a new
+// message. Only AI messages request tool calls, and every tool message
+// answers one.
+func validateToolFields(sender string, calls []dto.ToolCall, callID string) error {
+	if len(calls) > 0 && sender != constants.SenderRoleAI {
+		return errToolCallsNotAI
+	}
+	switch {
+	case sender == constants.SenderRoleTool && callID == "":
+		return errToolCallIDRequired
+	case sender != constants.SenderRoleTool && callID != "":
+		return errToolCallIDNotTool
+	}
+
+	seen := make(map[string]bool, len(calls))
+	for _, call := range calls {
+		if seen[call.ID] {
+			return validationError("tool_calls", fmt.Sprintf("duplicate call id %q", call.ID))
+		}
+		seen[call.ID] = true
+		var arguments map[string]interface{}
+		if json.Unmarshal(call.Arguments, &arguments) != nil || arguments == nil {
+			return validationError("tool_calls", fmt.Sprintf("arguments of call %q must be a JSON object", call.ID))
+		}
+	}
+	if len(marshalJSON(calls)) > MaxToolCallsBytes {
+		return validationError("tool_calls", fmt.Sprintf("must be at most %d bytes", MaxToolCallsBytes))
+	}
+	return nil
+}
+
+// encodeToolCalls returns the stored form of tool calls, or nil if there are
+// none
+func encodeToolCalls(calls []dto.ToolCall) *string {
+	if len(calls) == 0 {
+		return nil
+	}
+	// Arguments are compacted by the encoder, as jsonb would store them
+	encoded := string(marshalJSON(calls))
+	return &encoded
+}
+
+// decodeToolCalls reads the tool calls of a message. Calls that do not parse
+// are treated as absent.
+func decodeToolCalls(message *models.Message) []dto.ToolCall {
+	var calls []dto.ToolCall
+	if message.ToolCalls != nil {
+		_ = json.Unmarshal([]byte(*message.ToolCalls), &calls)
+	}
+	return calls
+}
+
+// findToolCall finds the call a new tool message replying to parentID
+// answers, and returns the name of its tool. Tool results reply to the AI
+// message with the call, or to another result of that message, so the call
+// is searched up the chain of results. Calls that already have a result on
+// the chain are skipped.
+func (s *ConversationService) findToolCall(ctx context.Context, conversationID uuid.UUID, parentID *uuid.UUID, callID string) (string, error) {
+	answered := make(map[string]bool)
+	for id := parentID; id != nil; {
+		parent, err := s.conversationRepo.GetMessage(ctx, conversationID, *id)
+		if errors.Is(err, repository.ErrMessageNotFound) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch parent.Sender {
+		case constants.SenderRoleTool:
+			if parent.ToolCallID != nil {
+				answered[*parent.ToolCallID] = true
+			}
+			id = parent.ParentMessageID
+			continue
+		case constants.SenderRoleAI:
+			for _, call := range decodeToolCalls(parent) {
+				if call.ID == callID && !answered[callID] {
+					return call.Name, nil
+				}
+			}
+		}
+		break
+	}
+	return "", ErrToolCallNotFound
+}
+
+// stringValue returns the value of an optional string, or "" if it is nil
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
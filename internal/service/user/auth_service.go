@@ -1,37 +1,79 @@
 package service
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"os"
+	"strings"
 	"time"
+	"user_service/internal/constants"
 	dto "user_service/internal/dto/user"
+	"user_service/internal/mailer"
 	"user_service/internal/models"
 	"user_service/internal/repository"
+	"user_service/internal/throttle"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // AuthService handles authentication logic
 type AuthService struct {
-	userRepo *repository.UserRepository
+	userRepo          repository.UserRepository
+	refreshTokenRepo  *repository.RefreshTokenRepository
+	revokedTokenRepo  *repository.RevokedTokenRepository
+	passwordResetRepo *repository.PasswordResetTokenRepository
+	verifier          *EmailVerifier
+	mfaRecoveryRepo   *repository.MFARecoveryCodeRepository
+	transactor        repository.Transactor
+	lockout           *throttle.Lockout
+	mailer            mailer.Mailer
+	appBaseURL        string
 }
 
 // NewAuthService creates a new authentication service
-func NewAuthService(userRepo *repository.UserRepository) *AuthService {
-	return &AuthService{userRepo: userRepo}
+func NewAuthService(
+	userRepo repository.UserRepository,
+	refreshTokenRepo *repository.RefreshTokenRepository,
+	revokedTokenRepo *repository.RevokedTokenRepository,
+	passwordResetRepo *repository.PasswordResetTokenRepository,
+	verifier *EmailVerifier,
+	mfaRecoveryRepo *repository.MFARecoveryCodeRepository,
+	transactor repository.Transactor,
+	lockout *throttle.Lockout,
+	mail mailer.Mailer,
+	appBaseURL string,
+) *AuthService {
+	return &AuthService{
+		userRepo:          userRepo,
+		refreshTokenRepo:  refreshTokenRepo,
+		revokedTokenRepo:  revokedTokenRepo,
+		passwordResetRepo: passwordResetRepo,
+		verifier:          verifier,
+		mfaRecoveryRepo:   mfaRecoveryRepo,
+		transactor:        transactor,
+		lockout:           lockout,
+		mailer:            mail,
+		appBaseURL:        appBaseURL,
+	}
 }
 
 // Register creates a new user account
-func (s *AuthService) Register(req *dto.RegisterRequest) (*dto.AuthResponse, error) {
+func (s *AuthService) Register(ctx context.Context, req *dto.RegisterRequest) (*dto.AuthResponse, error) {
 	// Check if email already exists
-	if s.userRepo.EmailExists(req.Email) {
-		return nil, errors.New("email already exists")
+	if s.userRepo.EmailExists(ctx, req.Email) {
+		return nil, ErrEmailExists
 	}
 
 	// Check if username already exists
-	if s.userRepo.UsernameExists(req.Username) {
-		return nil, errors.New("username already exists")
+	if s.userRepo.UsernameExists(ctx, req.Username) {
+		return nil, ErrUsernameExists
 	}
 
 	// Hash password
@@ -47,98 +89,247 @@ func (s *AuthService) Register(req *dto.RegisterRequest) (*dto.AuthResponse, err
 		Password:  string(hashedPassword),
 		FirstName: req.FirstName,
 		LastName:  req.LastName,
+		Role:      constants.UserRoleUser,
 	}
 
-	if err := s.userRepo.Create(user); err != nil {
+	if err := s.userRepo.Create(ctx, user); err != nil {
 		return nil, err
 	}
 
-	// Generate JWT token
-	token, err := s.generateJWT(user)
-	if err != nil {
+	if err := s.verifier.SendVerification(ctx, user); err != nil {
 		return nil, err
 	}
 
-	// Convert to response
-	userResponse := &dto.UserResponse{
-		UserID:    user.UserID,
-		Email:     user.Email,
-		Username:  user.Username,
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
-		CreatedAt: int64(user.CreatedAt.Unix()),
-		UpdatedAt: int64(user.UpdatedAt.Unix()),
+	// No session until the address is verified, if deployments require it
+	if s.verifier.RequiredForLogin() {
+		return &dto.AuthResponse{User: toUserResponse(user)}, nil
 	}
 
-	return &dto.AuthResponse{
-		Token: token,
-		User:  *userResponse,
-	}, nil
+	// Issue access and refresh tokens for a new session
+	response, _, err := s.issueTokens(ctx, user, uuid.New().String())
+	return response, err
 }
 
-// Login authenticates a user and returns a JWT token
-func (s *AuthService) Login(req *dto.LoginRequest) (*dto.AuthResponse, error) {
+// Login authenticates a user and returns an access and refresh token
+func (s *AuthService) Login(ctx context.Context, req *dto.LoginRequest) (*dto.AuthResponse, error) {
+	// Refuse locked accounts before doing any password work. Unknown emails are
+	// tracked the same way so lockouts do not reveal which accounts exist.
+	lockoutKey := accountLockoutKey(req.Email)
+	if err := s.checkLockout(ctx, lockoutKey); err != nil {
+		return nil, err
+	}
+
 	// Find user by email
-	user, err := s.userRepo.GetByEmail(req.Email)
+	user, err := s.userRepo.GetByEmail(ctx, req.Email)
 	if err != nil {
-		return nil, errors.New("invalid credentials")
+		return nil, s.recordFailure(ctx, lockoutKey, ErrInvalidCredentials)
 	}
 
 	// Check password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		return nil, errors.New("invalid credentials")
+		return nil, s.recordFailure(ctx, lockoutKey, ErrInvalidCredentials)
+	}
+
+	if s.verifier.RequiredForLogin() && user.EmailVerifiedAt == nil {
+		return nil, ErrEmailNotVerified
+	}
+
+	// Second factor required: hand out a short-lived challenge instead of tokens
+	if user.MFAEnabledAt != nil {
+		mfaToken, err := s.generateMFAChallenge(user)
+		if err != nil {
+			return nil, err
+		}
+		return &dto.AuthResponse{MFARequired: true, MFAToken: mfaToken}, nil
+	}
+
+	if err := s.lockout.Succeed(ctx, lockoutKey); err != nil {
+		return nil, err
+	}
+
+	// Issue access and refresh tokens for a new session
+	response, _, err := s.issueTokens(ctx, user, uuid.New().String())
+	return response, err
+}
+
+// Refresh rotates a refresh token and returns a new access and refresh token.
+// Presenting a token that has already been rotated is treated as token theft
+// and revokes every token in the same family.
+func (s *AuthService) Refresh(ctx context.Context, req *dto.RefreshRequest) (*dto.AuthResponse, error) {
+	// Look up the stored token by hash
+	stored, err := s.refreshTokenRepo.GetByHash(ctx, hashToken(req.RefreshToken))
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// Reuse of a rotated or revoked token: revoke the whole family
+	if stored.RevokedAt != nil {
+		if err := s.refreshTokenRepo.RevokeFamily(ctx, stored.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrRefreshTokenExpired
 	}
 
-	// Generate JWT token
-	token, err := s.generateJWT(user)
+	user, err := s.userRepo.GetByID(ctx, stored.UserID)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// Revoke the presented token and issue its successor atomically, so that a
+	// failure cannot leave the session without a usable refresh token
+	var response *dto.AuthResponse
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		// Losing the race to revoke means the token was reused
+		revoked, err := s.refreshTokenRepo.Revoke(ctx, stored.TokenID)
+		if err != nil {
+			return err
+		}
+		if !revoked {
+			return ErrRefreshTokenReused
+		}
+
+		// Issue the next token in the same family
+		var newTokenID uint
+		response, newTokenID, err = s.issueTokens(ctx, user, stored.FamilyID)
+		if err != nil {
+			return err
+		}
+
+		return s.refreshTokenRepo.SetReplacedBy(ctx, stored.TokenID, newTokenID)
+	})
+	if errors.Is(err, ErrRefreshTokenReused) {
+		// Revoke the family outside the rolled back transaction
+		if err := s.refreshTokenRepo.RevokeFamily(ctx, stored.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	// Convert to response
-	userResponse := &dto.UserResponse{
+	return response, nil
+}
+
+// VerifyEmail confirms an email address using a verification token
+func (s *AuthService) VerifyEmail(ctx context.Context, req *dto.VerifyEmailRequest) (*dto.UserResponse, error) {
+	user, err := s.verifier.Verify(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+	return toUserResponse(user), nil
+}
+
+// ResendVerification sends a new verification link. Like ForgotPassword, it
+// succeeds whether or not the account exists.
+func (s *AuthService) ResendVerification(ctx context.Context, req *dto.ResendVerificationRequest) error {
+	user, err := s.userRepo.GetByEmail(ctx, req.Email)
+	if err != nil {
+		return nil
+	}
+	return s.verifier.SendVerification(ctx, user)
+}
+
+// Logout revokes the access token described by claims and, if given, the
+// refresh token family it was issued with
+func (s *AuthService) Logout(ctx context.Context, claims *dto.Claims, req *dto.LogoutRequest) error {
+	// Add the access token to the denylist until it expires
+	revoked := &models.RevokedToken{
+		JTI:       claims.ID,
+		UserID:    claims.UserID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}
+	if err := s.revokedTokenRepo.Create(ctx, revoked); err != nil {
+		return err
+	}
+
+	// Opportunistically drop denylist entries that can no longer match
+	if err := s.revokedTokenRepo.DeleteExpired(ctx); err != nil {
+		return err
+	}
+
+	if req.RefreshToken == "" {
+		return nil
+	}
+
+	// Revoke the refresh token family, but only if it belongs to the caller
+	stored, err := s.refreshTokenRepo.GetByHash(ctx, hashToken(req.RefreshToken))
+	if err != nil || stored.UserID != claims.UserID {
+		return nil
+	}
+	return s.refreshTokenRepo.RevokeFamily(ctx, stored.FamilyID)
+}
+
+// RevokeAllSessions invalidates every access and refresh token issued to a user.
+// It is used for "log out everywhere" and after credential changes.
+func (s *AuthService) RevokeAllSessions(ctx context.Context, userID uint) error {
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.userRepo.IncrementTokenVersion(ctx, userID); err != nil {
+			return err
+		}
+		return s.refreshTokenRepo.RevokeAllForUser(ctx, userID)
+	})
+}
+
+// issueTokens creates an access token and a refresh token in the given family.
+// It also returns the ID of the stored refresh token.
+func (s *AuthService) issueTokens(ctx context.Context, user *models.User, familyID string) (*dto.AuthResponse, uint, error) {
+	// Generate JWT access token
+	token, expiresAt, err := s.generateJWT(user)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Generate opaque refresh token
+	refreshToken, err := generateOpaqueToken()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	stored := &models.RefreshToken{
 		UserID:    user.UserID,
-		Email:     user.Email,
-		Username:  user.Username,
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
-		CreatedAt: int64(user.CreatedAt.Unix()),
-		UpdatedAt: int64(user.UpdatedAt.Unix()),
+		FamilyID:  familyID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: time.Now().Add(getDurationEnv("REFRESH_TOKEN_EXPIRY", 30*24*time.Hour)),
+	}
+	if err := s.refreshTokenRepo.Create(ctx, stored); err != nil {
+		return nil, 0, err
 	}
 
 	return &dto.AuthResponse{
-		Token: token,
-		User:  *userResponse,
-	}, nil
+		Token:            token,
+		ExpiresAt:        expiresAt.Unix(),
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: stored.ExpiresAt.Unix(),
+		User:             toUserResponse(user),
+	}, stored.TokenID, nil
 }
 
-// generateJWT creates a JWT token for a user
-func (s *AuthService) generateJWT(user *models.User) (string, error) {
+// generateJWT creates a short-lived JWT access token for a user
+func (s *AuthService) generateJWT(user *models.User) (string, time.Time, error) {
 	// Get JWT secret from environment
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
-		return "", errors.New("JWT_SECRET not configured")
+		return "", time.Time{}, errors.New("JWT_SECRET not configured")
 	}
 
-	// Get JWT expiry from environment (default: 24h)
-	jwtExpiry := os.Getenv("JWT_EXPIRY")
-	if jwtExpiry == "" {
-		jwtExpiry = "24h"
-	}
-
-	// Parse expiry duration
-	expiryDuration, err := time.ParseDuration(jwtExpiry)
-	if err != nil {
-		expiryDuration = 24 * time.Hour
-	}
+	// Get JWT expiry from environment (default: 15m)
+	expiresAt := time.Now().Add(getDurationEnv("JWT_EXPIRY", 15*time.Minute))
 
 	// Create claims
 	claims := &dto.Claims{
-		UserID:   user.UserID,
-		Email:    user.Email,
-		Username: user.Username,
+		UserID:       user.UserID,
+		Email:        user.Email,
+		Username:     user.Username,
+		Role:         user.Role,
+		TokenVersion: user.TokenVersion,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(30 * expiryDuration)),
+			ID:        uuid.New().String(),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 			Issuer:    "user_service",
@@ -152,14 +343,14 @@ func (s *AuthService) generateJWT(user *models.User) (string, error) {
 	// Sign token
 	tokenString, err := token.SignedString([]byte(jwtSecret))
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
 
-	return tokenString, nil
+	return tokenString, expiresAt, nil
 }
 
 // ValidateJWT validates a JWT token and returns the claims
-func (s *AuthService) ValidateJWT(tokenString string) (*dto.Claims, error) {
+func (s *AuthService) ValidateJWT(ctx context.Context, tokenString string) (*dto.Claims, error) {
 	// Get JWT secret from environment
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
@@ -180,9 +371,99 @@ func (s *AuthService) ValidateJWT(tokenString string) (*dto.Claims, error) {
 	}
 
 	// Extract claims
-	if claims, ok := token.Claims.(*dto.Claims); ok && token.Valid {
-		return claims, nil
+	claims, ok := token.Claims.(*dto.Claims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	// Reject tokens that were explicitly logged out
+	revoked, err := s.revokedTokenRepo.IsRevoked(ctx, claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+
+	// Reject tokens for deleted users or issued before a session reset
+	user, err := s.userRepo.GetByID(ctx, claims.UserID)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if user.TokenVersion != claims.TokenVersion {
+		return nil, ErrTokenRevoked
+	}
+	claims.EmailVerified = user.EmailVerifiedAt != nil
+	// Role changes take effect immediately rather than when the token expires
+	claims.Role = user.Role
+
+	return claims, nil
+}
+
+// generateOpaqueToken returns a random, URL-safe opaque token
+func generateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.New("failed to generate token")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hex-encoded SHA-256 hash of an opaque token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkLockout returns a locked error while key is locked out
+func (s *AuthService) checkLockout(ctx context.Context, key string) error {
+	remaining, err := s.lockout.Check(ctx, key)
+	if err != nil {
+		return err
 	}
+	if remaining > 0 {
+		return accountLocked(remaining)
+	}
+	return nil
+}
 
-	return nil, errors.New("invalid token")
+// recordFailure counts a failed attempt against key and returns cause, or a
+// locked error if this failure triggered a lockout
+func (s *AuthService) recordFailure(ctx context.Context, key string, cause error) error {
+	locked, err := s.lockout.Fail(ctx, key)
+	if err != nil {
+		return err
+	}
+	if locked > 0 {
+		return accountLocked(locked)
+	}
+	return cause
+}
+
+// accountLockoutKey returns the throttle key for an account
+func accountLockoutKey(email string) string {
+	return fmt.Sprintf("account:%s", strings.ToLower(strings.TrimSpace(email)))
+}
+
+// purposeKey derives a signing key for a non-access token purpose from
+// JWT_SECRET, so that such tokens can never be accepted as access tokens
+func purposeKey(purpose string) ([]byte, error) {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return nil, errors.New("JWT_SECRET not configured")
+	}
+	return []byte(jwtSecret + ":" + purpose), nil
+}
+
+// getDurationEnv reads a duration from the environment, falling back to defaultValue
+func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultValue
+	}
+	return duration
 }
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"user_service/config"
+	"user_service/internal/apperrors"
+	"user_service/internal/database"
+	dto "user_service/internal/dto/user"
+	"user_service/internal/mailer"
+	"user_service/internal/repository"
+	"user_service/internal/throttle"
+)
+
+var ctx = context.Background()
+
+// testPassword is the password of the accounts created by register
+const testPassword = "secret123"
+
+// testMaxFailures is the number of failures that locks an account
+const testMaxFailures = 3
+
+// testEnv is an AuthService on a fresh in-memory SQLite database
+type testEnv struct {
+	auth  *AuthService
+	users repository.UserRepository
+	mail  *recordingMailer
+}
+
+func newTestEnv(t *testing.T, emailVerification string) *testEnv {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	db, err := database.InitDB(&config.Config{DatabaseURL: ":memory:"})
+	if err != nil {
+		t.Fatalf("failed to open SQLite database: %v", err)
+	}
+	users := repository.NewUserRepository(db)
+	mail := &recordingMailer{}
+	verifier := NewEmailVerifier(users, mail, "http://app.test", emailVerification)
+	lockout := throttle.NewLockout(throttle.NewMemoryStore(), testMaxFailures, time.Minute, time.Hour)
+
+	return &testEnv{
+		auth: NewAuthService(users,
+			repository.NewRefreshTokenRepository(db),
+			repository.NewRevokedTokenRepository(db),
+			repository.NewPasswordResetTokenRepository(db),
+			verifier,
+			repository.NewMFARecoveryCodeRepository(db),
+			repository.NewTransactor(db),
+			lockout, mail, "http://app.test"),
+		users: users,
+		mail:  mail,
+	}
+}
+
+// register creates an account named after the local part of email
+func (e *testEnv) register(t *testing.T, email string) *dto.AuthResponse {
+	t.Helper()
+	response, err := e.auth.Register(ctx, &dto.RegisterRequest{
+		Email:     email,
+		Username:  strings.Split(email, "@")[0],
+		Password:  testPassword,
+		FirstName: "Test",
+		LastName:  "User",
+	})
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	return response
+}
+
+// login logs in with the test password and expects tokens
+func (e *testEnv) login(t *testing.T, email string) *dto.AuthResponse {
+	t.Helper()
+	response, err := e.auth.Login(ctx, &dto.LoginRequest{Email: email, Password: testPassword})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if response.Token == "" || response.RefreshToken == "" {
+		t.Fatalf("Login: got %+v, want tokens", response)
+	}
+	return response
+}
+
+// recordingMailer keeps the messages sent through it
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (m *recordingMailer) Send(msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+var linkToken = regexp.MustCompile(`token=(\S+)`)
+
+// token returns the token of the last link to path that was sent
+func (m *recordingMailer) token(t *testing.T, path string) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if !strings.Contains(m.messages[i].Body, path+"?") {
+			continue
+		}
+		match := linkToken.FindStringSubmatch(m.messages[i].Body)
+		if match == nil {
+			break
+		}
+		token, err := url.QueryUnescape(match[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	t.Fatalf("no link to %s was sent", path)
+	return ""
+}
+
+// assertCode checks that err is an application error with the given code
+func assertCode(t *testing.T, err error, code string) {
+	t.Helper()
+	if appErr := apperrors.As(err); appErr == nil || appErr.Code != code {
+		t.Fatalf("got error %v, want code %s", err, code)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	t.Run("RotatesToken", func(t *testing.T) {
+		env := newTestEnv(t, EmailVerificationOff)
+		first := env.register(t, "alice@example.com")
+
+		second, err := env.auth.Refresh(ctx, &dto.RefreshRequest{RefreshToken: first.RefreshToken})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if second.RefreshToken == first.RefreshToken || second.Token == "" {
+			t.Fatalf("got %+v, want a new token pair", second)
+		}
+		if _, err := env.auth.Refresh(ctx, &dto.RefreshRequest{RefreshToken: second.RefreshToken}); err != nil {
+			t.Fatalf("refreshing the successor: %v", err)
+		}
+	})
+
+	t.Run("ReplayRevokesFamily", func(t *testing.T) {
+		env := newTestEnv(t, EmailVerificationOff)
+		first := env.register(t, "alice@example.com")
+		other := env.login(t, "alice@example.com")
+
+		second, err := env.auth.Refresh(ctx, &dto.RefreshRequest{RefreshToken: first.RefreshToken})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := env.auth.Refresh(ctx, &dto.RefreshRequest{RefreshToken: first.RefreshToken}); !errors.Is(err, ErrRefreshTokenReused) {
+			t.Fatalf("replay: got %v, want ErrRefreshTokenReused", err)
+		}
+		if _, err := env.auth.Refresh(ctx, &dto.RefreshRequest{RefreshToken: second.RefreshToken}); !errors.Is(err, ErrRefreshTokenReused) {
+			t.Fatalf("successor after replay: got %v, want ErrRefreshTokenReused", err)
+		}
+
+		// Other sessions are separate families
+		if _, err := env.auth.Refresh(ctx, &dto.RefreshRequest{RefreshToken: other.RefreshToken}); err != nil {
+			t.Fatalf("other session: %v", err)
+		}
+	})
+
+	t.Run("UnknownToken", func(t *testing.T) {
+		env := newTestEnv(t, EmailVerificationOff)
+		if _, err := env.auth.Refresh(ctx, &dto.RefreshRequest{RefreshToken: "unknown"}); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Fatalf("got %v, want ErrInvalidRefreshToken", err)
+		}
+	})
+}
+
+func TestLogoutDeniesAccessToken(t *testing.T) {
+	env := newTestEnv(t, EmailVerificationOff)
+	session := env.register(t, "alice@example.com")
+	other := env.login(t, "alice@example.com")
+
+	claims, err := env.auth.ValidateJWT(ctx, session.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.auth.Logout(ctx, claims, &dto.LogoutRequest{RefreshToken: session.RefreshToken}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := env.auth.ValidateJWT(ctx, session.Token); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("logged out access token: got %v, want ErrTokenRevoked", err)
+	}
+	if _, err := env.auth.Refresh(ctx, &dto.RefreshRequest{RefreshToken: session.RefreshToken}); err == nil {
+		t.Fatal("logged out refresh token was accepted")
+	}
+
+	// Only the session that logged out ends
+	if _, err := env.auth.ValidateJWT(ctx, other.Token); err != nil {
+		t.Fatalf("other session: %v", err)
+	}
+}
+
+func TestEmailVerificationGate(t *testing.T) {
+	env := newTestEnv(t, EmailVerificationLogin)
+
+	registered := env.register(t, "alice@example.com")
+	if registered.Token != "" || registered.RefreshToken != "" {
+		t.Fatal("registration issued tokens before the email was verified")
+	}
+	_, err := env.auth.Login(ctx, &dto.LoginRequest{Email: "alice@example.com", Password: testPassword})
+	if !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("unverified login: got %v, want ErrEmailNotVerified", err)
+	}
+
+	if _, err := env.auth.VerifyEmail(ctx, &dto.VerifyEmailRequest{Token: "invalid"}); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Fatalf("invalid token: got %v, want ErrInvalidVerificationToken", err)
+	}
+	verified, err := env.auth.VerifyEmail(ctx, &dto.VerifyEmailRequest{Token: env.mail.token(t, "/verify-email")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verified.EmailVerified {
+		t.Fatalf("got %+v, want a verified email", verified)
+	}
+
+	session := env.login(t, "alice@example.com")
+	claims, err := env.auth.ValidateJWT(ctx, session.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !claims.EmailVerified {
+		t.Fatal("claims do not report the verified email")
+	}
+}
+
+func TestLockout(t *testing.T) {
+	t.Run("LocksAfterMaxFailures", func(t *testing.T) {
+		env := newTestEnv(t, EmailVerificationOff)
+		env.register(t, "alice@example.com")
+
+		wrong := &dto.LoginRequest{Email: "alice@example.com", Password: "wrong-password"}
+		for i := 1; i < testMaxFailures; i++ {
+			if _, err := env.auth.Login(ctx, wrong); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("failure %d: got %v, want ErrInvalidCredentials", i, err)
+			}
+		}
+		_, err := env.auth.Login(ctx, wrong)
+		assertCode(t, err, "account_locked")
+		if appErr := apperrors.As(err); appErr.RetryAfter <= 0 {
+			t.Fatal("locked error does not say when to retry")
+		}
+
+		// The right password does not get through while locked
+		_, err = env.auth.Login(ctx, &dto.LoginRequest{Email: "alice@example.com", Password: testPassword})
+		assertCode(t, err, "account_locked")
+	})
+
+	t.Run("SuccessResetsFailures", func(t *testing.T) {
+		env := newTestEnv(t, EmailVerificationOff)
+		env.register(t, "alice@example.com")
+
+		wrong := &dto.LoginRequest{Email: "alice@example.com", Password: "wrong-password"}
+		for round := 0; round < 2; round++ {
+			for i := 1; i < testMaxFailures; i++ {
+				if _, err := env.auth.Login(ctx, wrong); !errors.Is(err, ErrInvalidCredentials) {
+					t.Fatalf("round %d, failure %d: got %v, want ErrInvalidCredentials", round, i, err)
+				}
+			}
+			env.login(t, "alice@example.com")
+		}
+	})
+
+	t.Run("UnknownEmailsAreLockedToo", func(t *testing.T) {
+		env := newTestEnv(t, EmailVerificationOff)
+
+		var err error
+		for i := 0; i < testMaxFailures; i++ {
+			_, err = env.auth.Login(ctx, &dto.LoginRequest{Email: "nobody@example.com", Password: testPassword})
+		}
+		assertCode(t, err, "account_locked")
+	})
+}
+
+func TestRevokeAllSessionsSurvivesStaleUpdate(t *testing.T) {
+	env := newTestEnv(t, EmailVerificationOff)
+	session := env.register(t, "alice@example.com")
+
+	// A profile update loaded before the sessions were revoked
+	stale, err := env.users.GetByID(ctx, session.User.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.auth.RevokeAllSessions(ctx, stale.UserID); err != nil {
+		t.Fatal(err)
+	}
+	stale.FirstName = "Alicia"
+	if err := env.users.Update(ctx, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := env.auth.ValidateJWT(ctx, session.Token); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("revoked access token: got %v, want ErrTokenRevoked", err)
+	}
+}
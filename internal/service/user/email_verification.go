@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+	"user_service/internal/mailer"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Email verification modes
+const (
+	EmailVerificationOff           = "off"
+	EmailVerificationConversations = "conversations"
+	EmailVerificationLogin         = "login"
+)
+
+// emailVerificationClaims are the claims of a signed verification link
+type emailVerificationClaims struct {
+	UserID uint   `json:"user_id"`
+	Email  string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// EmailVerifier sends and checks signed email verification links
+type EmailVerifier struct {
+	userRepo   repository.UserRepository
+	mailer     mailer.Mailer
+	appBaseURL string
+	mode       string
+}
+
+// NewEmailVerifier creates a new email verifier
+func NewEmailVerifier(userRepo repository.UserRepository, mail mailer.Mailer, appBaseURL string, mode string) *EmailVerifier {
+	return &EmailVerifier{
+		userRepo:   userRepo,
+		mailer:     mail,
+		appBaseURL: appBaseURL,
+		mode:       mode,
+	}
+}
+
+// RequiredForLogin reports whether unverified accounts are blocked from logging in
+func (v *EmailVerifier) RequiredForLogin() bool {
+	return v.mode == EmailVerificationLogin
+}
+
+// RequiredForConversations reports whether unverified accounts are blocked from conversation routes
+func (v *EmailVerifier) RequiredForConversations() bool {
+	return v.mode == EmailVerificationLogin || v.mode == EmailVerificationConversations
+}
+
+// SendVerification emails a verification link for the user's pending email
+// change, or for their current email if it has not been verified yet.
+// Delivery failures are logged rather than returned.
+func (v *EmailVerifier) SendVerification(ctx context.Context, user *models.User) error {
+	address := user.Email
+	if user.PendingEmail != nil {
+		address = *user.PendingEmail
+	} else if user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	token, err := v.sign(user.UserID, address)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", v.appBaseURL, url.QueryEscape(token))
+	msg := mailer.Message{
+		To:      address,
+		Subject: "Verify your email address",
+		Body:    fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below.\n\n%s\n", user.FirstName, link),
+	}
+
+	if err := v.mailer.Send(msg); err != nil {
+		log.Printf("failed to send verification email to user %d: %v", user.UserID, err)
+	}
+	return nil
+}
+
+// Verify checks a verification token and marks the address it was issued for
+// as verified, applying a pending email change if there is one
+func (v *EmailVerifier) Verify(ctx context.Context, tokenString string) (*models.User, error) {
+	claims, err := v.parse(tokenString)
+	if err != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	user, err := v.userRepo.GetByID(ctx, claims.UserID)
+	if err != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	now := time.Now()
+	switch {
+	case user.PendingEmail != nil && *user.PendingEmail == claims.Email:
+		// The new address may have been taken since the change was requested
+		if v.userRepo.EmailExists(ctx, claims.Email) {
+			return nil, ErrEmailExists
+		}
+		user.Email = claims.Email
+		user.PendingEmail = nil
+		user.EmailVerifiedAt = &now
+	case user.Email == claims.Email:
+		if user.EmailVerifiedAt != nil {
+			return user, nil
+		}
+		user.EmailVerifiedAt = &now
+	default:
+		// The link is for an address the account no longer uses
+		return nil, ErrInvalidVerificationToken
+	}
+
+	if err := v.userRepo.Update(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// sign creates a verification token for an address
+func (v *EmailVerifier) sign(userID uint, email string) (string, error) {
+	key, err := purposeKey("email-verification")
+	if err != nil {
+		return "", err
+	}
+
+	claims := &emailVerificationClaims{
+		UserID: userID,
+		Email:  email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(getDurationEnv("EMAIL_VERIFICATION_EXPIRY", 24*time.Hour))),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "user_service",
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+}
+
+// parse validates a verification token and returns its claims
+func (v *EmailVerifier) parse(tokenString string) (*emailVerificationClaims, error) {
+	key, err := purposeKey("email-verification")
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &emailVerificationClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*emailVerificationClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
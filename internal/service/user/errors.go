@@ -0,0 +1,33 @@
+package service
+
+import (
+	"time"
+	"user_service/internal/apperrors"
+)
+
+// Domain errors returned by the user and auth services
+var (
+	ErrEmailExists              = apperrors.Conflict("email_exists", "email already exists")
+	ErrUsernameExists           = apperrors.Conflict("username_exists", "username already exists")
+	ErrInvalidCredentials       = apperrors.Unauthorized("invalid_credentials", "invalid credentials")
+	ErrEmailNotVerified         = apperrors.Forbidden("email_not_verified", "email not verified")
+	ErrInvalidRefreshToken      = apperrors.Unauthorized("invalid_refresh_token", "invalid refresh token")
+	ErrRefreshTokenExpired      = apperrors.Unauthorized("refresh_token_expired", "refresh token expired")
+	ErrRefreshTokenReused       = apperrors.Unauthorized("refresh_token_reused", "refresh token reuse detected")
+	ErrInvalidToken             = apperrors.Unauthorized("invalid_token", "invalid token")
+	ErrTokenRevoked             = apperrors.Unauthorized("token_revoked", "token has been revoked")
+	ErrInvalidResetToken        = apperrors.BadRequest("invalid_reset_token", "invalid or expired reset token")
+	ErrInvalidVerificationToken = apperrors.BadRequest("invalid_verification_token", "invalid or expired verification token")
+	ErrIncorrectPassword        = apperrors.BadRequest("incorrect_password", "current password is incorrect")
+	ErrMFAAlreadyEnabled        = apperrors.Conflict("mfa_already_enabled", "mfa already enabled")
+	ErrMFAEnrollmentNotStarted  = apperrors.BadRequest("mfa_enrollment_not_started", "mfa enrollment not started")
+	ErrMFANotEnabled            = apperrors.BadRequest("mfa_not_enabled", "mfa not enabled")
+	ErrInvalidMFACode           = apperrors.Unauthorized("invalid_mfa_code", "invalid mfa code")
+	ErrIncorrectMFACode         = apperrors.BadRequest("incorrect_mfa_code", "mfa code is incorrect")
+	ErrInvalidMFAToken          = apperrors.Unauthorized("invalid_mfa_token", "invalid or expired mfa token")
+)
+
+// accountLocked returns the error reported while an account is locked out
+func accountLocked(retryAfter time.Duration) error {
+	return apperrors.Locked("account_locked", "account temporarily locked", retryAfter)
+}
@@ -0,0 +1,291 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"os"
+	"strings"
+	"time"
+	dto "user_service/internal/dto/user"
+	"user_service/internal/models"
+	"user_service/internal/totp"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// recoveryCodeCount is the number of recovery codes issued on enrollment
+const recoveryCodeCount = 10
+
+// mfaChallengeClaims are the claims of the token returned by the first login step
+type mfaChallengeClaims struct {
+	UserID       uint `json:"user_id"`
+	TokenVersion uint `json:"token_version"`
+	jwt.RegisteredClaims
+}
+
+// EnrollMFA starts TOTP enrollment by generating a new secret. The secret is
+// not enforced until ConfirmMFA succeeds.
+func (s *AuthService) EnrollMFA(ctx context.Context, userID uint) (*dto.MFAEnrollResponse, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.MFAEnabledAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	user.MFASecret = &secret
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		return nil, err
+	}
+
+	issuer := os.Getenv("MFA_ISSUER")
+	if issuer == "" {
+		issuer = "user_service"
+	}
+
+	return &dto.MFAEnrollResponse{
+		Secret:     secret,
+		OTPAuthURL: totp.URI(issuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmMFA enables MFA once the user proves their authenticator works, and
+// returns a fresh set of recovery codes
+func (s *AuthService) ConfirmMFA(ctx context.Context, userID uint, req *dto.MFACodeRequest) (*dto.MFAConfirmResponse, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.MFAEnabledAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.MFASecret == nil {
+		return nil, ErrMFAEnrollmentNotStarted
+	}
+
+	// Code guesses count towards the same lockout as password guesses
+	lockoutKey := accountLockoutKey(user.Email)
+	if err := s.checkLockout(ctx, lockoutKey); err != nil {
+		return nil, err
+	}
+
+	ok, err := s.checkTOTP(ctx, user, req.Code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, s.recordFailure(ctx, lockoutKey, ErrIncorrectMFACode)
+	}
+
+	if err := s.lockout.Succeed(ctx, lockoutKey); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	// Store the recovery codes and enable MFA together
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.mfaRecoveryRepo.Replace(ctx, userID, hashes); err != nil {
+			return err
+		}
+
+		// Reload to avoid overwriting the step recorded by checkTOTP
+		user, err := s.userRepo.GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		user.MFAEnabledAt = &now
+		return s.userRepo.Update(ctx, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.MFAConfirmResponse{RecoveryCodes: codes}, nil
+}
+
+// DisableMFA turns MFA off. A fresh TOTP or recovery code is required.
+func (s *AuthService) DisableMFA(ctx context.Context, userID uint, req *dto.MFACodeRequest) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if user.MFAEnabledAt == nil {
+		return ErrMFANotEnabled
+	}
+
+	// Code guesses count towards the same lockout as password guesses
+	lockoutKey := accountLockoutKey(user.Email)
+	if err := s.checkLockout(ctx, lockoutKey); err != nil {
+		return err
+	}
+
+	ok, err := s.checkSecondFactor(ctx, user, req.Code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return s.recordFailure(ctx, lockoutKey, ErrIncorrectMFACode)
+	}
+
+	if err := s.lockout.Succeed(ctx, lockoutKey); err != nil {
+		return err
+	}
+
+	// Drop the recovery codes and the secret together
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.mfaRecoveryRepo.DeleteAllForUser(ctx, userID); err != nil {
+			return err
+		}
+
+		user, err := s.userRepo.GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		// The last accepted step is kept: steps only move forward, so it
+		// does not get in the way of a new enrollment
+		user.MFASecret = nil
+		user.MFAEnabledAt = nil
+		return s.userRepo.Update(ctx, user)
+	})
+}
+
+// VerifyMFA completes a two-step login by exchanging an MFA challenge token
+// and a valid code for access and refresh tokens
+func (s *AuthService) VerifyMFA(ctx context.Context, req *dto.MFAVerifyRequest) (*dto.AuthResponse, error) {
+	claims, err := parseMFAChallenge(req.MFAToken)
+	if err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+
+	user, err := s.userRepo.GetByID(ctx, claims.UserID)
+	if err != nil || user.TokenVersion != claims.TokenVersion || user.MFAEnabledAt == nil {
+		return nil, ErrInvalidMFAToken
+	}
+
+	// Code guesses count towards the same lockout as password guesses
+	lockoutKey := accountLockoutKey(user.Email)
+	if err := s.checkLockout(ctx, lockoutKey); err != nil {
+		return nil, err
+	}
+
+	ok, err := s.checkSecondFactor(ctx, user, req.Code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, s.recordFailure(ctx, lockoutKey, ErrInvalidMFACode)
+	}
+
+	if err := s.lockout.Succeed(ctx, lockoutKey); err != nil {
+		return nil, err
+	}
+
+	response, _, err := s.issueTokens(ctx, user, uuid.New().String())
+	return response, err
+}
+
+// checkSecondFactor accepts either a TOTP code or an unused recovery code
+func (s *AuthService) checkSecondFactor(ctx context.Context, user *models.User, code string) (bool, error) {
+	ok, err := s.checkTOTP(ctx, user, code)
+	if err != nil || ok {
+		return ok, err
+	}
+	return s.mfaRecoveryRepo.Use(ctx, user.UserID, hashToken(normalizeRecoveryCode(code)))
+}
+
+// checkTOTP validates a TOTP code and rejects codes from an already used time step
+func (s *AuthService) checkTOTP(ctx context.Context, user *models.User, code string) (bool, error) {
+	if user.MFASecret == nil {
+		return false, nil
+	}
+
+	step, ok := totp.Validate(*user.MFASecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return s.userRepo.AdvanceMFAStep(ctx, user.UserID, step)
+}
+
+// generateMFAChallenge creates the short-lived token returned by the first login step
+func (s *AuthService) generateMFAChallenge(user *models.User) (string, error) {
+	key, err := purposeKey("mfa-challenge")
+	if err != nil {
+		return "", err
+	}
+
+	claims := &mfaChallengeClaims{
+		UserID:       user.UserID,
+		TokenVersion: user.TokenVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(5 * time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "user_service",
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+}
+
+// parseMFAChallenge validates an MFA challenge token and returns its claims
+func parseMFAChallenge(tokenString string) (*mfaChallengeClaims, error) {
+	key, err := purposeKey("mfa-challenge")
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &mfaChallengeClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*mfaChallengeClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// generateRecoveryCodes returns display codes formatted as xxxxx-xxxxx and their hashes
+func generateRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, errors.New("failed to generate recovery codes")
+		}
+		raw := strings.ToLower(encoding.EncodeToString(b))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashToken(raw)
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode strips formatting so codes can be typed loosely
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
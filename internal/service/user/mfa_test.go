@@ -0,0 +1,156 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+	dto "user_service/internal/dto/user"
+	"user_service/internal/totp"
+)
+
+// wrongCode has the shape of a TOTP code but never matches one
+const wrongCode = "abcdef"
+
+// totpCode returns the code of secret for the current time step plus offset
+func totpCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	code, err := totp.Code(secret, totp.Step(time.Now())+offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// enableMFA enrolls and confirms MFA for a user, and returns the secret and
+// the recovery codes
+func (e *testEnv) enableMFA(t *testing.T, userID uint) (string, []string) {
+	t.Helper()
+	enrollment, err := e.auth.EnrollMFA(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirmed, err := e.auth.ConfirmMFA(ctx, userID, &dto.MFACodeRequest{Code: totpCode(t, enrollment.Secret, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enrollment.Secret, confirmed.RecoveryCodes
+}
+
+// challenge logs in with the test password and expects an MFA challenge
+func (e *testEnv) challenge(t *testing.T, email string) string {
+	t.Helper()
+	response, err := e.auth.Login(ctx, &dto.LoginRequest{Email: email, Password: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.MFARequired || response.MFAToken == "" || response.Token != "" {
+		t.Fatalf("got %+v, want an MFA challenge instead of tokens", response)
+	}
+	return response.MFAToken
+}
+
+func TestMFA(t *testing.T) {
+	t.Run("EnrollAndConfirm", func(t *testing.T) {
+		env := newTestEnv(t, EmailVerificationOff)
+		user := env.register(t, "alice@example.com").User
+
+		if _, err := env.auth.ConfirmMFA(ctx, user.UserID, &dto.MFACodeRequest{Code: "123456"}); !errors.Is(err, ErrMFAEnrollmentNotStarted) {
+			t.Fatalf("confirm before enrolling: got %v, want ErrMFAEnrollmentNotStarted", err)
+		}
+		enrollment, err := env.auth.EnrollMFA(ctx, user.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := env.auth.ConfirmMFA(ctx, user.UserID, &dto.MFACodeRequest{Code: wrongCode}); !errors.Is(err, ErrIncorrectMFACode) {
+			t.Fatalf("wrong code: got %v, want ErrIncorrectMFACode", err)
+		}
+
+		// Login is unaffected until enrollment is confirmed
+		env.login(t, "alice@example.com")
+
+		confirmed, err := env.auth.ConfirmMFA(ctx, user.UserID, &dto.MFACodeRequest{Code: totpCode(t, enrollment.Secret, 0)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(confirmed.RecoveryCodes) != recoveryCodeCount {
+			t.Fatalf("got %d recovery codes, want %d", len(confirmed.RecoveryCodes), recoveryCodeCount)
+		}
+		if _, err := env.auth.EnrollMFA(ctx, user.UserID); !errors.Is(err, ErrMFAAlreadyEnabled) {
+			t.Fatalf("enroll again: got %v, want ErrMFAAlreadyEnabled", err)
+		}
+		env.challenge(t, "alice@example.com")
+	})
+
+	t.Run("RejectsReplayedCodes", func(t *testing.T) {
+		env := newTestEnv(t, EmailVerificationOff)
+		user := env.register(t, "alice@example.com").User
+		secret, _ := env.enableMFA(t, user.UserID)
+
+		// The code used to confirm cannot be used again
+		challenge := env.challenge(t, "alice@example.com")
+		replayed := &dto.MFAVerifyRequest{MFAToken: challenge, Code: totpCode(t, secret, 0)}
+		if _, err := env.auth.VerifyMFA(ctx, replayed); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("replayed code: got %v, want ErrInvalidMFACode", err)
+		}
+
+		// The next time step is still accepted, once
+		next := &dto.MFAVerifyRequest{MFAToken: challenge, Code: totpCode(t, secret, 1)}
+		session, err := env.auth.VerifyMFA(ctx, next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if session.Token == "" || session.RefreshToken == "" {
+			t.Fatalf("got %+v, want tokens", session)
+		}
+		if _, err := env.auth.VerifyMFA(ctx, next); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("replayed next code: got %v, want ErrInvalidMFACode", err)
+		}
+	})
+
+	t.Run("RecoveryCodesAreSingleUse", func(t *testing.T) {
+		env := newTestEnv(t, EmailVerificationOff)
+		user := env.register(t, "alice@example.com").User
+		_, recoveryCodes := env.enableMFA(t, user.UserID)
+
+		request := &dto.MFAVerifyRequest{MFAToken: env.challenge(t, "alice@example.com"), Code: recoveryCodes[0]}
+		if _, err := env.auth.VerifyMFA(ctx, request); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := env.auth.VerifyMFA(ctx, request); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("reused recovery code: got %v, want ErrInvalidMFACode", err)
+		}
+	})
+
+	t.Run("WrongCodesLock", func(t *testing.T) {
+		env := newTestEnv(t, EmailVerificationOff)
+		user := env.register(t, "alice@example.com").User
+		secret, _ := env.enableMFA(t, user.UserID)
+
+		request := &dto.MFAVerifyRequest{MFAToken: env.challenge(t, "alice@example.com"), Code: wrongCode}
+		for i := 1; i < testMaxFailures; i++ {
+			if _, err := env.auth.VerifyMFA(ctx, request); !errors.Is(err, ErrInvalidMFACode) {
+				t.Fatalf("failure %d: got %v, want ErrInvalidMFACode", i, err)
+			}
+		}
+		_, err := env.auth.VerifyMFA(ctx, request)
+		assertCode(t, err, "account_locked")
+
+		// Disabling MFA counts towards the same lockout
+		err = env.auth.DisableMFA(ctx, user.UserID, &dto.MFACodeRequest{Code: totpCode(t, secret, 1)})
+		assertCode(t, err, "account_locked")
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		env := newTestEnv(t, EmailVerificationOff)
+		user := env.register(t, "alice@example.com").User
+		secret, _ := env.enableMFA(t, user.UserID)
+
+		if err := env.auth.DisableMFA(ctx, user.UserID, &dto.MFACodeRequest{Code: wrongCode}); !errors.Is(err, ErrIncorrectMFACode) {
+			t.Fatalf("wrong code: got %v, want ErrIncorrectMFACode", err)
+		}
+		if err := env.auth.DisableMFA(ctx, user.UserID, &dto.MFACodeRequest{Code: totpCode(t, secret, 1)}); err != nil {
+			t.Fatal(err)
+		}
+		env.login(t, "alice@example.com")
+	})
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+	dto "user_service/internal/dto/user"
+	"user_service/internal/mailer"
+	"user_service/internal/models"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ForgotPassword emails a password reset link to the account with the given
+// email. It succeeds whether or not the account exists so that callers cannot
+// probe for registered addresses.
+func (s *AuthService) ForgotPassword(ctx context.Context, req *dto.ForgotPasswordRequest) error {
+	user, err := s.userRepo.GetByEmail(ctx, req.Email)
+	if err != nil {
+		return nil
+	}
+
+	// Only the most recent link should work
+	if err := s.passwordResetRepo.InvalidateForUser(ctx, user.UserID); err != nil {
+		return err
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	resetToken := &models.PasswordResetToken{
+		UserID:    user.UserID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(getDurationEnv("PASSWORD_RESET_EXPIRY", time.Hour)),
+	}
+	if err := s.passwordResetRepo.Create(ctx, resetToken); err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", s.appBaseURL, url.QueryEscape(token))
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires at %s.\n\n%s\n\nIf you did not request this, you can ignore this email.\n",
+			user.FirstName, resetToken.ExpiresAt.UTC().Format(time.RFC1123), link),
+	}
+
+	// A delivery failure must not reveal that the account exists
+	if err := s.mailer.Send(msg); err != nil {
+		log.Printf("failed to send password reset email to user %d: %v", user.UserID, err)
+	}
+
+	return nil
+}
+
+// ResetPassword sets a new password using a reset token and revokes every
+// existing session for the account
+func (s *AuthService) ResetPassword(ctx context.Context, req *dto.ResetPasswordRequest) error {
+	resetToken, err := s.passwordResetRepo.GetByHash(ctx, hashToken(req.Token))
+	if err != nil {
+		return ErrInvalidResetToken
+	}
+
+	if resetToken.UsedAt != nil || time.Now().After(resetToken.ExpiresAt) {
+		return ErrInvalidResetToken
+	}
+
+	// Hash password
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.New("failed to hash password")
+	}
+
+	// Consume the token and change the password together, so that a failure
+	// leaves the token usable for another attempt
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		// Losing the race to consume the token means it was already used
+		used, err := s.passwordResetRepo.MarkUsed(ctx, resetToken.TokenID)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidResetToken
+		}
+
+		if err := s.userRepo.UpdatePassword(ctx, resetToken.UserID, string(hashedPassword)); err != nil {
+			return err
+		}
+
+		return s.RevokeAllSessions(ctx, resetToken.UserID)
+	})
+}
+
+// ChangePassword sets a new password after checking the current one. All
+// existing sessions are revoked and a fresh session is returned for the caller.
+func (s *AuthService) ChangePassword(ctx context.Context, userID uint, req *dto.ChangePasswordRequest) (*dto.AuthResponse, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check current password. Guesses count towards the same lockout as
+	// failed logins.
+	lockoutKey := accountLockoutKey(user.Email)
+	if err := s.checkLockout(ctx, lockoutKey); err != nil {
+		return nil, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
+		return nil, s.recordFailure(ctx, lockoutKey, ErrIncorrectPassword)
+	}
+	if err := s.lockout.Succeed(ctx, lockoutKey); err != nil {
+		return nil, err
+	}
+
+	// Hash password
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, errors.New("failed to hash password")
+	}
+
+	// Change the password, revoke old sessions and start the new one together
+	var response *dto.AuthResponse
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.userRepo.UpdatePassword(ctx, user.UserID, string(hashedPassword)); err != nil {
+			return err
+		}
+
+		if err := s.RevokeAllSessions(ctx, user.UserID); err != nil {
+			return err
+		}
+
+		// Reload to pick up the new token version
+		user, err := s.userRepo.GetByID(ctx, user.UserID)
+		if err != nil {
+			return err
+		}
+
+		response, _, err = s.issueTokens(ctx, user, uuid.New().String())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
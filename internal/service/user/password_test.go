@@ -0,0 +1,108 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	dto "user_service/internal/dto/user"
+)
+
+func TestResetPassword(t *testing.T) {
+	t.Run("TokenIsSingleUse", func(t *testing.T) {
+		env := newTestEnv(t, EmailVerificationOff)
+		session := env.register(t, "alice@example.com")
+
+		if err := env.auth.ForgotPassword(ctx, &dto.ForgotPasswordRequest{Email: "alice@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		token := env.mail.token(t, "/reset-password")
+
+		if err := env.auth.ResetPassword(ctx, &dto.ResetPasswordRequest{Token: token, Password: "new-secret"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := env.auth.ResetPassword(ctx, &dto.ResetPasswordRequest{Token: token, Password: "other-secret"}); !errors.Is(err, ErrInvalidResetToken) {
+			t.Fatalf("second reset: got %v, want ErrInvalidResetToken", err)
+		}
+
+		if _, err := env.auth.Login(ctx, &dto.LoginRequest{Email: "alice@example.com", Password: "new-secret"}); err != nil {
+			t.Fatalf("login with the new password: %v", err)
+		}
+		if _, err := env.auth.ValidateJWT(ctx, session.Token); !errors.Is(err, ErrTokenRevoked) {
+			t.Fatalf("session from before the reset: got %v, want ErrTokenRevoked", err)
+		}
+	})
+
+	t.Run("NewRequestInvalidatesOldLink", func(t *testing.T) {
+		env := newTestEnv(t, EmailVerificationOff)
+		env.register(t, "alice@example.com")
+
+		if err := env.auth.ForgotPassword(ctx, &dto.ForgotPasswordRequest{Email: "alice@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		old := env.mail.token(t, "/reset-password")
+		if err := env.auth.ForgotPassword(ctx, &dto.ForgotPasswordRequest{Email: "alice@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := env.auth.ResetPassword(ctx, &dto.ResetPasswordRequest{Token: old, Password: "new-secret"}); !errors.Is(err, ErrInvalidResetToken) {
+			t.Fatalf("superseded link: got %v, want ErrInvalidResetToken", err)
+		}
+	})
+
+	t.Run("UnknownEmailSucceedsSilently", func(t *testing.T) {
+		env := newTestEnv(t, EmailVerificationOff)
+		if err := env.auth.ForgotPassword(ctx, &dto.ForgotPasswordRequest{Email: "nobody@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		if len(env.mail.messages) != 0 {
+			t.Fatalf("sent %d messages for an unknown email", len(env.mail.messages))
+		}
+	})
+}
+
+func TestChangePassword(t *testing.T) {
+	t.Run("IssuesNewSession", func(t *testing.T) {
+		env := newTestEnv(t, EmailVerificationOff)
+		old := env.register(t, "alice@example.com")
+
+		session, err := env.auth.ChangePassword(ctx, old.User.UserID, &dto.ChangePasswordRequest{
+			CurrentPassword: testPassword,
+			NewPassword:     "new-secret",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if session.Token == "" || session.RefreshToken == "" {
+			t.Fatalf("got %+v, want a new token pair", session)
+		}
+
+		if _, err := env.auth.ValidateJWT(ctx, session.Token); err != nil {
+			t.Fatalf("new session: %v", err)
+		}
+		if _, err := env.auth.Refresh(ctx, &dto.RefreshRequest{RefreshToken: session.RefreshToken}); err != nil {
+			t.Fatalf("new refresh token: %v", err)
+		}
+		if _, err := env.auth.ValidateJWT(ctx, old.Token); !errors.Is(err, ErrTokenRevoked) {
+			t.Fatalf("old access token: got %v, want ErrTokenRevoked", err)
+		}
+		if _, err := env.auth.Refresh(ctx, &dto.RefreshRequest{RefreshToken: old.RefreshToken}); err == nil {
+			t.Fatal("old refresh token was accepted")
+		}
+	})
+
+	t.Run("WrongCurrentPasswordLocks", func(t *testing.T) {
+		env := newTestEnv(t, EmailVerificationOff)
+		session := env.register(t, "alice@example.com")
+
+		wrong := &dto.ChangePasswordRequest{CurrentPassword: "wrong-password", NewPassword: "new-secret"}
+		for i := 1; i < testMaxFailures; i++ {
+			if _, err := env.auth.ChangePassword(ctx, session.User.UserID, wrong); !errors.Is(err, ErrIncorrectPassword) {
+				t.Fatalf("failure %d: got %v, want ErrIncorrectPassword", i, err)
+			}
+		}
+		_, err := env.auth.ChangePassword(ctx, session.User.UserID, wrong)
+		assertCode(t, err, "account_locked")
+
+		_, err = env.auth.Login(ctx, &dto.LoginRequest{Email: "alice@example.com", Password: testPassword})
+		assertCode(t, err, "account_locked")
+	})
+}
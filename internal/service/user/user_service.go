@@ -1,34 +1,49 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"log"
+	"strings"
+	"time"
+	"user_service/internal/constants"
 	dto "user_service/internal/dto/user"
 	"user_service/internal/models"
 	"user_service/internal/repository"
+	"user_service/internal/storage"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 // UserService handles business logic for users
 type UserService struct {
-	userRepo *repository.UserRepository
+	userRepo         repository.UserRepository
+	conversationRepo repository.ConversationRepository
+	blobs            storage.BlobStore
+	verifier         *EmailVerifier
 }
 
-// NewUserService creates a new user service
-func NewUserService(userRepo *repository.UserRepository) *UserService {
-	return &UserService{userRepo: userRepo}
+// NewUserService creates a new user service. The content of a user's
+// attachments is removed from blobs when the user is deleted.
+func NewUserService(userRepo repository.UserRepository, conversationRepo repository.ConversationRepository, blobs storage.BlobStore, verifier *EmailVerifier) *UserService {
+	return &UserService{
+		userRepo:         userRepo,
+		conversationRepo: conversationRepo,
+		blobs:            blobs,
+		verifier:         verifier,
+	}
 }
 
 // CreateUser creates a new user with business logic validation
-func (s *UserService) CreateUser(req *dto.CreateUserRequest) (*dto.UserResponse, error) {
+func (s *UserService) CreateUser(ctx context.Context, req *dto.CreateUserRequest) (*dto.UserResponse, error) {
 	// Check if email already exists
-	if s.userRepo.EmailExists(req.Email) {
-		return nil, errors.New("email already exists")
+	if s.userRepo.EmailExists(ctx, req.Email) {
+		return nil, ErrEmailExists
 	}
 
 	// Check if username already exists
-	if s.userRepo.UsernameExists(req.Username) {
-		return nil, errors.New("username already exists")
+	if s.userRepo.UsernameExists(ctx, req.Username) {
+		return nil, ErrUsernameExists
 	}
 
 	// Hash password
@@ -37,6 +52,11 @@ func (s *UserService) CreateUser(req *dto.CreateUserRequest) (*dto.UserResponse,
 		return nil, errors.New("failed to hash password")
 	}
 
+	role := req.Role
+	if role == "" {
+		role = constants.UserRoleUser
+	}
+
 	// Create user
 	user := &models.User{
 		Email:     req.Email,
@@ -44,43 +64,55 @@ func (s *UserService) CreateUser(req *dto.CreateUserRequest) (*dto.UserResponse,
 		Password:  string(hashedPassword),
 		FirstName: req.FirstName,
 		LastName:  req.LastName,
+		Role:      role,
+	}
+
+	if err := s.userRepo.Create(ctx, user); err != nil {
+		return nil, err
 	}
 
-	if err := s.userRepo.Create(user); err != nil {
+	if err := s.verifier.SendVerification(ctx, user); err != nil {
 		return nil, err
 	}
 
-	return s.toUserResponse(user), nil
+	return toUserResponse(user), nil
 }
 
 // GetUser retrieves a user by ID
-func (s *UserService) GetUser(id uint) (*dto.UserResponse, error) {
-	user, err := s.userRepo.GetByID(id)
+func (s *UserService) GetUser(ctx context.Context, id uint) (*dto.UserResponse, error) {
+	user, err := s.userRepo.GetByID(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	return s.toUserResponse(user), nil
+	return toUserResponse(user), nil
 }
 
 // UpdateUser updates a user with business logic validation
-func (s *UserService) UpdateUser(id uint, req *dto.UpdateUserRequest) (*dto.UserResponse, error) {
-	user, err := s.userRepo.GetByID(id)
+func (s *UserService) UpdateUser(ctx context.Context, id uint, req *dto.UpdateUserRequest) (*dto.UserResponse, error) {
+	user, err := s.userRepo.GetByID(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
-	// Check email uniqueness if updating
+	// Check email uniqueness if updating; the new address only replaces the
+	// current one once it has been verified
+	emailChanged := false
 	if req.Email != nil && *req.Email != user.Email {
-		if s.userRepo.EmailExists(*req.Email) {
-			return nil, errors.New("email already exists")
+		if s.userRepo.EmailExists(ctx, *req.Email) {
+			return nil, ErrEmailExists
 		}
-		user.Email = *req.Email
+		pendingEmail := *req.Email
+		user.PendingEmail = &pendingEmail
+		emailChanged = true
+	} else if req.Email != nil && user.PendingEmail != nil {
+		// Changing back to the current address cancels the pending change
+		user.PendingEmail = nil
 	}
 
 	// Check username uniqueness if updating
 	if req.Username != nil && *req.Username != user.Username {
-		if s.userRepo.UsernameExists(*req.Username) {
-			return nil, errors.New("username already exists")
+		if s.userRepo.UsernameExists(ctx, *req.Username) {
+			return nil, ErrUsernameExists
 		}
 		user.Username = *req.Username
 	}
@@ -92,33 +124,119 @@ func (s *UserService) UpdateUser(id uint, req *dto.UpdateUserRequest) (*dto.User
 	if req.LastName != nil {
 		user.LastName = *req.LastName
 	}
+	if req.Role != nil {
+		user.Role = *req.Role
+	}
 
-	if err := s.userRepo.Update(user); err != nil {
+	if err := s.userRepo.Update(ctx, user); err != nil {
 		return nil, err
 	}
 
-	return s.toUserResponse(user), nil
+	if emailChanged {
+		if err := s.verifier.SendVerification(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+
+	return toUserResponse(user), nil
 }
 
-// DeleteUser deletes a user
-func (s *UserService) DeleteUser(id uint) error {
+// DeleteUser deletes a user. Their conversations and attachments are deleted
+// with them by the database, and then the content of the attachments.
+func (s *UserService) DeleteUser(ctx context.Context, id uint) error {
 	// Check if user exists
-	if _, err := s.userRepo.GetByID(id); err != nil {
+	if _, err := s.userRepo.GetByID(ctx, id); err != nil {
 		return err
 	}
 
-	return s.userRepo.Delete(id)
+	// List the attachments while their rows still exist. The content of an
+	// upload that completes in between is left behind.
+	attachments, err := s.conversationRepo.ListUserAttachments(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// Outstanding access and refresh tokens stop validating once the user is gone
+	if err := s.userRepo.Delete(ctx, id); err != nil {
+		return err
+	}
+
+	// Failures are only logged: a blob left behind is unreachable storage,
+	// not an error for the caller
+	ctx = context.WithoutCancel(ctx)
+	for _, attachment := range attachments {
+		if err := s.blobs.Delete(ctx, attachment.StorageKey); err != nil {
+			log.Printf("failed to delete blob of attachment %s: %v", attachment.AttachmentID, err)
+		}
+	}
+	return nil
 }
 
 // toUserResponse converts a User model to UserResponse
-func (s *UserService) toUserResponse(user *models.User) *dto.UserResponse {
+func toUserResponse(user *models.User) *dto.UserResponse {
 	return &dto.UserResponse{
-		UserID:    user.UserID,
-		Email:     user.Email,
-		Username:  user.Username,
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
-		CreatedAt: int64(user.CreatedAt.Unix()),
-		UpdatedAt: int64(user.UpdatedAt.Unix()),
+		UserID:        user.UserID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		PendingEmail:  user.PendingEmail,
+		MFAEnabled:    user.MFAEnabledAt != nil,
+		Username:      user.Username,
+		Role:          user.Role,
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		CreatedAt:     int64(user.CreatedAt.Unix()),
+		UpdatedAt:     int64(user.UpdatedAt.Unix()),
 	}
 }
+
+// BootstrapAdmin makes sure an admin account exists for email. An existing
+// user is promoted; otherwise the account is created with password, if given.
+func (s *UserService) BootstrapAdmin(ctx context.Context, email, password string) error {
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err == nil {
+		if user.Role == constants.UserRoleAdmin {
+			return nil
+		}
+		user.Role = constants.UserRoleAdmin
+		if err := s.userRepo.Update(ctx, user); err != nil {
+			return err
+		}
+		log.Printf("Promoted user %d (%s) to admin", user.UserID, email)
+		return nil
+	}
+
+	if password == "" {
+		return errors.New("admin user not found and no bootstrap password set")
+	}
+
+	username := email
+	if at := strings.Index(email, "@"); at > 0 {
+		username = email[:at]
+	}
+
+	created, err := s.CreateUser(ctx, &dto.CreateUserRequest{
+		Email:     email,
+		Username:  username,
+		Password:  password,
+		FirstName: "Admin",
+		LastName:  "User",
+		Role:      constants.UserRoleAdmin,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The operator vouches for the address, so skip email verification
+	user, err = s.userRepo.GetByID(ctx, created.UserID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		return err
+	}
+
+	log.Printf("Created admin user %d (%s)", created.UserID, email)
+	return nil
+}
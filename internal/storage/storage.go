@@ -0,0 +1,38 @@
+// Package storage keeps the content of attachments in a blob store. The
+// database only records their metadata and the key of their content.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"user_service/config"
+)
+
+// ErrBlobNotFound is returned for a key that holds no blob
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore stores blobs by key. Keys are slash-separated paths of letters,
+// digits, dashes and underscores. Implementations must be safe for
+// concurrent use.
+type BlobStore interface {
+	// Put stores the content read from r under key, replacing any blob
+	// already stored there. A blob is either stored whole or not at all.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Open returns the content stored under key, or ErrBlobNotFound
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key. Deleting a missing blob is
+	// not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// New creates the blob store selected by the BLOB_STORE setting
+func New(cfg *config.Config) (BlobStore, error) {
+	switch cfg.BlobStore {
+	case "local", "":
+		return NewLocalBlobStore(cfg.AttachmentDir)
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", cfg.BlobStore)
+	}
+}
@@ -0,0 +1,81 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps attempt counters in process memory. It is suitable for a
+// single server; use PostgresStore when running several instances.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]Attempts
+	sweepAt time.Time
+}
+
+// NewMemoryStore creates a new in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]Attempts)}
+}
+
+// Get returns the current state of a key
+func (s *MemoryStore) Get(ctx context.Context, key string) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.entries[key], nil
+}
+
+// Increment counts an attempt in a fixed window
+func (s *MemoryStore) Increment(ctx context.Context, key string, window time.Duration) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	attempts := s.entries[key]
+	if !now.Before(attempts.WindowEnd) {
+		attempts.Count = 0
+		attempts.WindowEnd = now.Add(window)
+	}
+	attempts.Count++
+	s.entries[key] = attempts
+	return attempts, nil
+}
+
+// Lock locks a key until the given time
+func (s *MemoryStore) Lock(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts := s.entries[key]
+	attempts.LockedUntil = until
+	s.entries[key] = attempts
+	return nil
+}
+
+// Reset clears all state for a key
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// sweep drops entries whose window and lock have both ended. It runs at most
+// once a minute; callers must hold the lock.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Before(s.sweepAt) {
+		return
+	}
+	s.sweepAt = now.Add(time.Minute)
+
+	for key, attempts := range s.entries {
+		if now.After(attempts.WindowEnd) && now.After(attempts.LockedUntil) {
+			delete(s.entries, key)
+		}
+	}
+}
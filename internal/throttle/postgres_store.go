@@ -0,0 +1,108 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+	"user_service/internal/models"
+	"user_service/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+// PostgresStore keeps attempt counters in the login_attempts table so that
+// limits hold across all instances of the service. Called with the context of
+// a repository transaction, it takes part in the transaction, so attempts
+// counted in a unit of work that fails are rolled back with it; count failures
+// outside of it.
+type PostgresStore struct {
+	db *gorm.DB
+
+	mu      sync.Mutex
+	sweepAt time.Time
+}
+
+// NewPostgresStore creates a new database-backed store
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Get returns the current state of a key
+func (s *PostgresStore) Get(ctx context.Context, key string) (Attempts, error) {
+	var row models.LoginAttempt
+	if err := repository.Conn(ctx, s.db).Where("attempt_key = ?", key).First(&row).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Attempts{}, nil
+		}
+		return Attempts{}, err
+	}
+	return toAttempts(&row), nil
+}
+
+// Increment counts an attempt in a fixed window with a single atomic upsert
+func (s *PostgresStore) Increment(ctx context.Context, key string, window time.Duration) (Attempts, error) {
+	now := time.Now()
+	s.sweep(ctx, now)
+
+	var row models.LoginAttempt
+	err := repository.Conn(ctx, s.db).Raw(`
+		INSERT INTO login_attempts (attempt_key, count, window_end)
+		VALUES (?, 1, ?)
+		ON CONFLICT (attempt_key) DO UPDATE SET
+			count = CASE WHEN login_attempts.window_end <= ? THEN 1 ELSE login_attempts.count + 1 END,
+			window_end = CASE WHEN login_attempts.window_end <= ? THEN EXCLUDED.window_end ELSE login_attempts.window_end END
+		RETURNING attempt_key, count, window_end, locked_until`,
+		key, now.Add(window), now, now,
+	).Scan(&row).Error
+	if err != nil {
+		return Attempts{}, err
+	}
+	return toAttempts(&row), nil
+}
+
+// Lock locks a key until the given time
+func (s *PostgresStore) Lock(ctx context.Context, key string, until time.Time) error {
+	return repository.Conn(ctx, s.db).Exec(`
+		INSERT INTO login_attempts (attempt_key, count, window_end, locked_until)
+		VALUES (?, 0, ?, ?)
+		ON CONFLICT (attempt_key) DO UPDATE SET locked_until = EXCLUDED.locked_until`,
+		key, time.Now(), until,
+	).Error
+}
+
+// Reset clears all state for a key
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+	return repository.Conn(ctx, s.db).Where("attempt_key = ?", key).Delete(&models.LoginAttempt{}).Error
+}
+
+// toAttempts converts a database row to Attempts
+func toAttempts(row *models.LoginAttempt) Attempts {
+	attempts := Attempts{
+		Count:     row.Count,
+		WindowEnd: row.WindowEnd,
+	}
+	if row.LockedUntil != nil {
+		attempts.LockedUntil = *row.LockedUntil
+	}
+	return attempts
+}
+
+// sweep deletes rows whose window and lock have both ended. Each instance runs
+// it at most once a minute; failures are only logged.
+func (s *PostgresStore) sweep(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if now.Before(s.sweepAt) {
+		s.mu.Unlock()
+		return
+	}
+	s.sweepAt = now.Add(time.Minute)
+	s.mu.Unlock()
+
+	err := repository.Conn(ctx, s.db).Where("window_end < ? AND (locked_until IS NULL OR locked_until < ?)", now, now).
+		Delete(&models.LoginAttempt{}).Error
+	if err != nil {
+		log.Printf("failed to clean up login attempts: %v", err)
+	}
+}
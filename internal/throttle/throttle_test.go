@@ -0,0 +1,148 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"user_service/config"
+	"user_service/internal/database"
+	"user_service/internal/repository"
+)
+
+var ctx = context.Background()
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("DeniesOverLimit", func(t *testing.T) {
+		limiter := NewRateLimiter(NewMemoryStore())
+
+		for i := 1; i <= 2; i++ {
+			allowed, _, err := limiter.Allow(ctx, "a", 2, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !allowed {
+				t.Fatalf("attempt %d was denied within the limit", i)
+			}
+		}
+		allowed, retryAfter, err := limiter.Allow(ctx, "a", 2, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed {
+			t.Fatal("attempt over the limit was allowed")
+		}
+		if retryAfter <= 0 || retryAfter > time.Minute {
+			t.Fatalf("got retry after %v, want within the window", retryAfter)
+		}
+
+		// Other keys have their own counters
+		if allowed, _, _ := limiter.Allow(ctx, "b", 2, time.Minute); !allowed {
+			t.Fatal("attempt on another key was denied")
+		}
+	})
+
+	t.Run("WindowResets", func(t *testing.T) {
+		limiter := NewRateLimiter(NewMemoryStore())
+		window := 50 * time.Millisecond
+
+		limiter.Allow(ctx, "a", 1, window)
+		if allowed, _, _ := limiter.Allow(ctx, "a", 1, window); allowed {
+			t.Fatal("attempt over the limit was allowed")
+		}
+		time.Sleep(window)
+		if allowed, _, _ := limiter.Allow(ctx, "a", 1, window); !allowed {
+			t.Fatal("attempt in a new window was denied")
+		}
+	})
+}
+
+func TestLockout(t *testing.T) {
+	t.Run("DoublesUpToMax", func(t *testing.T) {
+		lockout := NewLockout(NewMemoryStore(), 3, time.Minute, 4*time.Minute)
+
+		want := []time.Duration{0, 0, time.Minute, 2 * time.Minute, 4 * time.Minute, 4 * time.Minute}
+		for i, delay := range want {
+			got, err := lockout.Fail(ctx, "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != delay {
+				t.Fatalf("failure %d: got lock %v, want %v", i+1, got, delay)
+			}
+		}
+
+		remaining, err := lockout.Check(ctx, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if remaining <= 3*time.Minute || remaining > 4*time.Minute {
+			t.Fatalf("got %v remaining, want the last lock", remaining)
+		}
+		if remaining, _ := lockout.Check(ctx, "b"); remaining != 0 {
+			t.Fatalf("unrelated key is locked for %v", remaining)
+		}
+	})
+
+	t.Run("SucceedResets", func(t *testing.T) {
+		lockout := NewLockout(NewMemoryStore(), 2, time.Minute, time.Hour)
+
+		lockout.Fail(ctx, "a")
+		if err := lockout.Succeed(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+		if delay, _ := lockout.Fail(ctx, "a"); delay != 0 {
+			t.Fatalf("failure after success locked for %v", delay)
+		}
+		if remaining, _ := lockout.Check(ctx, "a"); remaining != 0 {
+			t.Fatalf("got %v remaining, want unlocked", remaining)
+		}
+	})
+}
+
+// TestPostgresStoreJoinsTransactions runs the database store on SQLite, which
+// has a single connection, so a query outside the transaction would wait for
+// the deadline
+func TestPostgresStoreJoinsTransactions(t *testing.T) {
+	db, err := database.InitDB(&config.Config{DatabaseURL: ":memory:"})
+	if err != nil {
+		t.Fatalf("failed to open SQLite database: %v", err)
+	}
+	store := NewPostgresStore(db)
+	transactor := repository.NewTransactor(db)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rollback := errors.New("rollback")
+	err = transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		attempts, err := store.Increment(ctx, "a", time.Minute)
+		if err != nil {
+			return err
+		}
+		if attempts.Count != 1 {
+			t.Errorf("got count %d, want 1", attempts.Count)
+		}
+		if err := store.Lock(ctx, "a", time.Now().Add(time.Minute)); err != nil {
+			return err
+		}
+		if attempts, err = store.Get(ctx, "a"); err != nil {
+			return err
+		}
+		if attempts.LockedUntil.IsZero() {
+			t.Error("lock is not visible within the transaction")
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("got %v, want the rollback error", err)
+	}
+
+	// The attempts were rolled back with the transaction
+	attempts, err := store.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Count != 0 || !attempts.LockedUntil.IsZero() {
+		t.Fatalf("got %+v after the rollback, want no attempts", attempts)
+	}
+}
@@ -6,13 +6,20 @@ import (
 	"os"
 	"user_service/config"
 	"user_service/internal/database"
+	"user_service/internal/mailer"
 	"user_service/internal/middleware"
+	"user_service/internal/migrations"
+	"user_service/internal/repository"
 	userRoutes "user_service/internal/routes/user"
+	userServices "user_service/internal/service/user"
+	"user_service/internal/storage"
+	"user_service/internal/throttle"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	ginadapter "github.com/awslabs/aws-lambda-go-api-proxy/gin"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 var ginLambda *ginadapter.GinLambdaV2
@@ -25,12 +32,52 @@ func main() {
 	// Load configuration
 	cfg := config.Load()
 
+	// Schema migrations: user_service migrate up|down|status|create
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		open := func() (*gorm.DB, error) { return database.Open(cfg) }
+		if err := migrations.Run(os.Args[2:], open, os.Stdout); err != nil {
+			log.Fatal("Migration failed: ", err)
+		}
+		return
+	}
+
 	// Initialize database
 	db, err := database.InitDB(cfg)
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
+	// Initialize mailer
+	mail, err := mailer.New(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize mailer:", err)
+	}
+
+	// Initialize repositories
+	userRepo := repository.NewUserRepository(db)
+	conversationRepo := repository.NewConversationRepository(db)
+
+	// Initialize attachment storage
+	blobStore, err := storage.New(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize blob store:", err)
+	}
+
+	// Make sure the first admin exists
+	if cfg.BootstrapAdminEmail != "" {
+		emailVerifier := userServices.NewEmailVerifier(userRepo, mail, cfg.AppBaseURL, cfg.EmailVerification)
+		userService := userServices.NewUserService(userRepo, conversationRepo, blobStore, emailVerifier)
+		if err := userService.BootstrapAdmin(context.Background(), cfg.BootstrapAdminEmail, cfg.BootstrapAdminPassword); err != nil {
+			log.Fatal("Failed to bootstrap admin user:", err)
+		}
+	}
+
+	// Initialize login throttling store
+	throttleStore, err := throttle.NewStore(cfg.ThrottleStore, db)
+	if err != nil {
+		log.Fatal("Failed to initialize throttle store:", err)
+	}
+
 	// Set Gin mode
 	if cfg.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -42,10 +89,12 @@ func main() {
 	// Add middleware
 	router.Use(middleware.Logger())
 	router.Use(middleware.Recovery())
+	router.Use(middleware.ErrorHandler())
+	router.Use(middleware.Timeout(cfg.RequestTimeout, userRoutes.LongLivedRoutes...))
 	router.Use(middleware.CORS())
 
 	// Setup routes
-	userRoutes.SetupRoutes(router, db)
+	userRoutes.SetupRoutes(router, db, cfg, mail, throttleStore, blobStore, userRepo, conversationRepo)
 
 	// Start server
 	if os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" {